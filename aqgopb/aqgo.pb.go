@@ -0,0 +1,333 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.26.0
+// 	protoc        (unknown)
+// source: aqgo.proto
+
+package aqgopb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	durationpb "google.golang.org/protobuf/types/known/durationpb"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type StreamReadingsRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// If set, only readings from the sensor with this serial number are sent.
+	SensorSerialNumber string `protobuf:"bytes,1,opt,name=sensor_serial_number,json=sensorSerialNumber,proto3" json:"sensor_serial_number,omitempty"`
+}
+
+func (x *StreamReadingsRequest) Reset() {
+	*x = StreamReadingsRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_aqgo_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *StreamReadingsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StreamReadingsRequest) ProtoMessage() {}
+
+func (x *StreamReadingsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_aqgo_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StreamReadingsRequest.ProtoReflect.Descriptor instead.
+func (*StreamReadingsRequest) Descriptor() ([]byte, []int) {
+	return file_aqgo_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *StreamReadingsRequest) GetSensorSerialNumber() string {
+	if x != nil {
+		return x.SensorSerialNumber
+	}
+	return ""
+}
+
+type AirQualityMeasurement struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	SensorSerialNumber string `protobuf:"bytes,1,opt,name=sensor_serial_number,json=sensorSerialNumber,proto3" json:"sensor_serial_number,omitempty"`
+	CoConcentrationPpb int32  `protobuf:"varint,2,opt,name=co_concentration_ppb,json=coConcentrationPpb,proto3" json:"co_concentration_ppb,omitempty"`
+	// temperature_c and relative_humidity are rounded to the nearest whole
+	// number; temperature_c_float and relative_humidity_float are as reported.
+	TemperatureC     int32                  `protobuf:"varint,3,opt,name=temperature_c,json=temperatureC,proto3" json:"temperature_c,omitempty"`
+	RelativeHumidity int32                  `protobuf:"varint,4,opt,name=relative_humidity,json=relativeHumidity,proto3" json:"relative_humidity,omitempty"`
+	Uptime           *durationpb.Duration   `protobuf:"bytes,5,opt,name=uptime,proto3" json:"uptime,omitempty"`
+	MeasurementTime  *timestamppb.Timestamp `protobuf:"bytes,6,opt,name=measurement_time,json=measurementTime,proto3" json:"measurement_time,omitempty"`
+	SensorWarmedUp   bool                   `protobuf:"varint,7,opt,name=sensor_warmed_up,json=sensorWarmedUp,proto3" json:"sensor_warmed_up,omitempty"`
+	// The fields, of co, temperature and humidity, that could not be parsed
+	// from the sensor's response. Their values are zero and should be ignored.
+	InvalidFields         []string `protobuf:"bytes,8,rep,name=invalid_fields,json=invalidFields,proto3" json:"invalid_fields,omitempty"`
+	TemperatureCFloat     float64  `protobuf:"fixed64,9,opt,name=temperature_c_float,json=temperatureCFloat,proto3" json:"temperature_c_float,omitempty"`
+	RelativeHumidityFloat float64  `protobuf:"fixed64,10,opt,name=relative_humidity_float,json=relativeHumidityFloat,proto3" json:"relative_humidity_float,omitempty"`
+}
+
+func (x *AirQualityMeasurement) Reset() {
+	*x = AirQualityMeasurement{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_aqgo_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *AirQualityMeasurement) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AirQualityMeasurement) ProtoMessage() {}
+
+func (x *AirQualityMeasurement) ProtoReflect() protoreflect.Message {
+	mi := &file_aqgo_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AirQualityMeasurement.ProtoReflect.Descriptor instead.
+func (*AirQualityMeasurement) Descriptor() ([]byte, []int) {
+	return file_aqgo_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *AirQualityMeasurement) GetSensorSerialNumber() string {
+	if x != nil {
+		return x.SensorSerialNumber
+	}
+	return ""
+}
+
+func (x *AirQualityMeasurement) GetCoConcentrationPpb() int32 {
+	if x != nil {
+		return x.CoConcentrationPpb
+	}
+	return 0
+}
+
+func (x *AirQualityMeasurement) GetTemperatureC() int32 {
+	if x != nil {
+		return x.TemperatureC
+	}
+	return 0
+}
+
+func (x *AirQualityMeasurement) GetRelativeHumidity() int32 {
+	if x != nil {
+		return x.RelativeHumidity
+	}
+	return 0
+}
+
+func (x *AirQualityMeasurement) GetUptime() *durationpb.Duration {
+	if x != nil {
+		return x.Uptime
+	}
+	return nil
+}
+
+func (x *AirQualityMeasurement) GetMeasurementTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.MeasurementTime
+	}
+	return nil
+}
+
+func (x *AirQualityMeasurement) GetSensorWarmedUp() bool {
+	if x != nil {
+		return x.SensorWarmedUp
+	}
+	return false
+}
+
+func (x *AirQualityMeasurement) GetInvalidFields() []string {
+	if x != nil {
+		return x.InvalidFields
+	}
+	return nil
+}
+
+func (x *AirQualityMeasurement) GetTemperatureCFloat() float64 {
+	if x != nil {
+		return x.TemperatureCFloat
+	}
+	return 0
+}
+
+func (x *AirQualityMeasurement) GetRelativeHumidityFloat() float64 {
+	if x != nil {
+		return x.RelativeHumidityFloat
+	}
+	return 0
+}
+
+var File_aqgo_proto protoreflect.FileDescriptor
+
+var file_aqgo_proto_rawDesc = []byte{
+	0x0a, 0x0a, 0x61, 0x71, 0x67, 0x6f, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x07, 0x61, 0x71,
+	0x67, 0x6f, 0x2e, 0x76, 0x31, 0x1a, 0x1e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2f, 0x70, 0x72,
+	0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2f, 0x64, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x2e,
+	0x70, 0x72, 0x6f, 0x74, 0x6f, 0x1a, 0x1f, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2f, 0x70, 0x72,
+	0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2f, 0x74, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70,
+	0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x22, 0x49, 0x0a, 0x15, 0x53, 0x74, 0x72, 0x65, 0x61, 0x6d,
+	0x52, 0x65, 0x61, 0x64, 0x69, 0x6e, 0x67, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12,
+	0x30, 0x0a, 0x14, 0x73, 0x65, 0x6e, 0x73, 0x6f, 0x72, 0x5f, 0x73, 0x65, 0x72, 0x69, 0x61, 0x6c,
+	0x5f, 0x6e, 0x75, 0x6d, 0x62, 0x65, 0x72, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x12, 0x73,
+	0x65, 0x6e, 0x73, 0x6f, 0x72, 0x53, 0x65, 0x72, 0x69, 0x61, 0x6c, 0x4e, 0x75, 0x6d, 0x62, 0x65,
+	0x72, 0x22, 0x80, 0x04, 0x0a, 0x15, 0x41, 0x69, 0x72, 0x51, 0x75, 0x61, 0x6c, 0x69, 0x74, 0x79,
+	0x4d, 0x65, 0x61, 0x73, 0x75, 0x72, 0x65, 0x6d, 0x65, 0x6e, 0x74, 0x12, 0x30, 0x0a, 0x14, 0x73,
+	0x65, 0x6e, 0x73, 0x6f, 0x72, 0x5f, 0x73, 0x65, 0x72, 0x69, 0x61, 0x6c, 0x5f, 0x6e, 0x75, 0x6d,
+	0x62, 0x65, 0x72, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x12, 0x73, 0x65, 0x6e, 0x73, 0x6f,
+	0x72, 0x53, 0x65, 0x72, 0x69, 0x61, 0x6c, 0x4e, 0x75, 0x6d, 0x62, 0x65, 0x72, 0x12, 0x30, 0x0a,
+	0x14, 0x63, 0x6f, 0x5f, 0x63, 0x6f, 0x6e, 0x63, 0x65, 0x6e, 0x74, 0x72, 0x61, 0x74, 0x69, 0x6f,
+	0x6e, 0x5f, 0x70, 0x70, 0x62, 0x18, 0x02, 0x20, 0x01, 0x28, 0x05, 0x52, 0x12, 0x63, 0x6f, 0x43,
+	0x6f, 0x6e, 0x63, 0x65, 0x6e, 0x74, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x50, 0x70, 0x62, 0x12,
+	0x23, 0x0a, 0x0d, 0x74, 0x65, 0x6d, 0x70, 0x65, 0x72, 0x61, 0x74, 0x75, 0x72, 0x65, 0x5f, 0x63,
+	0x18, 0x03, 0x20, 0x01, 0x28, 0x05, 0x52, 0x0c, 0x74, 0x65, 0x6d, 0x70, 0x65, 0x72, 0x61, 0x74,
+	0x75, 0x72, 0x65, 0x43, 0x12, 0x2b, 0x0a, 0x11, 0x72, 0x65, 0x6c, 0x61, 0x74, 0x69, 0x76, 0x65,
+	0x5f, 0x68, 0x75, 0x6d, 0x69, 0x64, 0x69, 0x74, 0x79, 0x18, 0x04, 0x20, 0x01, 0x28, 0x05, 0x52,
+	0x10, 0x72, 0x65, 0x6c, 0x61, 0x74, 0x69, 0x76, 0x65, 0x48, 0x75, 0x6d, 0x69, 0x64, 0x69, 0x74,
+	0x79, 0x12, 0x31, 0x0a, 0x06, 0x75, 0x70, 0x74, 0x69, 0x6d, 0x65, 0x18, 0x05, 0x20, 0x01, 0x28,
+	0x0b, 0x32, 0x19, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f,
+	0x62, 0x75, 0x66, 0x2e, 0x44, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x52, 0x06, 0x75, 0x70,
+	0x74, 0x69, 0x6d, 0x65, 0x12, 0x45, 0x0a, 0x10, 0x6d, 0x65, 0x61, 0x73, 0x75, 0x72, 0x65, 0x6d,
+	0x65, 0x6e, 0x74, 0x5f, 0x74, 0x69, 0x6d, 0x65, 0x18, 0x06, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a,
+	0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66,
+	0x2e, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x52, 0x0f, 0x6d, 0x65, 0x61, 0x73,
+	0x75, 0x72, 0x65, 0x6d, 0x65, 0x6e, 0x74, 0x54, 0x69, 0x6d, 0x65, 0x12, 0x28, 0x0a, 0x10, 0x73,
+	0x65, 0x6e, 0x73, 0x6f, 0x72, 0x5f, 0x77, 0x61, 0x72, 0x6d, 0x65, 0x64, 0x5f, 0x75, 0x70, 0x18,
+	0x07, 0x20, 0x01, 0x28, 0x08, 0x52, 0x0e, 0x73, 0x65, 0x6e, 0x73, 0x6f, 0x72, 0x57, 0x61, 0x72,
+	0x6d, 0x65, 0x64, 0x55, 0x70, 0x12, 0x25, 0x0a, 0x0e, 0x69, 0x6e, 0x76, 0x61, 0x6c, 0x69, 0x64,
+	0x5f, 0x66, 0x69, 0x65, 0x6c, 0x64, 0x73, 0x18, 0x08, 0x20, 0x03, 0x28, 0x09, 0x52, 0x0d, 0x69,
+	0x6e, 0x76, 0x61, 0x6c, 0x69, 0x64, 0x46, 0x69, 0x65, 0x6c, 0x64, 0x73, 0x12, 0x2e, 0x0a, 0x13,
+	0x74, 0x65, 0x6d, 0x70, 0x65, 0x72, 0x61, 0x74, 0x75, 0x72, 0x65, 0x5f, 0x63, 0x5f, 0x66, 0x6c,
+	0x6f, 0x61, 0x74, 0x18, 0x09, 0x20, 0x01, 0x28, 0x01, 0x52, 0x11, 0x74, 0x65, 0x6d, 0x70, 0x65,
+	0x72, 0x61, 0x74, 0x75, 0x72, 0x65, 0x43, 0x46, 0x6c, 0x6f, 0x61, 0x74, 0x12, 0x36, 0x0a, 0x17,
+	0x72, 0x65, 0x6c, 0x61, 0x74, 0x69, 0x76, 0x65, 0x5f, 0x68, 0x75, 0x6d, 0x69, 0x64, 0x69, 0x74,
+	0x79, 0x5f, 0x66, 0x6c, 0x6f, 0x61, 0x74, 0x18, 0x0a, 0x20, 0x01, 0x28, 0x01, 0x52, 0x15, 0x72,
+	0x65, 0x6c, 0x61, 0x74, 0x69, 0x76, 0x65, 0x48, 0x75, 0x6d, 0x69, 0x64, 0x69, 0x74, 0x79, 0x46,
+	0x6c, 0x6f, 0x61, 0x74, 0x32, 0x60, 0x0a, 0x0a, 0x41, 0x69, 0x72, 0x51, 0x75, 0x61, 0x6c, 0x69,
+	0x74, 0x79, 0x12, 0x52, 0x0a, 0x0e, 0x53, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x52, 0x65, 0x61, 0x64,
+	0x69, 0x6e, 0x67, 0x73, 0x12, 0x1e, 0x2e, 0x61, 0x71, 0x67, 0x6f, 0x2e, 0x76, 0x31, 0x2e, 0x53,
+	0x74, 0x72, 0x65, 0x61, 0x6d, 0x52, 0x65, 0x61, 0x64, 0x69, 0x6e, 0x67, 0x73, 0x52, 0x65, 0x71,
+	0x75, 0x65, 0x73, 0x74, 0x1a, 0x1e, 0x2e, 0x61, 0x71, 0x67, 0x6f, 0x2e, 0x76, 0x31, 0x2e, 0x41,
+	0x69, 0x72, 0x51, 0x75, 0x61, 0x6c, 0x69, 0x74, 0x79, 0x4d, 0x65, 0x61, 0x73, 0x75, 0x72, 0x65,
+	0x6d, 0x65, 0x6e, 0x74, 0x30, 0x01, 0x42, 0x25, 0x5a, 0x23, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62,
+	0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x6a, 0x6b, 0x6f, 0x65, 0x6c, 0x6e, 0x64, 0x6f, 0x72, 0x66, 0x65,
+	0x72, 0x2f, 0x61, 0x71, 0x67, 0x6f, 0x2f, 0x61, 0x71, 0x67, 0x6f, 0x70, 0x62, 0x62, 0x06, 0x70,
+	0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_aqgo_proto_rawDescOnce sync.Once
+	file_aqgo_proto_rawDescData = file_aqgo_proto_rawDesc
+)
+
+func file_aqgo_proto_rawDescGZIP() []byte {
+	file_aqgo_proto_rawDescOnce.Do(func() {
+		file_aqgo_proto_rawDescData = protoimpl.X.CompressGZIP(file_aqgo_proto_rawDescData)
+	})
+	return file_aqgo_proto_rawDescData
+}
+
+var file_aqgo_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_aqgo_proto_goTypes = []interface{}{
+	(*StreamReadingsRequest)(nil), // 0: aqgo.v1.StreamReadingsRequest
+	(*AirQualityMeasurement)(nil), // 1: aqgo.v1.AirQualityMeasurement
+	(*durationpb.Duration)(nil),   // 2: google.protobuf.Duration
+	(*timestamppb.Timestamp)(nil), // 3: google.protobuf.Timestamp
+}
+var file_aqgo_proto_depIdxs = []int32{
+	2, // 0: aqgo.v1.AirQualityMeasurement.uptime:type_name -> google.protobuf.Duration
+	3, // 1: aqgo.v1.AirQualityMeasurement.measurement_time:type_name -> google.protobuf.Timestamp
+	0, // 2: aqgo.v1.AirQuality.StreamReadings:input_type -> aqgo.v1.StreamReadingsRequest
+	1, // 3: aqgo.v1.AirQuality.StreamReadings:output_type -> aqgo.v1.AirQualityMeasurement
+	3, // [3:4] is the sub-list for method output_type
+	2, // [2:3] is the sub-list for method input_type
+	2, // [2:2] is the sub-list for extension type_name
+	2, // [2:2] is the sub-list for extension extendee
+	0, // [0:2] is the sub-list for field type_name
+}
+
+func init() { file_aqgo_proto_init() }
+func file_aqgo_proto_init() {
+	if File_aqgo_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_aqgo_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*StreamReadingsRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_aqgo_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*AirQualityMeasurement); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_aqgo_proto_rawDesc,
+			NumEnums:      0,
+			NumMessages:   2,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_aqgo_proto_goTypes,
+		DependencyIndexes: file_aqgo_proto_depIdxs,
+		MessageInfos:      file_aqgo_proto_msgTypes,
+	}.Build()
+	File_aqgo_proto = out.File
+	file_aqgo_proto_rawDesc = nil
+	file_aqgo_proto_goTypes = nil
+	file_aqgo_proto_depIdxs = nil
+}
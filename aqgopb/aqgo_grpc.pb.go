@@ -0,0 +1,130 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+
+package aqgopb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// AirQualityClient is the client API for AirQuality service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type AirQualityClient interface {
+	// StreamReadings pushes each new reading to the client as it is taken.
+	StreamReadings(ctx context.Context, in *StreamReadingsRequest, opts ...grpc.CallOption) (AirQuality_StreamReadingsClient, error)
+}
+
+type airQualityClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewAirQualityClient(cc grpc.ClientConnInterface) AirQualityClient {
+	return &airQualityClient{cc}
+}
+
+func (c *airQualityClient) StreamReadings(ctx context.Context, in *StreamReadingsRequest, opts ...grpc.CallOption) (AirQuality_StreamReadingsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &AirQuality_ServiceDesc.Streams[0], "/aqgo.v1.AirQuality/StreamReadings", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &airQualityStreamReadingsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type AirQuality_StreamReadingsClient interface {
+	Recv() (*AirQualityMeasurement, error)
+	grpc.ClientStream
+}
+
+type airQualityStreamReadingsClient struct {
+	grpc.ClientStream
+}
+
+func (x *airQualityStreamReadingsClient) Recv() (*AirQualityMeasurement, error) {
+	m := new(AirQualityMeasurement)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// AirQualityServer is the server API for AirQuality service.
+// All implementations must embed UnimplementedAirQualityServer
+// for forward compatibility
+type AirQualityServer interface {
+	// StreamReadings pushes each new reading to the client as it is taken.
+	StreamReadings(*StreamReadingsRequest, AirQuality_StreamReadingsServer) error
+	mustEmbedUnimplementedAirQualityServer()
+}
+
+// UnimplementedAirQualityServer must be embedded to have forward compatible implementations.
+type UnimplementedAirQualityServer struct {
+}
+
+func (UnimplementedAirQualityServer) StreamReadings(*StreamReadingsRequest, AirQuality_StreamReadingsServer) error {
+	return status.Errorf(codes.Unimplemented, "method StreamReadings not implemented")
+}
+func (UnimplementedAirQualityServer) mustEmbedUnimplementedAirQualityServer() {}
+
+// UnsafeAirQualityServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to AirQualityServer will
+// result in compilation errors.
+type UnsafeAirQualityServer interface {
+	mustEmbedUnimplementedAirQualityServer()
+}
+
+func RegisterAirQualityServer(s grpc.ServiceRegistrar, srv AirQualityServer) {
+	s.RegisterService(&AirQuality_ServiceDesc, srv)
+}
+
+func _AirQuality_StreamReadings_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StreamReadingsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(AirQualityServer).StreamReadings(m, &airQualityStreamReadingsServer{stream})
+}
+
+type AirQuality_StreamReadingsServer interface {
+	Send(*AirQualityMeasurement) error
+	grpc.ServerStream
+}
+
+type airQualityStreamReadingsServer struct {
+	grpc.ServerStream
+}
+
+func (x *airQualityStreamReadingsServer) Send(m *AirQualityMeasurement) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// AirQuality_ServiceDesc is the grpc.ServiceDesc for AirQuality service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var AirQuality_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "aqgo.v1.AirQuality",
+	HandlerType: (*AirQualityServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamReadings",
+			Handler:       _AirQuality_StreamReadings_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "aqgo.proto",
+}
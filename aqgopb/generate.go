@@ -0,0 +1,7 @@
+package aqgopb
+
+// This package contains the protobuf and gRPC definitions of the aqgo
+// streaming API. Regenerate it with `go generate`, which requires buf,
+// protoc-gen-go and protoc-gen-go-grpc.
+
+//go:generate buf generate
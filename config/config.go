@@ -0,0 +1,920 @@
+// Package config defines the settings aqgo is configured with, as given by
+// its flags or in the JSON file named by -config.
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jkoelndorfer/aqgo/daemon"
+)
+
+// Config holds every setting of aqgo. It is also the format of the file named
+// by -config: a JSON object naming each setting by its flag, e.g.
+//
+//	{"poll-interval": 10000, "mqtt-broker": "tcp://localhost:1883", "warmup-duration": "1h"}
+//
+// Durations are given as strings such as "90s".
+type Config struct {
+	// PollInterval is -poll-interval: how frequently to poll for and submit
+	// readings, in millseconds.
+	PollInterval int `json:"poll-interval"`
+
+	// SerialDevicePath is -serial-device-path: the location of the serial device
+	// to poll for readings; may also be a tcp://host:port URL, or a named pipe
+	// that responses are read from without sending commands; if omitted, the
+	// device is detected automatically.
+	SerialDevicePath string `json:"serial-device-path"`
+
+	// MetricNamespace is -metric-namespace: the CloudWatch metric namespace for
+	// which to submit readings.
+	MetricNamespace string `json:"metric-namespace"`
+
+	// RegexParser is -regex-parser: parse sensor responses with a regular
+	// expression that tolerates delimiter variation.
+	RegexParser bool `json:"regex-parser"`
+
+	// ResponseRegexp is -response-regexp: a custom regular expression with named
+	// groups (serial, co, temperature, humidity, days, hours, minutes, seconds)
+	// used to parse sensor responses; implies -regex-parser.
+	ResponseRegexp string `json:"response-regexp"`
+
+	// KafkaBrokers is -kafka-brokers: a comma-separated list of Kafka brokers to
+	// publish readings to.
+	KafkaBrokers string `json:"kafka-brokers"`
+
+	// KafkaTopic is -kafka-topic: the Kafka topic to publish readings to.
+	KafkaTopic string `json:"kafka-topic"`
+
+	// KafkaEncoding is -kafka-encoding: the encoding of messages published to
+	// Kafka (json or avro).
+	KafkaEncoding string `json:"kafka-encoding"`
+
+	// SQSQueueURL is -sqs-queue-url: the URL of an SQS queue to send readings
+	// to.
+	SQSQueueURL string `json:"sqs-queue-url"`
+
+	// SQSBatchDelay is -sqs-batch-delay: the longest a reading is held waiting
+	// for a full batch before it is sent to SQS.
+	SQSBatchDelay Duration `json:"sqs-batch-delay"`
+
+	// S3Bucket is -s3-bucket: an S3 bucket to periodically archive readings to.
+	S3Bucket string `json:"s3-bucket"`
+
+	// S3Prefix is -s3-prefix: the key prefix of objects archived to S3.
+	S3Prefix string `json:"s3-prefix"`
+
+	// S3FlushInterval is -s3-flush-interval: how frequently accumulated readings
+	// are uploaded to S3.
+	S3FlushInterval Duration `json:"s3-flush-interval"`
+
+	// IoTEndpoint is -iot-endpoint: the AWS IoT Core data endpoint to publish
+	// readings to.
+	IoTEndpoint string `json:"iot-endpoint"`
+
+	// IoTTopic is -iot-topic: the MQTT topic to publish readings to on AWS IoT
+	// Core.
+	IoTTopic string `json:"iot-topic"`
+
+	// IoTThingName is -iot-thing-name: the AWS IoT thing name; defaults to the
+	// sensor serial number.
+	IoTThingName string `json:"iot-thing-name"`
+
+	// IoTUpdateShadow is -iot-update-shadow: update the reported state of the
+	// device shadow with each reading.
+	IoTUpdateShadow bool `json:"iot-update-shadow"`
+
+	// IoTCert is -iot-cert: the client certificate used to authenticate to AWS
+	// IoT Core.
+	IoTCert string `json:"iot-cert"`
+
+	// IoTKey is -iot-key: the private key of the AWS IoT Core client
+	// certificate.
+	IoTKey string `json:"iot-key"`
+
+	// IoTCA is -iot-ca: the CA certificate used to verify AWS IoT Core.
+	IoTCA string `json:"iot-ca"`
+
+	// GRPCAddr is -grpc-addr: the address on which to serve the gRPC reading
+	// stream, e.g. :50051.
+	GRPCAddr string `json:"grpc-addr"`
+
+	// HTTPAddr is -http-addr: the address on which to serve the live dashboard,
+	// WebSocket feed and /status health summary, e.g. :8080.
+	HTTPAddr string `json:"http-addr"`
+
+	// PushgatewayURL is -pushgateway-url: the URL of a Prometheus Pushgateway to
+	// push readings to.
+	PushgatewayURL string `json:"pushgateway-url"`
+
+	// PushgatewayJob is -pushgateway-job: the job name readings are pushed to
+	// the Pushgateway under.
+	PushgatewayJob string `json:"pushgateway-job"`
+
+	// RemoteWriteURL is -remote-write-url: the URL of a Prometheus remote-write
+	// endpoint to send readings to.
+	RemoteWriteURL string `json:"remote-write-url"`
+
+	// RemoteWriteUsername is -remote-write-username: the basic auth username for
+	// the remote-write endpoint.
+	RemoteWriteUsername string `json:"remote-write-username"`
+
+	// RemoteWritePassword is -remote-write-password: the basic auth password for
+	// the remote-write endpoint.
+	RemoteWritePassword string `json:"remote-write-password"`
+
+	// RemoteWriteBearerToken is -remote-write-bearer-token: the bearer token for
+	// the remote-write endpoint.
+	RemoteWriteBearerToken string `json:"remote-write-bearer-token"`
+
+	// Dedup is -dedup: skip submitting readings whose CO, temperature and
+	// humidity are unchanged from the last submission.
+	Dedup bool `json:"dedup"`
+
+	// DedupMaxInterval is -dedup-max-interval: with -dedup or a minimum delta,
+	// the longest to go without submitting a reading.
+	DedupMaxInterval Duration `json:"dedup-max-interval"`
+
+	// FirmwareInfo is -firmware-info: print the firmware information reported by
+	// the sensor and exit.
+	FirmwareInfo bool `json:"firmware-info"`
+
+	// Calibrate is -calibrate: zero calibrate the sensor and exit; only run this
+	// while the sensor is in air known to be free of CO.
+	Calibrate bool `json:"calibrate"`
+
+	// AutoCalibrateInterval is -auto-calibrate-interval: zero calibrate the
+	// sensor on this interval; only use this if the sensor is in air known to be
+	// free of CO at those times.
+	AutoCalibrateInterval Duration `json:"auto-calibrate-interval"`
+
+	// CloudWatchTimeout is -cloudwatch-timeout: how long to wait for each
+	// CloudWatch PutMetricData call.
+	CloudWatchTimeout Duration `json:"cloudwatch-timeout"`
+
+	// SubmitQueueDepth is -submit-queue-depth: the number of readings to queue
+	// for submission, and for each sink, before the oldest are dropped.
+	SubmitQueueDepth int `json:"submit-queue-depth"`
+
+	// Host is -host: the value of the Host dimension added to CloudWatch
+	// metrics; defaults to the system hostname.
+	Host string `json:"host"`
+
+	// NoHostDimension is -no-host-dimension: do not add a Host dimension to
+	// CloudWatch metrics.
+	NoHostDimension bool `json:"no-host-dimension"`
+
+	// Location is -location: the location of the sensor, added to submitted
+	// metrics as the Location dimension.
+	Location string `json:"location"`
+
+	// LocationMap is -location-map: a comma-separated list of serial=location
+	// pairs giving the location of each sensor; overrides -location.
+	LocationMap string `json:"location-map"`
+
+	// FallbackFile is -fallback-file: a file to which readings are appended as
+	// JSON when they cannot be submitted to CloudWatch.
+	FallbackFile string `json:"fallback-file"`
+
+	// SpoolCompress is -spool-compress: gzip each reading appended to -fallback-
+	// file; read it with zcat, or resubmit it with -replay-spool.
+	SpoolCompress bool `json:"spool-compress"`
+
+	// WarmupSubmitRaw is -warmup-submit-raw: submit CO, temperature and humidity
+	// to CloudWatch while the sensor is warming up; these values are not
+	// trustworthy.
+	WarmupSubmitRaw bool `json:"warmup-submit-raw"`
+
+	// Probe is -probe: take a single reading to verify that a sensor is
+	// attached, then exit with a nonzero status if it could not be read.
+	Probe bool `json:"probe"`
+
+	// ProbeTimeout is -probe-timeout: how long -probe waits for a reading.
+	ProbeTimeout Duration `json:"probe-timeout"`
+
+	// SettleDelay is -settle-delay: how long to wait for the sensor to respond
+	// after sending it a command.
+	SettleDelay Duration `json:"settle-delay"`
+
+	// RequireClockSync is -require-clock-sync: skip submitting readings until
+	// the system clock has been set, for devices without a real-time clock.
+	RequireClockSync bool `json:"require-clock-sync"`
+
+	// MaxMeasurementAge is -max-measurement-age: drop readings older than this
+	// when they are submitted; 0 disables the check.
+	MaxMeasurementAge Duration `json:"max-measurement-age"`
+
+	// Check is -check: verify that AWS credentials permit submitting metrics to
+	// the metric namespace, then exit.
+	Check bool `json:"check"`
+
+	// OpenRetryTimeout is -open-retry-timeout: how long to keep retrying to open
+	// the serial device if it is not yet present.
+	OpenRetryTimeout Duration `json:"open-retry-timeout"`
+
+	// LogGroup is -log-group: a CloudWatch Logs group to record each raw sensor
+	// response to.
+	LogGroup string `json:"log-group"`
+
+	// LogStream is -log-stream: the CloudWatch Logs stream raw sensor responses
+	// are recorded to.
+	LogStream string `json:"log-stream"`
+
+	// LogBatchDelay is -log-batch-delay: the longest a raw response is held
+	// waiting for a full batch before it is sent to CloudWatch Logs.
+	LogBatchDelay Duration `json:"log-batch-delay"`
+
+	// SampleCount is -sample-count: the number of readings to take on each poll
+	// and average into a single submission; readings are taken back to back,
+	// each waiting -settle-delay, so the poll interval should allow for all of
+	// them.
+	SampleCount int `json:"sample-count"`
+
+	// MaxRuntime is -max-runtime: shut down cleanly after running for this long;
+	// 0 runs until interrupted.
+	MaxRuntime Duration `json:"max-runtime"`
+
+	// Stdout is -stdout: write each reading to standard output.
+	Stdout bool `json:"stdout"`
+
+	// StdoutFormat is -stdout-format: the format readings are written to
+	// standard output and the output file in (text, json or csv).
+	StdoutFormat string `json:"stdout-format"`
+
+	// DisplayTimezone is -display-timezone: the time zone in which timestamps
+	// written to standard output are displayed: UTC, Local or an IANA time zone
+	// name.
+	DisplayTimezone string `json:"display-timezone"`
+
+	// SubmitWorkers is -submit-workers: the number of submissions to sinks in
+	// progress at once; each sink takes readings from its own queue, and waits
+	// for a free worker to submit each.
+	SubmitWorkers int `json:"submit-workers"`
+
+	// SerialField is -serial-field: the index of the response field holding the
+	// sensor serial number.
+	SerialField int `json:"serial-field"`
+
+	// SerialOverride is -serial-override: report this serial number for every
+	// reading instead of the one in the sensor response.
+	SerialOverride string `json:"serial-override"`
+
+	// JSONPretty is -json-pretty: with -stdout-format json, indent each reading
+	// rather than writing compact NDJSON.
+	JSONPretty bool `json:"json-pretty"`
+
+	// COMinDeltaPPB is -co-min-delta-ppb: skip submitting readings unless CO has
+	// changed by more than this from the last submission, or temperature or
+	// humidity has changed beyond its own delta.
+	COMinDeltaPPB int `json:"co-min-delta-ppb"`
+
+	// TemperatureMinDeltaC is -temperature-min-delta-c: skip submitting readings
+	// unless temperature has changed by more than this, e.g. 0.5, or another
+	// value has changed beyond its own delta.
+	TemperatureMinDeltaC float64 `json:"temperature-min-delta-c"`
+
+	// HumidityMinDelta is -humidity-min-delta: skip submitting readings unless
+	// relative humidity has changed by more than this, or another value has
+	// changed beyond its own delta.
+	HumidityMinDelta float64 `json:"humidity-min-delta"`
+
+	// IntervalField is -interval-field: the index of the response field holding
+	// the sensor's measurement interval in seconds, for firmware that reports
+	// it; -1 disables.
+	IntervalField int `json:"interval-field"`
+
+	// PressureField is -pressure-field: the index of the response field holding
+	// the barometric pressure in hPa, for variants that report it; it is
+	// submitted as PressurehPa; -1 disables.
+	PressureField int `json:"pressure-field"`
+
+	// VOCField is -voc-field: the index of the response field holding a VOC
+	// index, for variants that report it; it is submitted as VOCIndex; -1
+	// disables.
+	VOCField int `json:"voc-field"`
+
+	// AlignPollInterval is -align-poll-interval: with -interval-field, lengthen
+	// the poll interval to the sensor's measurement interval if it is shorter.
+	AlignPollInterval bool `json:"align-poll-interval"`
+
+	// MalformedRetries is -malformed-retries: how many times to immediately
+	// retry a reading when the sensor response is malformed.
+	MalformedRetries int `json:"malformed-retries"`
+
+	// WarmupDuration is -warmup-duration: how long the sensor must be powered on
+	// before its readings are trusted; 0 trusts readings immediately.
+	WarmupDuration Duration `json:"warmup-duration"`
+
+	// NoWarmupSkip is -no-warmup-skip: trust readings immediately, for sensors
+	// warmed up before deployment; equivalent to -warmup-duration 0.
+	NoWarmupSkip bool `json:"no-warmup-skip"`
+
+	// AWSTargets is -aws-targets: a comma-separated list of
+	// serial=profile/region pairs directing the CloudWatch metrics of each
+	// sensor to the account of a shared config profile and a region; either may
+	// be empty to use the default.
+	AWSTargets string `json:"aws-targets"`
+
+	// Resolution is -resolution: a comma-separated list of metric=seconds pairs
+	// setting the CloudWatch storage resolution of those metrics to 1 (high
+	// resolution) or 60 seconds, e.g. COConcentrationPPB=1,Uptime=60; metrics
+	// default to 1.
+	Resolution string `json:"resolution"`
+
+	// StartupEvent is -startup-event: query the sensor firmware at startup, then
+	// log a summary of the device and configuration and submit a Startup metric
+	// with the first reading.
+	StartupEvent bool `json:"startup-event"`
+
+	// COVarianceWindow is -co-variance-window: if nonzero, submit the moving
+	// variance and z-score of CO over roughly this many readings.
+	COVarianceWindow int `json:"co-variance-window"`
+
+	// DumpFields is -dump-fields: take one reading, print each of its fields
+	// with its index as JSON, and exit.
+	DumpFields bool `json:"dump-fields"`
+
+	// Delimiter is -delimiter: the delimiter between the fields of a sensor
+	// response; Go escape sequences such as \t are interpreted.
+	Delimiter string `json:"delimiter"`
+
+	// PrintConfig is -print-config: print the effective configuration, after
+	// applying the config file, as JSON with secrets redacted, and exit.
+	PrintConfig bool `json:"print-config"`
+
+	// CloudWatchNamespaces is -cloudwatch-namespaces: a comma-separated list of
+	// namespace=profile/region pairs, each submitting all metrics to another
+	// CloudWatch namespace in the account of a shared config profile and a
+	// region, in addition to metric-namespace; either may be empty to use the
+	// default.
+	CloudWatchNamespaces string `json:"cloudwatch-namespaces"`
+
+	// CONegativePolicy is -co-negative-policy: how to submit negative CO
+	// concentrations to CloudWatch: clamp to 0, drop, or pass as reported.
+	CONegativePolicy string `json:"co-negative-policy"`
+
+	// OutputFile is -output-file: if set, append each reading to this file, in
+	// the format given by -stdout-format.
+	OutputFile string `json:"output-file"`
+
+	// FileRotateSize is -file-rotate-size: if nonzero, rotate the output and
+	// fallback files before they exceed this many bytes.
+	FileRotateSize int64 `json:"file-rotate-size"`
+
+	// FileRotateInterval is -file-rotate-interval: if nonzero, rotate the output
+	// and fallback files this often.
+	FileRotateInterval Duration `json:"file-rotate-interval"`
+
+	// FileRotateKeep is -file-rotate-keep: how many rotated output and fallback
+	// files to keep.
+	FileRotateKeep int `json:"file-rotate-keep"`
+
+	// FileRotateGzip is -file-rotate-gzip: gzip the output and fallback files
+	// when they are rotated.
+	FileRotateGzip bool `json:"file-rotate-gzip"`
+
+	// DisableSinks is -disable-sinks: a comma-separated list of sinks to
+	// suppress even though they are configured, e.g. cloudwatch,kafka.
+	DisableSinks string `json:"disable-sinks"`
+
+	// MQTTBroker is -mqtt-broker: if set, publish readings to this MQTT broker,
+	// e.g. tcp://localhost:1883 or ssl://broker:8883.
+	MQTTBroker string `json:"mqtt-broker"`
+
+	// MQTTTopic is -mqtt-topic: the MQTT topic to publish readings to.
+	MQTTTopic string `json:"mqtt-topic"`
+
+	// MQTTClientID is -mqtt-client-id: the MQTT client ID.
+	MQTTClientID string `json:"mqtt-client-id"`
+
+	// MQTTUsername is -mqtt-username: the username to authenticate to the MQTT
+	// broker with.
+	MQTTUsername string `json:"mqtt-username"`
+
+	// MQTTPassword is -mqtt-password: the password to authenticate to the MQTT
+	// broker with.
+	MQTTPassword string `json:"mqtt-password"`
+
+	// MQTTTLSCA is -mqtt-tls-ca: a PEM file of CA certificates to verify the
+	// MQTT broker with, instead of the system roots.
+	MQTTTLSCA string `json:"mqtt-tls-ca"`
+
+	// MQTTTLSCert is -mqtt-tls-cert: a PEM client certificate to authenticate to
+	// the MQTT broker with; requires mqtt-tls-key.
+	MQTTTLSCert string `json:"mqtt-tls-cert"`
+
+	// MQTTTLSKey is -mqtt-tls-key: the PEM private key of mqtt-tls-cert.
+	MQTTTLSKey string `json:"mqtt-tls-key"`
+
+	// MQTTTLSInsecure is -mqtt-tls-insecure: do not verify the MQTT broker's
+	// certificate; only for self-signed development brokers.
+	MQTTTLSInsecure bool `json:"mqtt-tls-insecure"`
+
+	// KeepaliveInterval is -keepalive-interval: with -dedup or a minimum delta,
+	// if nonzero, submit an unchanged reading after this long without a
+	// submission, and log that it was a keepalive.
+	KeepaliveInterval Duration `json:"keepalive-interval"`
+
+	// AdaptivePoll is -adaptive-poll: lengthen the poll interval while CO is
+	// stable and shorten it when CO changes, to save power.
+	AdaptivePoll bool `json:"adaptive-poll"`
+
+	// AdaptivePollMin is -adaptive-poll-min: with -adaptive-poll, the shortest
+	// poll interval, used while CO is changing.
+	AdaptivePollMin Duration `json:"adaptive-poll-min"`
+
+	// AdaptivePollMax is -adaptive-poll-max: with -adaptive-poll, the longest
+	// poll interval, reached while CO is stable.
+	AdaptivePollMax Duration `json:"adaptive-poll-max"`
+
+	// AdaptivePollThresholdPPB is -adaptive-poll-threshold-ppb: with -adaptive-
+	// poll, the largest change in CO between readings that is considered stable.
+	AdaptivePollThresholdPPB int `json:"adaptive-poll-threshold-ppb"`
+
+	// MaxResponseBytes is -max-response-bytes: the longest sensor response
+	// accepted; longer responses are reported as truncated.
+	MaxResponseBytes int `json:"max-response-bytes"`
+
+	// TimestampSource is -timestamp-source: how reading timestamps are assigned:
+	// wallclock uses the system clock; monotonic uses the startup time plus
+	// elapsed time, keeping readings evenly spaced if the clock is stepped but
+	// offset if it was wrong at startup.
+	TimestampSource string `json:"timestamp-source"`
+
+	// Provenance is -provenance: attach to each reading, in JSON output, the raw
+	// responses it was parsed from and how it was derived; for debugging data
+	// quality.
+	Provenance bool `json:"provenance"`
+
+	// SerialDeviceGlob is -serial-device-glob: a glob, e.g. /dev/ttyUSB*,
+	// matched to find the serial device; the first match is opened, and the glob
+	// is matched again whenever the device must be reopened, so that a replaced
+	// adapter is picked up.
+	SerialDeviceGlob string `json:"serial-device-glob"`
+
+	// AlertWebhookURL is -alert-webhook-url: a URL to post a JSON notification
+	// to when the carbon monoxide concentration reaches -alert-co-threshold-ppb;
+	// readings taken while the sensor is warming up never alert unless -alert-
+	// during-warmup is given.
+	AlertWebhookURL string `json:"alert-webhook-url"`
+
+	// AlertCOThresholdPPB is -alert-co-threshold-ppb: the carbon monoxide
+	// concentration, in parts per billion, at which to alert.
+	AlertCOThresholdPPB int `json:"alert-co-threshold-ppb"`
+
+	// AlertDuringWarmup is -alert-during-warmup: alert on readings taken while
+	// the sensor is warming up, which are not accurate.
+	AlertDuringWarmup bool `json:"alert-during-warmup"`
+
+	// AlertNotifyActive is -alert-notify-active: post a monitoring_active
+	// notification to the alert webhook when each sensor completes warm up.
+	AlertNotifyActive bool `json:"alert-notify-active"`
+
+	// ReadRetryDelay is -read-retry-delay: how long to wait before each retry of
+	// a malformed response; retries that would run past the next poll are not
+	// attempted.
+	ReadRetryDelay Duration `json:"read-retry-delay"`
+
+	// Journal is -journal: log readings to the systemd journal with structured
+	// fields such as AQGO_CO_PPB, for querying with journalctl -o json.
+	Journal bool `json:"journal"`
+
+	// SuppressWarmupMetrics is -suppress-warmup-metrics: submit nothing while
+	// the sensor is warming up, not even its uptime and warm-up status, which
+	// are otherwise submitted so that warm up can be followed.
+	SuppressWarmupMetrics bool `json:"suppress-warmup-metrics"`
+
+	// ShutdownTimeout is -shutdown-timeout: on shutdown, how long to wait for
+	// queued readings to be submitted, and then how long each of the HTTP
+	// server, the sink queues, each sink and the state file is given to stop
+	// before its work in progress is cancelled; 0 never cancels it.
+	ShutdownTimeout Duration `json:"shutdown-timeout"`
+
+	// COUnit is -co-unit: the unit to submit CO concentrations to CloudWatch in:
+	// ppb, as COConcentrationPPB, or ppm, as COConcentrationPPM.
+	COUnit string `json:"co-unit"`
+
+	// StartupDelay is -startup-delay: how long after startup to take and discard
+	// readings before submitting any, as the first reads after the device is
+	// opened may be garbage; unlike the warm-up duration, this does not depend
+	// on the sensor's uptime.
+	StartupDelay Duration `json:"startup-delay"`
+
+	// SubmitRawAlongside is -submit-raw-alongside: also submit the CO,
+	// temperature and humidity values reported by the sensor, before
+	// aggregation, clamping or unit conversion, to CloudWatch as metrics
+	// suffixed Raw; this adds to CloudWatch costs.
+	SubmitRawAlongside bool `json:"submit-raw-alongside"`
+
+	// LenientParsing is -lenient-parsing: when the CO concentration, temperature
+	// or humidity of a response cannot be parsed, submit the rest of the reading
+	// and a FieldParseError metric for the field, rather than discarding the
+	// reading.
+	LenientParsing bool `json:"lenient-parsing"`
+
+	// ConfigCheck is -config-check: validate the configuration, create each
+	// configured sink and check that it can connect, e.g. that AWS credentials
+	// are valid and the MQTT broker is reachable, then print a PASS or FAIL line
+	// for each and exit, nonzero if any failed.
+	ConfigCheck bool `json:"config-check"`
+
+	// SubmitUptimeComponents is -submit-uptime-components: also submit the days,
+	// hours, minutes and seconds fields the sensor's uptime is computed from to
+	// CloudWatch, as UptimeDays, UptimeHours, UptimeMinutes and UptimeSeconds,
+	// for debugging odd uptimes.
+	SubmitUptimeComponents bool `json:"submit-uptime-components"`
+
+	// ArchiveFormat is -archive-format: the format of objects archived to S3:
+	// ndjson, gzip-compressed with every field of each reading, or parquet, with
+	// the columns of the csv stdout format, for querying with Athena or Spark.
+	ArchiveFormat string `json:"archive-format"`
+
+	// StateFile is -state-file: a JSON file persisting state across restarts:
+	// when each sensor was last zero calibrated, by -calibrate or -auto-
+	// calibrate-interval, which is then submitted as SecondsSinceCalibration,
+	// and whether each sensor had warmed up.
+	StateFile string `json:"state-file"`
+
+	// CalibrationStateFile is -calibration-state-file: deprecated; use -state-
+	// file, which reads files written with this flag.
+	CalibrationStateFile string `json:"calibration-state-file"`
+
+	// DelimiterAuto is -delimiter-auto: detect the field delimiter from the
+	// first reading, trying ", ", ",", ";", tab and space in turn, for fleets
+	// with differing firmware.
+	DelimiterAuto bool `json:"delimiter-auto"`
+
+	// NATSURL is -nats-url: a NATS server URL, e.g. nats://localhost:4222, to
+	// publish readings to as JSON.
+	NATSURL string `json:"nats-url"`
+
+	// NATSSubject is -nats-subject: the NATS subject readings are published to;
+	// {serial} is replaced with the sensor serial number.
+	NATSSubject string `json:"nats-subject"`
+
+	// NATSToken is -nats-token: the token to authenticate to the NATS server
+	// with.
+	NATSToken string `json:"nats-token"`
+
+	// NATSCreds is -nats-creds: a NATS credentials file to authenticate to the
+	// NATS server with.
+	NATSCreds string `json:"nats-creds"`
+
+	// COPercentiles is -co-percentiles: a comma-separated list of percentiles,
+	// e.g. 50,95,99, of each sensor's CO concentration over -co-percentile-
+	// window to submit with each reading, as COConcentrationPPBp95 and so on.
+	COPercentiles string `json:"co-percentiles"`
+
+	// COPercentileWindow is -co-percentile-window: the sliding window -co-
+	// percentiles are computed over.
+	COPercentileWindow Duration `json:"co-percentile-window"`
+
+	// Read is -read: take a single reading, print it in the -format and exit.
+	Read bool `json:"read"`
+
+	// Format is -format: the format -read prints the reading in (human, json or
+	// csv).
+	Format string `json:"format"`
+
+	// FlushAlign is -flush-align: flush readings to S3 at each multiple of
+	// -s3-flush-interval in wall-clock time, e.g. at the top of each hour,
+	// rather than an interval after the previous flush.
+	FlushAlign bool `json:"flush-align"`
+
+	// MaxDatumsPerRequest is -max-datums-per-request: the most datums sent to
+	// CloudWatch in a single PutMetricData request, from 1 to 1000; smaller
+	// requests are quicker and use less memory each, but more of them are made.
+	MaxDatumsPerRequest int `json:"max-datums-per-request"`
+
+	// HoldLastValue is -hold-last-value: if nonzero, resubmit the last good
+	// reading when a read fails, for up to this long after it was taken; held
+	// readings are submitted with a Stale metric of 1 and without SensorAlive,
+	// and with "stale": true in JSON.
+	HoldLastValue Duration `json:"hold-last-value"`
+
+	// ExitOnOpenFailure is -exit-on-open-failure: exit if the serial device
+	// cannot be opened at startup, after -open-retry-timeout; if false, keep
+	// retrying until it can be.
+	ExitOnOpenFailure bool `json:"exit-on-open-failure"`
+
+	// HealthWeightReadSuccess is -health-weight-read-success: the weight in
+	// DeviceHealthScore of the proportion of recent reads that succeeded.
+	HealthWeightReadSuccess float64 `json:"health-weight-read-success"`
+
+	// HealthWeightWarmedUp is -health-weight-warmed-up: the weight in
+	// DeviceHealthScore of the sensor having warmed up.
+	HealthWeightWarmedUp float64 `json:"health-weight-warmed-up"`
+
+	// HealthWeightFresh is -health-weight-fresh: the weight in DeviceHealthScore
+	// of the reading not being stale, as held by -hold-last-value; the score is
+	// the weighted mean of the three, from 0 to 100, and is not submitted if all
+	// weights are 0.
+	HealthWeightFresh float64 `json:"health-weight-fresh"`
+
+	// SkipEcho is -skip-echo: skip empty lines and echoes of the command sent
+	// that precede the sensor's response, as some serial setups produce.
+	SkipEcho bool `json:"skip-echo"`
+
+	// ReferenceURL is -reference-url: an HTTP endpoint returning the CO
+	// concentration in ppb measured by a nearby reference monitor, as a JSON
+	// number or the co_ppb field of a JSON object; warmed up readings less it
+	// are submitted as CODeltaFromReference.
+	ReferenceURL string `json:"reference-url"`
+
+	// ReferenceInterval is -reference-interval: how often -reference-url is
+	// polled; its value is reused for the readings in between.
+	ReferenceInterval Duration `json:"reference-interval"`
+
+	// MinUptimeBeforeSubmit is -min-uptime-before-submit: if nonzero, submit
+	// nothing at all, not even uptime or read failures, until the sensor has
+	// been up this long; unlike -warmup-duration, which is about whether
+	// readings can be trusted, this avoids the unparseable responses some
+	// firmware produces just after power on.
+	MinUptimeBeforeSubmit Duration `json:"min-uptime-before-submit"`
+
+	// Environment is -environment: a deployment label, e.g. dev, staging or
+	// prod, added to CloudWatch metrics as the Environment dimension and to JSON
+	// readings, including those published over MQTT, as environment; up to 64
+	// letters, digits, '.', '_' and '-'.
+	Environment string `json:"environment"`
+
+	// ReplayPath is -replay-path: submit the sensor responses saved one per line
+	// in this file, timestamped as they are read, instead of reading a sensor.
+	ReplayPath string `json:"replay-path"`
+
+	// ReplayFollow is -replay-follow: keep reading -replay-path as it grows,
+	// like tail -f, rather than exiting at its end; the file may be truncated or
+	// rotated.
+	ReplayFollow bool `json:"replay-follow"`
+
+	// ReplaySpool is -replay-spool: resubmit the readings saved in this
+	// -fallback-file, compressed or not, to the sinks that failed to submit
+	// them, instead of reading a sensor; records left partially written by a
+	// crash are skipped.
+	ReplaySpool string `json:"replay-spool"`
+
+	// DeviceAlias is -device-alias: a comma-separated list of device=alias
+	// pairs; readings from the sensor on each device are submitted with the
+	// alias in place of the serial number, to tell apart sensors that report the
+	// same one.
+	DeviceAlias string `json:"device-alias"`
+
+	// SerialRegistryDir is -serial-registry-dir: the directory, shared by every
+	// aqgo on the host, in which the serial number read from each device is
+	// recorded, to detect sensors that report the same one; it should be
+	// writable only by the users aqgo runs as; if empty, duplicates are not
+	// detected.
+	SerialRegistryDir string `json:"serial-registry-dir"`
+
+	// StaticMetric is -static-metric: a name=value CloudWatch metric submitted
+	// with every reading, e.g. DeviceCount=1 to count devices; may be repeated
+	// or comma-separated, and may not use the name of a built-in metric.
+	StaticMetric string `json:"static-metric"`
+}
+
+// Duration is a time.Duration that is encoded in JSON as a string such as
+// "1m30s".
+type Duration time.Duration
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("expected a duration such as \"90s\", got %s", b)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// fields holds the index of each field of Config, by setting name.
+var fields = func() map[string]int {
+	fields := map[string]int{}
+	t := reflect.TypeOf(Config{})
+	for i := 0; i < t.NumField(); i++ {
+		fields[t.Field(i).Tag.Get("json")] = i
+	}
+	return fields
+}()
+
+var durationType = reflect.TypeOf(Duration(0))
+
+func (c *Config) field(name string) (reflect.Value, bool) {
+	i, ok := fields[name]
+	if !ok {
+		return reflect.Value{}, false
+	}
+	return reflect.ValueOf(c).Elem().Field(i), true
+}
+
+// Get returns the value of the setting name, formatted as its flag is given,
+// and whether there is such a setting.
+func (c *Config) Get(name string) (string, bool) {
+	v, ok := c.field(name)
+	if !ok {
+		return "", false
+	}
+	if v.Type() == durationType {
+		return Duration(v.Int()).String(), true
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), true
+	case reflect.Int, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), true
+	case reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'g', -1, 64), true
+	}
+	return v.String(), true
+}
+
+// Set sets the setting name from value, given as its flag is.
+func (c *Config) Set(name, value string) error {
+	v, ok := c.field(name)
+	if !ok {
+		return fmt.Errorf("unknown setting %q", name)
+	}
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	default:
+		v.SetString(value)
+	}
+	return nil
+}
+
+// Load reads the settings in the JSON object in the file at path into c,
+// leaving the others unchanged, and returns the names of those it read. A
+// *daemon.ValidationError lists each setting that is unknown or whose value
+// has the wrong type; the others are still read.
+func (c *Config) Load(path string) ([]string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	values := map[string]json.RawMessage{}
+	if err := json.Unmarshal(b, &values); err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	read := []string{}
+	problems := []string{}
+	for _, name := range names {
+		v, ok := c.field(name)
+		if !ok {
+			problems = append(problems, fmt.Sprintf("unknown setting %q", name))
+			continue
+		}
+		if err := json.Unmarshal(values[name], v.Addr().Interface()); err != nil {
+			problems = append(problems, fmt.Sprintf("invalid %s: %s", name, err))
+			continue
+		}
+		read = append(read, name)
+	}
+	if len(problems) > 0 {
+		return read, &daemon.ValidationError{Problems: problems}
+	}
+	return read, nil
+}
+
+// AWS_REGION_PATTERN matches the names of AWS regions, such as us-east-1.
+var AWS_REGION_PATTERN = regexp.MustCompile(`^[a-z]{2}(-[a-z]+)+-[0-9]+$`)
+
+// Validate checks that each configured sink has the settings it requires,
+// and that settings that only apply to a sink are not given without it. It
+// returns a *daemon.ValidationError describing every problem found.
+func (c *Config) Validate() error {
+	problems := []string{}
+	missingArguments := []string{}
+	oneShot := c.FirmwareInfo || c.Calibrate || c.Probe || c.Check || c.DumpFields || c.ConfigCheck || c.Read
+	if c.MetricNamespace == "" && (!oneShot || c.Check || c.ConfigCheck) && !c.PrintConfig {
+		missingArguments = append(missingArguments, "metric-namespace")
+	}
+	if c.KafkaBrokers != "" && c.KafkaTopic == "" {
+		missingArguments = append(missingArguments, "kafka-topic")
+	}
+	if c.LogGroup != "" && c.LogStream == "" {
+		missingArguments = append(missingArguments, "log-stream")
+	}
+	if c.IoTEndpoint != "" {
+		if c.IoTCert == "" {
+			missingArguments = append(missingArguments, "iot-cert")
+		}
+		if c.IoTKey == "" {
+			missingArguments = append(missingArguments, "iot-key")
+		}
+		if c.IoTCA == "" {
+			missingArguments = append(missingArguments, "iot-ca")
+		}
+	}
+	if len(missingArguments) > 0 {
+		problems = append(problems, fmt.Sprint("missing required argument(s): ", strings.Join(missingArguments, ", ")))
+	}
+	for _, sink := range []struct {
+		name     string
+		set      bool
+		settings map[string]bool
+	}{
+		{"mqtt-broker", c.MQTTBroker != "", map[string]bool{"mqtt-username": c.MQTTUsername != "", "mqtt-password": c.MQTTPassword != "", "mqtt-tls-ca": c.MQTTTLSCA != "", "mqtt-tls-cert": c.MQTTTLSCert != "", "mqtt-tls-key": c.MQTTTLSKey != "", "mqtt-tls-insecure": c.MQTTTLSInsecure}},
+		{"kafka-brokers", c.KafkaBrokers != "", map[string]bool{"kafka-topic": c.KafkaTopic != ""}},
+		{"iot-endpoint", c.IoTEndpoint != "", map[string]bool{"iot-thing-name": c.IoTThingName != "", "iot-update-shadow": c.IoTUpdateShadow}},
+		{"s3-bucket", c.S3Bucket != "", map[string]bool{"s3-prefix": c.S3Prefix != ""}},
+		{"remote-write-url", c.RemoteWriteURL != "", map[string]bool{"remote-write-username": c.RemoteWriteUsername != "", "remote-write-password": c.RemoteWritePassword != "", "remote-write-bearer-token": c.RemoteWriteBearerToken != ""}},
+		{"nats-url", c.NATSURL != "", map[string]bool{"nats-token": c.NATSToken != "", "nats-creds": c.NATSCreds != ""}},
+		{"log-group", c.LogGroup != "", map[string]bool{"log-stream": c.LogStream != ""}},
+	} {
+		if sink.set {
+			continue
+		}
+		given := []string{}
+		for name, set := range sink.settings {
+			if set {
+				given = append(given, name)
+			}
+		}
+		if len(given) > 0 {
+			sort.Strings(given)
+			problems = append(problems, fmt.Sprintf("%s given without %s", strings.Join(given, ", "), sink.name))
+		}
+	}
+	if c.RemoteWriteUsername != "" && c.RemoteWriteBearerToken != "" {
+		problems = append(problems, "remote-write-username and remote-write-bearer-token are mutually exclusive")
+	}
+	if (c.MQTTTLSCert == "") != (c.MQTTTLSKey == "") {
+		problems = append(problems, "mqtt-tls-cert and mqtt-tls-key must be given together")
+	}
+	if c.NATSURL != "" && c.NATSSubject == "" {
+		problems = append(problems, "nats-subject must not be empty")
+	}
+	if c.NATSToken != "" && c.NATSCreds != "" {
+		problems = append(problems, "nats-token and nats-creds are mutually exclusive")
+	}
+	for _, list := range []struct {
+		name    string
+		targets string
+	}{
+		{"aws-targets", c.AWSTargets},
+		{"cloudwatch-namespaces", c.CloudWatchNamespaces},
+	} {
+		for _, target := range strings.Split(list.targets, ",") {
+			kv := strings.SplitN(target, "=", 2)
+			if len(kv) != 2 || !strings.Contains(kv[1], "/") {
+				// reported when the list is parsed
+				continue
+			}
+			region := kv[1][strings.LastIndex(kv[1], "/")+1:]
+			if region != "" && !AWS_REGION_PATTERN.MatchString(region) {
+				problems = append(problems, fmt.Sprintf("invalid %s: %q for %s is not an AWS region", list.name, region, kv[0]))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return &daemon.ValidationError{Problems: problems}
+	}
+	return nil
+}
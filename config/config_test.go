@@ -0,0 +1,64 @@
+package config
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jkoelndorfer/aqgo/daemon"
+)
+
+func TestLoadReportsEveryProblem(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "aqgo.json")
+	file := `{"poll-interval": 10000, "warmup-duration": "1h", "sample-count": "two", "bogus": 1, "stdout": true}`
+	if err := ioutil.WriteFile(path, []byte(file), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c := Config{SampleCount: 1}
+	names, err := c.Load(path)
+	var invalid *daemon.ValidationError
+	if !errors.As(err, &invalid) || len(invalid.Problems) != 2 {
+		t.Fatalf("Load() = %v, want the unknown setting and the invalid sample-count", err)
+	}
+	if strings.Join(names, ",") != "poll-interval,stdout,warmup-duration" {
+		t.Errorf("read %v, want the valid settings", names)
+	}
+	if c.PollInterval != 10000 || !c.Stdout || time.Duration(c.WarmupDuration) != time.Hour || c.SampleCount != 1 {
+		t.Errorf("loaded %+v", c)
+	}
+	if v, _ := c.Get("warmup-duration"); v != "1h0m0s" {
+		t.Errorf("Get(warmup-duration) = %q, want 1h0m0s", v)
+	}
+}
+
+func TestValidateSinkSettings(t *testing.T) {
+	c := Config{
+		MetricNamespace: "test",
+		MQTTUsername:    "aqgo",
+		MQTTTLSCert:     "cert.pem",
+		IoTEndpoint:     "example.iot.us-east-1.amazonaws.com",
+		IoTCert:         "cert.pem",
+		AWSTargets:      "123456789012=/us-east-1,210987654321=prod/useast1",
+	}
+	var invalid *daemon.ValidationError
+	if err := c.Validate(); !errors.As(err, &invalid) {
+		t.Fatalf("Validate() = %v, want a *daemon.ValidationError", err)
+	}
+	want := []string{
+		"missing required argument(s): iot-key, iot-ca",
+		"mqtt-tls-cert, mqtt-username given without mqtt-broker",
+		"mqtt-tls-cert and mqtt-tls-key must be given together",
+		`invalid aws-targets: "useast1" for 210987654321 is not an AWS region`,
+	}
+	if strings.Join(invalid.Problems, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Validate() problems:\n%s\nwant:\n%s", strings.Join(invalid.Problems, "\n"), strings.Join(want, "\n"))
+	}
+
+	c = Config{MetricNamespace: "test", MQTTBroker: "tcp://localhost:1883", MQTTUsername: "aqgo"}
+	if err := c.Validate(); err != nil {
+		t.Errorf("Validate() = %v for an MQTT sink with a broker", err)
+	}
+}
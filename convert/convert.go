@@ -0,0 +1,78 @@
+package convert
+
+// This package converts sensor measurements between units and derives
+// quantities from them.
+
+import (
+	"math"
+)
+
+// CO_MOLECULAR_WEIGHT is the molecular weight of carbon monoxide in g/mol.
+const CO_MOLECULAR_WEIGHT = 28.01
+
+// MOLAR_VOLUME_L is the molar volume of an ideal gas in liters at 25°C and
+// 1 atm, the reference conditions used for air quality reporting.
+const MOLAR_VOLUME_L = 24.45
+
+func CelsiusToFahrenheit(c float64) float64 {
+	return c*9/5 + 32
+}
+
+func FahrenheitToCelsius(f float64) float64 {
+	return (f - 32) * 5 / 9
+}
+
+func PPBToPPM(ppb float64) float64 {
+	return ppb / 1000
+}
+
+// COPPBToMicrogramsPerCubicMeter converts a CO concentration in parts per
+// billion to µg/m³ at reference conditions.
+func COPPBToMicrogramsPerCubicMeter(ppb float64) float64 {
+	return ppb * CO_MOLECULAR_WEIGHT / MOLAR_VOLUME_L
+}
+
+// DewPointC approximates the dew point in °C from the temperature in °C and
+// the relative humidity in percent using the Magnus formula. It returns NaN
+// if rh is not positive.
+func DewPointC(c, rh float64) float64 {
+	if rh <= 0 {
+		return math.NaN()
+	}
+	const b, l = 17.62, 243.12
+	g := math.Log(rh/100) + b*c/(l+c)
+	return l * g / (b - g)
+}
+
+// coAQIBreakpoints are the US EPA breakpoints for 8-hour CO concentrations
+// in ppm and the corresponding AQI range.
+var coAQIBreakpoints = []struct {
+	cLow, cHigh float64
+	iLow, iHigh float64
+}{
+	{0.0, 4.4, 0, 50},
+	{4.5, 9.4, 51, 100},
+	{9.5, 12.4, 101, 150},
+	{12.5, 15.4, 151, 200},
+	{15.5, 30.4, 201, 300},
+	{30.5, 40.4, 301, 400},
+	{40.5, 50.4, 401, 500},
+}
+
+// COAQI computes the US EPA air quality index for a CO concentration in
+// ppm. The EPA defines the index over 8-hour averages; applying it to
+// instantaneous readings gives an indication only. Concentrations above the
+// highest breakpoint return 500.
+func COAQI(ppm float64) int {
+	if ppm < 0 {
+		ppm = 0
+	}
+	// Concentrations are truncated to one decimal place per EPA guidance.
+	ppm = math.Floor(ppm*10) / 10
+	for _, bp := range coAQIBreakpoints {
+		if ppm <= bp.cHigh {
+			return int(math.Round((bp.iHigh-bp.iLow)/(bp.cHigh-bp.cLow)*(ppm-bp.cLow) + bp.iLow))
+		}
+	}
+	return 500
+}
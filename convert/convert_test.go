@@ -0,0 +1,79 @@
+package convert
+
+import (
+	"math"
+	"testing"
+)
+
+func approx(a, b float64) bool {
+	return math.Abs(a-b) < 1e-6
+}
+
+func TestTemperature(t *testing.T) {
+	for _, tc := range []struct{ c, f float64 }{
+		{0, 32},
+		{100, 212},
+		{-40, -40},
+		{21.5, 70.7},
+	} {
+		if got := CelsiusToFahrenheit(tc.c); !approx(got, tc.f) {
+			t.Errorf("CelsiusToFahrenheit(%g) = %g, want %g", tc.c, got, tc.f)
+		}
+		if got := FahrenheitToCelsius(tc.f); !approx(got, tc.c) {
+			t.Errorf("FahrenheitToCelsius(%g) = %g, want %g", tc.f, got, tc.c)
+		}
+	}
+}
+
+func TestConcentration(t *testing.T) {
+	if got := PPBToPPM(1500); !approx(got, 1.5) {
+		t.Errorf("PPBToPPM(1500) = %g, want 1.5", got)
+	}
+	if got := COPPBToMicrogramsPerCubicMeter(1000); !approx(got, 1145.603272) {
+		t.Errorf("COPPBToMicrogramsPerCubicMeter(1000) = %g, want 1145.603272", got)
+	}
+	if got := COPPBToMicrogramsPerCubicMeter(0); got != 0 {
+		t.Errorf("COPPBToMicrogramsPerCubicMeter(0) = %g, want 0", got)
+	}
+}
+
+func TestDewPointC(t *testing.T) {
+	for _, tc := range []struct{ c, rh, want float64 }{
+		{20, 50, 9.255175},
+		{0, 80, -3.040421},
+		// saturated air is at its dew point
+		{25, 100, 25},
+	} {
+		if got := DewPointC(tc.c, tc.rh); !approx(got, tc.want) {
+			t.Errorf("DewPointC(%g, %g) = %g, want %g", tc.c, tc.rh, got, tc.want)
+		}
+	}
+	for _, rh := range []float64{0, -5} {
+		if got := DewPointC(20, rh); !math.IsNaN(got) {
+			t.Errorf("DewPointC(20, %g) = %g, want NaN", rh, got)
+		}
+	}
+}
+
+func TestCOAQI(t *testing.T) {
+	for _, tc := range []struct {
+		ppm  float64
+		want int
+	}{
+		{-1, 0},
+		{0, 0},
+		{4.4, 50},
+		// truncated to 4.4 rather than rounded to 4.5
+		{4.49, 50},
+		{4.5, 51},
+		{9.4, 100},
+		{9.5, 101},
+		{12.5, 151},
+		{50.4, 500},
+		{60, 500},
+	} {
+		if got := COAQI(tc.ppm); got != tc.want {
+			t.Errorf("COAQI(%g) = %d, want %d", tc.ppm, got, tc.want)
+		}
+	}
+}
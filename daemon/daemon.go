@@ -0,0 +1,895 @@
+package daemon
+
+// This package runs the loop that polls a sensor and submits its readings,
+// so that it can be embedded in other programs.
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/jkoelndorfer/aqgo/iotco1000"
+	"github.com/jkoelndorfer/aqgo/sink"
+)
+
+// MIN_PLAUSIBLE_TIME is the earliest wall clock time considered correct. On
+// devices without a real-time clock, earlier times indicate that the clock
+// has not yet been set by NTP.
+var MIN_PLAUSIBLE_TIME = time.Date(2021, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// POLL_OVERRUN_LOG_INTERVAL is the least time between warnings that readings
+// are taking longer than the poll interval.
+const POLL_OVERRUN_LOG_INTERVAL = time.Minute
+
+// Config configures Run.
+type Config struct {
+	Sensor *iotco1000.IOTCO1000
+
+	// Logger receives status messages. If nil, the standard logger is used.
+	Logger *log.Logger
+
+	// Clock times readings and the delays between them. If nil,
+	// iotco1000.RealClock is used. Polling follows a ticker of the clock.
+	Clock iotco1000.Clock
+
+	PollInterval time.Duration
+
+	// SampleCount readings are taken on each poll and averaged into a single
+	// submission.
+	SampleCount int
+
+	// MalformedRetries is how many times a reading is retried immediately
+	// when the sensor response is malformed, as the next one usually is not.
+	MalformedRetries int
+
+	// ReadRetryDelay is how long to wait before each retry of a malformed
+	// response. Retries stop early rather than run past the next poll.
+	ReadRetryDelay time.Duration
+
+	// AlignPollInterval lengthens the poll interval to the measurement
+	// interval reported by the sensor if it is shorter.
+	AlignPollInterval bool
+
+	// AdaptivePoll lengthens the poll interval, by doubling it up to
+	// AdaptiveMaxInterval, while the CO concentration changes by no more
+	// than AdaptiveCOThreshold ppb between readings, and returns it to
+	// AdaptiveMinInterval as soon as it changes by more. This saves power on
+	// battery-powered deployments. PollInterval is the initial interval.
+	AdaptivePoll        bool
+	AdaptiveMinInterval time.Duration
+	AdaptiveMaxInterval time.Duration
+	AdaptiveCOThreshold int
+
+	// AutoCalibrateInterval, if nonzero, is how often the sensor is zero
+	// calibrated.
+	AutoCalibrateInterval time.Duration
+
+	// State, if set, persists each sensor's calibration time and warm-up
+	// state across restarts, so that readings can report the time since
+	// calibration and warm up completing is reported once.
+	State *State
+
+	// SubmitQueueDepth is the number of readings queued for submission
+	// before the oldest are dropped.
+	SubmitQueueDepth int
+
+	// StartupDelay is how long after Run starts readings are discarded, as
+	// the first reads after the device is opened may be garbage.
+	StartupDelay time.Duration
+
+	// ShutdownTimeout bounds how long Run waits, once ctx is cancelled, for
+	// queued readings to be submitted. If zero, it waits indefinitely.
+	ShutdownTimeout time.Duration
+
+	// WarmUpDuration is the uptime after which readings are considered
+	// trustworthy. If zero, iotco1000.WARM_UP_DURATION is used.
+	WarmUpDuration time.Duration
+
+	// SkipWarmUp trusts readings regardless of uptime, for sensors that
+	// were warmed up before deployment.
+	SkipWarmUp bool
+
+	// SuppressWarmUpMetrics submits nothing for readings taken while the
+	// sensor is warming up, rather than only their uptime and warm-up status.
+	SuppressWarmUpMetrics bool
+
+	// RequireClockSync skips readings taken before MIN_PLAUSIBLE_TIME.
+	RequireClockSync bool
+
+	// MaxMeasurementAge, if nonzero, drops readings older than this when
+	// they are submitted.
+	MaxMeasurementAge time.Duration
+
+	// HealthScoreWeights weigh the components of the DeviceHealthScore
+	// submitted with each reading. If all are zero, no score is computed.
+	HealthScoreWeights HealthScoreWeights
+
+	// MinUptimeBeforeSubmit, if nonzero, submits nothing, neither readings
+	// nor read failures, until the sensor has been up this long, for
+	// firmware whose responses are garbage shortly after power on. Read
+	// failures before the first reading are suppressed for this long after
+	// Run starts, since the sensor may have powered on with the daemon.
+	MinUptimeBeforeSubmit time.Duration
+
+	// Reference, if set, is compared with warmed up readings to compute
+	// their CODeltaFromReference.
+	Reference *Reference
+
+	// HoldLastValue, if nonzero, resubmits the last good reading, marked
+	// stale, in place of each failed read until the reading is this old.
+	HoldLastValue time.Duration
+
+	// Environment labels every reading with the deployment it comes from,
+	// e.g. dev or prod.
+	Environment string
+
+	// SerialRegistry, if set, detects another sensor, read by this or
+	// another daemon on the host, that reports the same serial number.
+	// Readings from such a sensor are logged and marked DuplicateSerial.
+	SerialRegistry *SerialRegistry
+
+	// DeviceAliases, keyed by device path, replace the serial number of
+	// readings from the sensor on that device, so that sensors reporting the
+	// same serial number are submitted separately.
+	DeviceAliases map[string]string
+
+	// Location is the location of every sensor, unless LocationMap, keyed
+	// by serial number, gives another.
+	Location    string
+	LocationMap map[string]string
+
+	// Dedup skips readings whose values are unchanged from the last
+	// submission. The min deltas skip readings whose values changed by no
+	// more than the delta; any delta implies Dedup. Either way a reading is
+	// submitted at least every DedupMaxInterval.
+	Dedup               bool
+	DedupMaxInterval    time.Duration
+	COMinDelta          int
+	TemperatureMinDelta float64
+	HumidityMinDelta    float64
+
+	// KeepaliveInterval, if nonzero, submits an unchanged reading after this
+	// long without a submission, even if DedupMaxInterval has not elapsed,
+	// as proof that the pipeline is healthy.
+	KeepaliveInterval time.Duration
+
+	// COVarianceWindow, if nonzero, tracks the moving variance of each
+	// sensor's warmed up CO readings over roughly this many readings, and
+	// sets COStatistics on them.
+	COVarianceWindow int
+
+	// COPercentiles, if set, are the percentiles of each sensor's warmed up
+	// CO readings over the last COPercentileWindow to set as COPercentiles
+	// on them.
+	COPercentiles      []float64
+	COPercentileWindow time.Duration
+
+	// Provenance sets Provenance on each reading. The sensor must keep raw
+	// responses, e.g. with iotco1000.WithKeepRaw, for them to be included.
+	Provenance bool
+
+	// Reload, if set, delivers replacement configurations while Run is
+	// running. Only the settings used when submitting take effect: the
+	// warm-up duration, clock sync, max measurement age, location and
+	// deduplication settings.
+	Reload <-chan Config
+
+	// OnReload, if set on a configuration received on Reload, is called once
+	// that configuration has been applied. It is called between
+	// submissions, so it may safely reconfigure the sink.
+	OnReload func()
+
+	// OnStartup, if set, is called with the first reading to be submitted,
+	// just before it is submitted with Startup set.
+	OnStartup func(r *sink.Reading)
+}
+
+// readFailure records a failed attempt to take a reading. detached is set
+// if the serial device was found to be gone when it was reopened.
+type readFailure struct {
+	category string
+	time     time.Time
+	detached bool
+}
+
+// ValidationError lists every problem found with a configuration, so that
+// they can all be fixed at once.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration: " + strings.Join(e.Problems, "; ")
+}
+
+// Validate checks that the values of cfg are in range and that a sensor is
+// configured. It returns a *ValidationError describing every problem found.
+func (cfg Config) Validate() error {
+	problems := []string{}
+	if cfg.Sensor == nil {
+		problems = append(problems, "no sensor configured")
+	}
+	if cfg.PollInterval <= 0 {
+		problems = append(problems, "poll interval must be positive")
+	}
+	if cfg.AdaptivePoll && (cfg.AdaptiveMinInterval <= 0 || cfg.AdaptiveMaxInterval < cfg.AdaptiveMinInterval) {
+		problems = append(problems, "adaptive poll intervals must be positive, with the maximum at least the minimum")
+	}
+	if cfg.COMinDelta < 0 || cfg.TemperatureMinDelta < 0 || cfg.HumidityMinDelta < 0 {
+		problems = append(problems, "minimum deltas must not be negative")
+	}
+	if cfg.ShutdownTimeout < 0 {
+		problems = append(problems, "shutdown timeout must not be negative")
+	}
+	if len(problems) > 0 {
+		return &ValidationError{problems}
+	}
+	return nil
+}
+
+// Run polls cfg.Sensor and submits its readings to s until ctx is cancelled.
+// Before returning, it submits the readings that are still queued. Failures
+// to take a reading are submitted to s if it is a sink.ReadFailureSink.
+// Run does not close the sensor or s.
+func Run(ctx context.Context, cfg Config, s sink.MetricSink) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = log.Default()
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = iotco1000.RealClock
+	}
+	if cfg.SampleCount < 1 {
+		cfg.SampleCount = 1
+	}
+	if cfg.SubmitQueueDepth < 1 {
+		cfg.SubmitQueueDepth = 1
+	}
+
+	ch := make(chan *sink.Reading, cfg.SubmitQueueDepth)
+	failures := make(chan readFailure, cfg.SubmitQueueDepth)
+	done := make(chan struct{})
+	// Submissions outlive ctx so that the queue can be drained, until the
+	// shutdown timeout cancels them.
+	submitCtx, cancelSubmit := context.WithCancel(context.Background())
+	defer cancelSubmit()
+	go func() {
+		submitReadings(submitCtx, cfg, s, ch, failures)
+		close(done)
+	}()
+	pollSensor(ctx, cfg, ch, failures)
+
+	// Polling has stopped, so nothing else will be queued. Closing the queue
+	// lets the submission goroutine finish submitting what remains and exit.
+	cfg.Logger.Printf("shutting down; submitting %d queued reading(s)\n", len(ch))
+	close(ch)
+	var timeout <-chan time.Time
+	if cfg.ShutdownTimeout > 0 {
+		timeout = cfg.Clock.After(cfg.ShutdownTimeout)
+	}
+	select {
+	case <-done:
+	case <-timeout:
+		cfg.Logger.Printf("readings were not submitted within shutdown timeout %s; cancelling the submission in progress and dropping %d queued reading(s)\n", cfg.ShutdownTimeout, len(ch))
+		cancelSubmit()
+		<-done
+	}
+	return nil
+}
+
+// pollSensor takes a reading from the sensor on every poll interval and
+// queues it on ch, until ctx is cancelled. Failed readings are queued on
+// failures.
+func pollSensor(ctx context.Context, cfg Config, ch chan *sink.Reading, failures chan readFailure) {
+	logger := cfg.Logger
+	clock := cfg.Clock
+	sensor := cfg.Sensor
+	pollInterval := cfg.PollInterval
+	ticker := clock.NewTicker(pollInterval)
+	defer ticker.Stop()
+	if cfg.SerialRegistry != nil {
+		defer cfg.SerialRegistry.Release()
+	}
+	// duplicates lists the other devices last found reporting the same
+	// serial number, so that a warning is logged whenever it changes.
+	duplicates := ""
+
+	lastCalibration := clock.Now()
+	calibrated := false
+	successes := 0
+	// attached is whether the serial device was open after the last attempt
+	// to open it, and reattached whether it has been opened again since the
+	// last reading was queued.
+	attached, reattached := true, false
+	var lastCO *int
+	var reportedInterval time.Duration
+	// overruns counts the readings since the last warning that took longer
+	// than the poll interval.
+	overruns := 0
+	var lastOverrunLog time.Time
+	startupUntil := clock.Now().Add(cfg.StartupDelay)
+	inStartupDelay := cfg.StartupDelay > 0
+	if inStartupDelay {
+		logger.Printf("discarding readings for startup delay %s\n", cfg.StartupDelay)
+	}
+	for ctx.Err() == nil {
+		start := clock.Now()
+		if cfg.AutoCalibrateInterval > 0 && clock.Now().Sub(lastCalibration) >= cfg.AutoCalibrateInterval {
+			logger.Println("starting scheduled zero calibration; readings are paused until it completes")
+			if err := sensor.Calibrate(); err != nil {
+				logger.Printf("scheduled zero calibration failed: %s\n", err)
+			} else {
+				logger.Println("scheduled zero calibration complete; resuming readings")
+				calibrated = true
+			}
+			lastCalibration = clock.Now()
+		}
+
+		aq, samples, retries, err := sampleAirQuality(cfg, start.Add(pollInterval))
+		overran := clock.Now().Sub(start) > pollInterval
+		var failure readFailure
+		if err != nil {
+			successes = 0
+			failure = readFailure{category: iotco1000.FailureCategory(err), time: clock.Now()}
+		}
+		serialErr := errors.Is(err, iotco1000.ErrReadTimeout) || errors.Is(err, iotco1000.ErrSerialRead) || errors.Is(err, iotco1000.ErrSerialWrite)
+		if serialErr && !sensor.CanReopen() {
+			// the port was given to the sensor, so it is kept
+			logger.Println(err)
+		} else if serialErr {
+			logger.Printf("%s; reopening serial device\n", err)
+			previous := sensor.DevicePath()
+			if err := sensor.Reopen(); err != nil {
+				if attached && errors.Is(err, iotco1000.ErrNoDevice) {
+					logger.Printf("serial device %s detached\n", previous)
+					attached = false
+					failure.detached = true
+				}
+				logger.Printf("failed reopening serial device: %s\n", err)
+			} else if !attached || sensor.DevicePath() != previous {
+				logger.Printf("serial device %s attached\n", sensor.DevicePath())
+				attached = true
+				reattached = true
+			}
+		} else if err != nil {
+			logger.Println(err)
+		} else if inStartupDelay && clock.Now().Before(startupUntil) {
+			logger.Printf("discarding reading during startup delay: co_ppb=%d temperature_c=%d relative_humidity=%d\n", aq.COConcentrationPPB, aq.TemperatureC, aq.RelativeHumidity)
+		} else {
+			if inStartupDelay {
+				logger.Println("startup delay complete; submitting readings")
+				inStartupDelay = false
+			}
+			if aq.ReportedInterval > 0 && aq.ReportedInterval != reportedInterval {
+				reportedInterval = aq.ReportedInterval
+				if pollInterval < reportedInterval {
+					if cfg.AlignPollInterval {
+						logger.Printf("sensor measures every %s; polling at that interval instead of %s\n", reportedInterval, pollInterval)
+						pollInterval = reportedInterval
+						ticker.Reset(pollInterval)
+					} else {
+						logger.Printf("poll interval %s is shorter than the sensor's measurement interval %s; readings will repeat\n", pollInterval, reportedInterval)
+					}
+				}
+			}
+			if len(aq.InvalidFields) > 0 {
+				logger.Printf("failed parsing %s from sensor %s; submitting the rest of the reading\n", strings.Join(aq.InvalidFields, ", "), aq.SensorSerialNumber)
+			}
+			if cfg.AdaptivePoll && aq.FieldValid(iotco1000.FIELD_CO) {
+				if next := adaptPollInterval(cfg, pollInterval, lastCO, aq.COConcentrationPPB); next != pollInterval {
+					if next < pollInterval {
+						logger.Printf("CO changed from %d to %d ppb; polling every %s\n", *lastCO, aq.COConcentrationPPB, next)
+					} else {
+						logger.Printf("CO stable at %d ppb; polling every %s\n", aq.COConcentrationPPB, next)
+					}
+					pollInterval = next
+					ticker.Reset(pollInterval)
+				}
+				co := aq.COConcentrationPPB
+				lastCO = &co
+			}
+			successes++
+			r := &sink.Reading{AirQualityMeasurement: aq, Samples: samples, Calibrated: calibrated, ConsecutiveSuccesses: successes, DeviceAttached: reattached, PollOverrun: overran}
+			alias, aliased := cfg.DeviceAliases[sensor.DevicePath()]
+			if cfg.SerialRegistry != nil {
+				others, err := cfg.SerialRegistry.Register(aq.SensorSerialNumber, sensor.DevicePath())
+				if err != nil {
+					logger.Println(err)
+				}
+				r.DuplicateSerial = len(others) > 0
+				if joined := strings.Join(others, ", "); joined != duplicates {
+					if joined != "" && aliased {
+						logger.Printf("sensor on %s reports serial number %s, as does the sensor on %s; submitting its readings as device alias %s\n", sensor.DevicePath(), aq.SensorSerialNumber, joined, alias)
+					} else if joined != "" {
+						logger.Printf("WARNING: sensor on %s reports serial number %s, as does the sensor on %s; their metrics are combined unless told apart with -device-alias\n", sensor.DevicePath(), aq.SensorSerialNumber, joined)
+					}
+					duplicates = joined
+				}
+			}
+			if aliased {
+				aq.SensorSerialNumber = alias
+			}
+			reattached = false
+			if state := cfg.State; state != nil {
+				if calibrated {
+					if err := state.RecordCalibration(aq.SensorSerialNumber, aq.MeasurementTime); err != nil {
+						logger.Printf("failed saving state: %s\n", err)
+					}
+				}
+				r.LastCalibration = state.LastCalibration(aq.SensorSerialNumber)
+			}
+			if cfg.Provenance {
+				r.Provenance = &sink.Provenance{
+					Aggregated: len(samples) > 1,
+					Calibrated: calibrated,
+					Retries:    retries,
+				}
+				for _, s := range samples {
+					if s.RawResponse != "" {
+						r.Provenance.RawResponses = append(r.Provenance.RawResponses, s.RawResponse)
+					}
+				}
+			}
+			enqueue(ctx, logger, ch, r)
+			calibrated = false
+		}
+		if err != nil {
+			select {
+			case failures <- failure:
+			default:
+			}
+		}
+
+		// Readings are taken on a fixed cadence. If this one overran the poll
+		// interval, skip the tick that was missed rather than reading again
+		// immediately.
+		if elapsed := clock.Now().Sub(start); elapsed > pollInterval {
+			overruns++
+			if clock.Now().Sub(lastOverrunLog) >= POLL_OVERRUN_LOG_INTERVAL {
+				logger.Printf("reading took %s, longer than the poll interval %s; skipping a tick (%d overrun(s) since last warned)\n", elapsed, pollInterval, overruns)
+				lastOverrunLog = clock.Now()
+				overruns = 0
+			}
+			select {
+			case <-ticker.C():
+			default:
+			}
+		}
+		select {
+		case <-ctx.Done():
+		case <-ticker.C():
+		}
+	}
+}
+
+// adaptPollInterval returns the poll interval to use after a reading of co
+// ppb, given that the previous reading, if any, was lastCO.
+func adaptPollInterval(cfg Config, current time.Duration, lastCO *int, co int) time.Duration {
+	if lastCO == nil {
+		return current
+	}
+	if abs(co-*lastCO) > cfg.AdaptiveCOThreshold {
+		return cfg.AdaptiveMinInterval
+	}
+	next := current * 2
+	if next > cfg.AdaptiveMaxInterval {
+		next = cfg.AdaptiveMaxInterval
+	}
+	if next < cfg.AdaptiveMinInterval {
+		next = cfg.AdaptiveMinInterval
+	}
+	return next
+}
+
+// sampleAirQuality takes cfg.SampleCount readings from the sensor and returns
+// their average along with the individual readings and the number of
+// malformed responses retried. Readings that fail are discarded; an error is
+// returned only if all of them fail. No retry is begun that would not
+// complete before deadline.
+func sampleAirQuality(cfg Config, deadline time.Time) (*iotco1000.AirQualityMeasurement, []*iotco1000.AirQualityMeasurement, int, error) {
+	samples := []*iotco1000.AirQualityMeasurement{}
+	totalRetries := 0
+	var lastErr error
+	for i := 0; i < cfg.SampleCount; i++ {
+		aq, retries, err := analyzeAirQuality(cfg, deadline)
+		totalRetries += retries
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		samples = append(samples, aq)
+	}
+	if len(samples) == 0 {
+		return nil, nil, totalRetries, lastErr
+	}
+	return averageMeasurements(samples), samples, totalRetries, nil
+}
+
+// analyzeAirQuality takes a reading from the sensor, retrying up to
+// cfg.MalformedRetries times if the response is malformed, and returns the
+// number of retries made. Each retry waits cfg.ReadRetryDelay, and retries
+// stop once one would finish after deadline.
+func analyzeAirQuality(cfg Config, deadline time.Time) (*iotco1000.AirQualityMeasurement, int, error) {
+	aq, err := cfg.Sensor.AnalyzeAirQuality()
+	if errors.Is(err, iotco1000.ErrResponseTruncated) {
+		cfg.Logger.Printf("warning: %s; the terminator or protocol may not match the sensor's\n", err)
+	}
+	retries := 0
+	for ; retries < cfg.MalformedRetries && errors.Is(err, iotco1000.ErrMalformedResponse); retries++ {
+		// a retry takes at least the settle delay as well, which is not
+		// known here; leave the delay again as margin
+		if cfg.Clock.Now().Add(2 * cfg.ReadRetryDelay).After(deadline) {
+			cfg.Logger.Printf("no time to retry before the next poll; giving up after %d retries\n", retries)
+			return aq, retries, err
+		}
+		cfg.Clock.Sleep(cfg.ReadRetryDelay)
+		aq, err = cfg.Sensor.AnalyzeAirQuality()
+	}
+	if cfg.MalformedRetries > 0 && errors.Is(err, iotco1000.ErrMalformedResponse) {
+		cfg.Logger.Printf("response still malformed after %d retries\n", cfg.MalformedRetries)
+	}
+	return aq, retries, err
+}
+
+// averageMeasurements returns the last of samples with its CO concentration,
+// temperature and relative humidity replaced by the mean across samples.
+func averageMeasurements(samples []*iotco1000.AirQualityMeasurement) *iotco1000.AirQualityMeasurement {
+	aq := *samples[len(samples)-1]
+	for _, s := range samples {
+		aq.Placeholder = aq.Placeholder || s.Placeholder
+	}
+	// Each value is averaged over the samples it was parsed from; it is
+	// invalid only if it was parsed from none of them.
+	aq.InvalidFields = nil
+	average := func(field string, value func(*iotco1000.AirQualityMeasurement) float64) float64 {
+		sum, n := 0.0, 0
+		for _, s := range samples {
+			if s.FieldValid(field) {
+				sum += value(s)
+				n++
+			}
+		}
+		if n == 0 {
+			aq.InvalidFields = append(aq.InvalidFields, field)
+			return 0
+		}
+		return sum / float64(n)
+	}
+	aq.COConcentrationPPB = int(math.Round(average(iotco1000.FIELD_CO, func(m *iotco1000.AirQualityMeasurement) float64 { return float64(m.COConcentrationPPB) })))
+	aq.TemperatureCFloat = average(iotco1000.FIELD_TEMPERATURE, func(m *iotco1000.AirQualityMeasurement) float64 { return m.TemperatureCFloat })
+	aq.RelativeHumidityFloat = average(iotco1000.FIELD_HUMIDITY, func(m *iotco1000.AirQualityMeasurement) float64 { return m.RelativeHumidityFloat })
+	aq.TemperatureC = int(math.Round(aq.TemperatureCFloat))
+	aq.RelativeHumidity = int(math.Round(aq.RelativeHumidityFloat))
+	return &aq
+}
+
+// enqueue queues r for submission without blocking. If the queue is full,
+// the oldest queued reading is dropped to make room so that polling is not
+// held up by a slow sink.
+func enqueue(ctx context.Context, logger *log.Logger, ch chan *sink.Reading, r *sink.Reading) {
+	r.ChannelBacklog = len(ch)
+	select {
+	case ch <- r:
+		return
+	default:
+	}
+	select {
+	case dropped := <-ch:
+		logger.Printf("submission queue is full; dropping reading taken at %s\n", dropped.MeasurementTime)
+		r.DroppedReadings = dropped.DroppedReadings + 1
+	default:
+	}
+	select {
+	case ch <- r:
+	case <-ctx.Done():
+	}
+}
+
+// submitReadings submits each reading queued on ch, and each failure queued
+// on failures, to s until ch is closed or ctx is cancelled.
+func submitReadings(ctx context.Context, cfg Config, s sink.MetricSink, ch chan *sink.Reading, failures chan readFailure) {
+	logger := cfg.Logger
+	dedup := &deduplicator{last: map[string]*sink.Reading{}}
+	dedup.configure(cfg)
+	// warmedUp holds the warm-up state of each sensor's previous reading so
+	// that transitions can be logged as they happen.
+	warmedUp := map[string]bool{}
+	loggedClockNotSynced := false
+	// lastGood is the last reading that was submitted, which is held by
+	// HoldLastValue.
+	var lastGood *sink.Reading
+	health := &healthScore{weights: cfg.HealthScoreWeights}
+	uptime := &uptimeGate{min: cfg.MinUptimeBeforeSubmit, start: cfg.Clock.Now()}
+	var variance *coVariance
+	if cfg.COVarianceWindow > 0 {
+		variance = newCOVariance(cfg.COVarianceWindow)
+	}
+	var percentiles *coPercentiles
+	if len(cfg.COPercentiles) > 0 {
+		percentiles = newCOPercentiles(cfg.COPercentileWindow, cfg.COPercentiles)
+	}
+	for ctx.Err() == nil {
+		var r *sink.Reading
+		select {
+		case <-ctx.Done():
+			return
+		case newCfg := <-cfg.Reload:
+			cfg.WarmUpDuration = newCfg.WarmUpDuration
+			cfg.SkipWarmUp = newCfg.SkipWarmUp
+			cfg.RequireClockSync = newCfg.RequireClockSync
+			cfg.MaxMeasurementAge = newCfg.MaxMeasurementAge
+			cfg.Location = newCfg.Location
+			cfg.LocationMap = newCfg.LocationMap
+			dedup.configure(newCfg)
+			if newCfg.OnReload != nil {
+				newCfg.OnReload()
+			}
+			logger.Println("configuration reloaded")
+			continue
+		case f := <-failures:
+			if fs, ok := s.(sink.ReadFailureSink); ok {
+				if err := fs.SubmitReadFailure(ctx, f.category, f.time); err != nil {
+					logger.Printf("error submitting read failure to %s: %s\n", s.Name(), err)
+				}
+			}
+			if ds, ok := s.(sink.DeviceDetachSink); ok && f.detached {
+				if err := ds.SubmitDeviceDetached(ctx, f.time); err != nil {
+					logger.Printf("error submitting device detachment to %s: %s\n", s.Name(), err)
+				}
+			}
+			health.read(false)
+			if uptime.below(f.time) {
+				continue
+			}
+			if held := holdReading(lastGood, f.time, cfg.HoldLastValue); held != nil {
+				if score, ok := health.score(held.SensorWarmedUp, true); ok {
+					held.HealthScore = &score
+				}
+				if err := s.Submit(ctx, held); err != nil {
+					logger.Printf("error submitting metric data to %s: %s\n", s.Name(), err)
+				}
+			}
+			continue
+		case next, ok := <-ch:
+			if !ok {
+				return
+			}
+			r = next
+			health.read(true)
+		}
+		aq := r.AirQualityMeasurement
+		if uptime.observe(aq) {
+			if !uptime.logged {
+				logger.Printf("sensor %s uptime %s is below min uptime before submit %s; submitting nothing until it is reached\n", aq.SensorSerialNumber, aq.Uptime, uptime.min)
+				uptime.logged = true
+			}
+			continue
+		}
+		if cfg.RequireClockSync {
+			if aq.MeasurementTime.Before(MIN_PLAUSIBLE_TIME) {
+				if !loggedClockNotSynced {
+					logger.Printf("system clock (%s) is not synchronized; skipping metric submission until it is\n", aq.MeasurementTime)
+					loggedClockNotSynced = true
+				}
+				continue
+			} else if loggedClockNotSynced {
+				logger.Println("system clock is synchronized; will submit metrics")
+				loggedClockNotSynced = false
+			}
+		}
+		if age := cfg.Clock.Now().Sub(aq.MeasurementTime); cfg.MaxMeasurementAge > 0 && age > cfg.MaxMeasurementAge {
+			logger.Printf("dropping reading taken at %s; it is %s old, older than max measurement age %s\n", aq.MeasurementTime, age, cfg.MaxMeasurementAge)
+			continue
+		}
+		warmUpDuration := cfg.WarmUpDuration
+		if warmUpDuration == 0 {
+			warmUpDuration = iotco1000.WARM_UP_DURATION
+		}
+		if cfg.SkipWarmUp {
+			warmUpDuration = 0
+		}
+		r.SensorWarmedUp = aq.WarmedUp(warmUpDuration)
+		r.Environment = cfg.Environment
+		r.Location = cfg.Location
+		if location, ok := cfg.LocationMap[aq.SensorSerialNumber]; ok {
+			r.Location = location
+		}
+
+		previous, seen := warmedUp[aq.SensorSerialNumber]
+		// a sensor that was warming up when the daemon last stopped
+		// completes warm up on its first reading after the restart
+		resumedWarmUp := false
+		if !seen && cfg.State != nil {
+			persisted, ok := cfg.State.WarmedUp(aq.SensorSerialNumber)
+			resumedWarmUp = ok && !persisted
+		}
+		if !seen || previous != r.SensorWarmedUp {
+			if r.SensorWarmedUp {
+				r.WarmUpCompleted = seen || resumedWarmUp
+				logger.Printf("sensor %s has been active for warm up duration %s (uptime %s); will submit metrics\n", aq.SensorSerialNumber, warmUpDuration, aq.Uptime)
+			} else if seen {
+				logger.Printf("sensor %s uptime %s is below warm up duration %s; sensor appears to have reset, skipping metric submission\n", aq.SensorSerialNumber, aq.Uptime, warmUpDuration)
+			} else {
+				// sensor readings made when the IOTCO1000 sensor has recently powered on are not accurate
+				logger.Printf("sensor %s has not been active for warm up duration %s (uptime %s); skipping metric submission\n", aq.SensorSerialNumber, warmUpDuration, aq.Uptime)
+			}
+			warmedUp[aq.SensorSerialNumber] = r.SensorWarmedUp
+		}
+		if aq.Placeholder && r.SensorWarmedUp {
+			logger.Printf("sensor %s reported placeholder values (uptime %s); submitting only uptime and warm-up status\n", aq.SensorSerialNumber, aq.Uptime)
+			r.SensorWarmedUp = false
+			r.WarmUpCompleted = false
+			warmedUp[aq.SensorSerialNumber] = false
+		}
+		if cfg.State != nil {
+			if err := cfg.State.RecordWarmedUp(aq.SensorSerialNumber, warmedUp[aq.SensorSerialNumber]); err != nil {
+				logger.Printf("failed saving state: %s\n", err)
+			}
+		}
+
+		if cfg.SuppressWarmUpMetrics && !r.SensorWarmedUp {
+			continue
+		}
+
+		if variance != nil && r.SensorWarmedUp && aq.FieldValid(iotco1000.FIELD_CO) {
+			r.COStatistics = variance.update(aq.SensorSerialNumber, float64(aq.COConcentrationPPB))
+		}
+		if cfg.Reference != nil && r.SensorWarmedUp && aq.FieldValid(iotco1000.FIELD_CO) {
+			if reference, ok := cfg.Reference.Value(); ok {
+				delta := float64(aq.COConcentrationPPB) - reference
+				r.CODeltaFromReference = &delta
+			}
+		}
+		if percentiles != nil && r.SensorWarmedUp && aq.FieldValid(iotco1000.FIELD_CO) {
+			r.COPercentiles = percentiles.update(aq.SensorSerialNumber, aq.MeasurementTime, float64(aq.COConcentrationPPB))
+		}
+
+		if dedup.enabled && !r.Calibrated {
+			skip, keepalive := dedup.duplicate(r)
+			if skip {
+				continue
+			}
+			if keepalive && r.Provenance != nil {
+				r.Provenance.Keepalive = true
+			}
+			if keepalive {
+				logger.Printf("sensor %s readings unchanged for keepalive interval %s; submitting anyway\n", aq.SensorSerialNumber, cfg.KeepaliveInterval)
+			}
+		}
+		if cfg.OnStartup != nil {
+			r.Startup = true
+			cfg.OnStartup(r)
+			cfg.OnStartup = nil
+		}
+		if score, ok := health.score(r.SensorWarmedUp, false); ok {
+			r.HealthScore = &score
+		}
+		lastGood = r
+		if err := s.Submit(ctx, r); err != nil {
+			logger.Printf("error submitting metric data to %s: %s\n", s.Name(), err)
+		}
+	}
+}
+
+// uptimeGate tracks whether the sensor has been up for less than a minimum,
+// estimating its uptime between readings from the last one.
+type uptimeGate struct {
+	min    time.Duration
+	start  time.Time
+	logged bool
+
+	seen     bool
+	uptime   time.Duration
+	uptimeAt time.Time
+}
+
+// observe records the uptime of aq and reports whether it is below the
+// minimum.
+func (g *uptimeGate) observe(aq *iotco1000.AirQualityMeasurement) bool {
+	g.seen = true
+	g.uptime = aq.Uptime
+	g.uptimeAt = aq.MeasurementTime
+	below := g.min > 0 && aq.Uptime < g.min
+	if !below {
+		g.logged = false
+	}
+	return below
+}
+
+// below reports whether the sensor's uptime at t is estimated to be below
+// the minimum.
+func (g *uptimeGate) below(t time.Time) bool {
+	if g.min <= 0 {
+		return false
+	}
+	if !g.seen {
+		return t.Sub(g.start) < g.min
+	}
+	return g.uptime+t.Sub(g.uptimeAt) < g.min
+}
+
+// holdReading returns a copy of r timestamped t and marked stale, to be
+// submitted in place of a reading that failed at t, or nil if there is no
+// reading to hold or it was taken longer than max before t.
+func holdReading(r *sink.Reading, t time.Time, max time.Duration) *sink.Reading {
+	if r == nil || max <= 0 || t.Sub(r.MeasurementTime) > max {
+		return nil
+	}
+	aq := *r.AirQualityMeasurement
+	aq.MeasurementTime = t
+	return &sink.Reading{
+		AirQualityMeasurement: &aq,
+		SensorWarmedUp:        r.SensorWarmedUp,
+		Location:              r.Location,
+		Environment:           r.Environment,
+		Stale:                 true,
+	}
+}
+
+// deduplicator identifies readings whose values are unchanged, or changed by
+// no more than a configured delta, from the last reading submitted for the
+// same sensor.
+type deduplicator struct {
+	enabled bool
+
+	// maxInterval is the longest a sensor may go without a submission, even
+	// if its readings are unchanged.
+	maxInterval time.Duration
+
+	keepalive time.Duration
+
+	coDelta          int
+	temperatureDelta float64
+	humidityDelta    float64
+
+	last map[string]*sink.Reading
+}
+
+func (d *deduplicator) configure(cfg Config) {
+	d.enabled = cfg.Dedup || cfg.COMinDelta > 0 || cfg.TemperatureMinDelta > 0 || cfg.HumidityMinDelta > 0
+	d.maxInterval = cfg.DedupMaxInterval
+	d.keepalive = cfg.KeepaliveInterval
+	d.coDelta = cfg.COMinDelta
+	d.temperatureDelta = cfg.TemperatureMinDelta
+	d.humidityDelta = cfg.HumidityMinDelta
+}
+
+// duplicate reports whether r should be skipped, and whether it is only
+// submitted because the keepalive interval has elapsed. Readings that are not
+// skipped become the basis for subsequent comparisons.
+func (d *deduplicator) duplicate(r *sink.Reading) (skip bool, keepalive bool) {
+	last, ok := d.last[r.SensorSerialNumber]
+	if ok && d.unchanged(last, r) && last.SensorWarmedUp == r.SensorWarmedUp {
+		elapsed := r.MeasurementTime.Sub(last.MeasurementTime)
+		keepalive = d.keepalive > 0 && elapsed >= d.keepalive
+		if elapsed < d.maxInterval && !keepalive {
+			return true, false
+		}
+	}
+	d.last[r.SensorSerialNumber] = r
+	return false, keepalive
+}
+
+// unchanged reports whether r measured the same values as last, or values
+// that differ by no more than the configured deltas. As with SameReading, the
+// temperature and humidity compared are those reported, before rounding.
+func (d *deduplicator) unchanged(last, r *sink.Reading) bool {
+	if d.coDelta == 0 && d.temperatureDelta == 0 && d.humidityDelta == 0 {
+		return last.SameReading(r.AirQualityMeasurement)
+	}
+	return abs(last.COConcentrationPPB-r.COConcentrationPPB) <= d.coDelta &&
+		math.Abs(last.TemperatureCFloat-r.TemperatureCFloat) <= d.temperatureDelta &&
+		math.Abs(last.RelativeHumidityFloat-r.RelativeHumidityFloat) <= d.humidityDelta
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,406 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/jkoelndorfer/aqgo/internal/clocktest"
+	"github.com/jkoelndorfer/aqgo/iotco1000"
+	"github.com/jkoelndorfer/aqgo/sink"
+)
+
+// scriptedPort is an in-memory serial port that answers each command written
+// to it with the next of responses. Once they run out it answers nothing and
+// calls done.
+type scriptedPort struct {
+	mu        sync.Mutex
+	responses []string
+	pending   string
+	done      func()
+}
+
+func (p *scriptedPort) Read(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := copy(b, p.pending)
+	p.pending = p.pending[n:]
+	return n, nil
+}
+
+func (p *scriptedPort) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.responses) == 0 {
+		p.done()
+		return len(b), nil
+	}
+	p.pending, p.responses = p.responses[0], p.responses[1:]
+	return len(b), nil
+}
+
+func (p *scriptedPort) Close() error {
+	return nil
+}
+
+// fakeSink records the readings, read failures and device detachments
+// submitted to it.
+type fakeSink struct {
+	mu       sync.Mutex
+	readings []*sink.Reading
+	failures []string
+	detached int
+}
+
+func (s *fakeSink) Name() string { return "fake" }
+
+func (s *fakeSink) Submit(ctx context.Context, r *sink.Reading) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.readings = append(s.readings, r)
+	return nil
+}
+
+func (s *fakeSink) SubmitReadFailure(ctx context.Context, category string, t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = append(s.failures, category)
+	return nil
+}
+
+func (s *fakeSink) SubmitDeviceDetached(ctx context.Context, t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.detached++
+	return nil
+}
+
+func (s *fakeSink) Close() error { return nil }
+
+// runSession runs the daemon against a sensor that gives responses, one per
+// poll, and returns what was submitted once they have all been read.
+func runSession(t *testing.T, cfg Config, responses ...string) *fakeSink {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	port := &scriptedPort{responses: responses, done: cancel}
+	clock := clocktest.NewFakeClock(time.Date(2024, time.June, 1, 0, 0, 0, 0, time.UTC))
+	cfg.Sensor = iotco1000.NewFromPort(port, iotco1000.WithClock(clock), iotco1000.WithSettleDelay(0))
+	cfg.Logger = log.New(ioutil.Discard, "", 0)
+	if cfg.PollInterval == 0 {
+		cfg.PollInterval = time.Millisecond
+	}
+	s := &fakeSink{}
+	if err := Run(ctx, cfg, s); err != nil {
+		t.Fatal(err)
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		t.Fatal("session did not finish")
+	}
+	return s
+}
+
+func TestRunSession(t *testing.T) {
+	s := runSession(t, Config{},
+		// warming up
+		"123456789012, 2, 21, 39, 27890, 23189, 28405, 00, 01, 00, 00\r\n",
+		"123456789012, garbled\r\n",
+		"ERROR: sensor not ready\r\n",
+		// warmed up
+		"123456789012, 3, 22, 40, 27890, 23189, 28405, 00, 02, 00, 00\r\n",
+	)
+	if len(s.readings) != 2 {
+		t.Fatalf("submitted %d readings, want 2", len(s.readings))
+	}
+	if r := s.readings[0]; r.SensorWarmedUp || r.COConcentrationPPB != 2 {
+		t.Errorf("first reading: warmed up %t, CO %d; want false, 2", r.SensorWarmedUp, r.COConcentrationPPB)
+	}
+	if r := s.readings[1]; !r.SensorWarmedUp || r.COConcentrationPPB != 3 || r.WarmUpCompleted != true {
+		t.Errorf("second reading: warmed up %t, warm up completed %t, CO %d; want true, true, 3", r.SensorWarmedUp, r.WarmUpCompleted, r.COConcentrationPPB)
+	}
+	// the final failure is the poll that found the script exhausted
+	if len(s.failures) < 2 || s.failures[0] != "malformed" || s.failures[1] != "not-ready" {
+		t.Errorf("read failures %v, want malformed and not-ready first", s.failures)
+	}
+}
+
+func TestRunSessionSkipWarmUp(t *testing.T) {
+	s := runSession(t, Config{SkipWarmUp: true, Location: "kitchen"},
+		"123456789012, 2, 21, 39, 27890, 23189, 28405, 00, 00, 01, 00\r\n",
+	)
+	if len(s.readings) != 1 {
+		t.Fatalf("submitted %d readings, want 1", len(s.readings))
+	}
+	if r := s.readings[0]; !r.SensorWarmedUp || r.Location != "kitchen" {
+		t.Errorf("warmed up %t, location %q; want true, kitchen", r.SensorWarmedUp, r.Location)
+	}
+}
+
+// blockingSink is a fakeSink whose first Submit blocks until release is
+// closed, having closed entered.
+type blockingSink struct {
+	fakeSink
+	once    sync.Once
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (s *blockingSink) Submit(ctx context.Context, r *sink.Reading) error {
+	s.once.Do(func() {
+		close(s.entered)
+		<-s.release
+	})
+	return s.fakeSink.Submit(ctx, r)
+}
+
+func TestRunShutdownWithReadingInFlight(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	port := &scriptedPort{
+		responses: []string{
+			"123456789012, 2, 21, 39, 27890, 23189, 28405, 00, 03, 00, 00\r\n",
+			"123456789012, 3, 21, 39, 27890, 23189, 28405, 00, 03, 00, 01\r\n",
+		},
+		done: func() {},
+	}
+	clock := clocktest.NewFakeClock(time.Date(2024, time.June, 1, 0, 0, 0, 0, time.UTC))
+	cfg := Config{
+		Sensor:           iotco1000.NewFromPort(port, iotco1000.WithClock(clock), iotco1000.WithSettleDelay(0)),
+		Logger:           log.New(ioutil.Discard, "", 0),
+		PollInterval:     time.Millisecond,
+		SubmitQueueDepth: 4,
+	}
+	s := &blockingSink{entered: make(chan struct{}), release: make(chan struct{})}
+	returned := make(chan error)
+	go func() {
+		returned <- Run(ctx, cfg, s)
+	}()
+
+	// the first reading is being submitted; let the second be queued behind
+	// it before shutting down
+	<-s.entered
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	select {
+	case <-returned:
+		t.Fatal("Run returned before the reading in flight was submitted")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(s.release)
+	select {
+	case err := <-returned:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after shutdown")
+	}
+	if len(s.readings) != 2 {
+		t.Errorf("submitted %d readings, want the queued reading drained as well", len(s.readings))
+	}
+}
+
+// stuckSink is a fakeSink whose Submit blocks until its context is
+// cancelled.
+type stuckSink struct {
+	fakeSink
+	cancelled chan struct{}
+}
+
+func (s *stuckSink) Submit(ctx context.Context, r *sink.Reading) error {
+	<-ctx.Done()
+	close(s.cancelled)
+	return ctx.Err()
+}
+
+func TestRunShutdownTimeoutCancelsSubmission(t *testing.T) {
+	goroutines := runtime.NumGoroutine()
+	ctx, cancel := context.WithCancel(context.Background())
+	port := &scriptedPort{
+		responses: []string{
+			"123456789012, 2, 21, 39, 27890, 23189, 28405, 00, 03, 00, 00\r\n",
+			"123456789012, 3, 21, 39, 27890, 23189, 28405, 00, 03, 00, 01\r\n",
+		},
+		done: cancel,
+	}
+	clock := clocktest.NewFakeClock(time.Date(2024, time.June, 1, 0, 0, 0, 0, time.UTC))
+	cfg := Config{
+		Sensor:           iotco1000.NewFromPort(port, iotco1000.WithClock(clock), iotco1000.WithSettleDelay(0)),
+		Logger:           log.New(ioutil.Discard, "", 0),
+		PollInterval:     time.Millisecond,
+		SubmitQueueDepth: 4,
+		ShutdownTimeout:  50 * time.Millisecond,
+	}
+	s := &stuckSink{cancelled: make(chan struct{})}
+	returned := make(chan error)
+	go func() {
+		returned <- Run(ctx, cfg, s)
+	}()
+	select {
+	case err := <-returned:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after the shutdown timeout")
+	}
+	select {
+	case <-s.cancelled:
+	default:
+		t.Fatal("Run returned without cancelling the submission in progress")
+	}
+
+	// every goroutine Run started has exited
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > goroutines && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > goroutines {
+		t.Errorf("%d goroutines running after Run returned, want %d", n, goroutines)
+	}
+}
+
+func TestDeduplicatorComparesUnroundedValues(t *testing.T) {
+	reading := func(temperature, humidity float64) *sink.Reading {
+		return &sink.Reading{AirQualityMeasurement: &iotco1000.AirQualityMeasurement{
+			SensorSerialNumber:    "123456789012",
+			COConcentrationPPB:    2,
+			TemperatureC:          int(math.Round(temperature)),
+			RelativeHumidity:      int(math.Round(humidity)),
+			TemperatureCFloat:     temperature,
+			RelativeHumidityFloat: humidity,
+		}}
+	}
+	d := &deduplicator{last: map[string]*sink.Reading{}}
+	d.configure(Config{TemperatureMinDelta: 0.3, HumidityMinDelta: 0.5, DedupMaxInterval: time.Hour})
+	for _, tc := range []struct {
+		temperature, humidity float64
+		skip                  bool
+	}{
+		{21.0, 39.0, false},
+		{21.2, 39.4, true},
+		// rounds to the same temperature, but changed by more than the delta
+		{21.4, 39.0, false},
+		{21.4, 39.6, false},
+	} {
+		if skip, _ := d.duplicate(reading(tc.temperature, tc.humidity)); skip != tc.skip {
+			t.Errorf("%g °C, %g%%: skipped %t, want %t", tc.temperature, tc.humidity, skip, tc.skip)
+		}
+	}
+}
+
+func (s *fakeSink) submitted() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.readings)
+}
+
+func TestRunPollsOnClockTicks(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	port := &scriptedPort{
+		responses: []string{
+			"123456789012, 2, 21, 39, 27890, 23189, 28405, 00, 03, 00, 00\r\n",
+			"123456789012, 3, 21, 39, 27890, 23189, 28405, 00, 03, 00, 01\r\n",
+		},
+		done: func() {},
+	}
+	clock := clocktest.NewFakeClock(time.Date(2024, time.June, 1, 0, 0, 0, 0, time.UTC))
+	cfg := Config{
+		Sensor:       iotco1000.NewFromPort(port, iotco1000.WithClock(clock), iotco1000.WithSettleDelay(0)),
+		Logger:       log.New(ioutil.Discard, "", 0),
+		Clock:        clock,
+		PollInterval: time.Hour,
+	}
+	s := &fakeSink{}
+	returned := make(chan error)
+	go func() {
+		returned <- Run(ctx, cfg, s)
+	}()
+
+	waitFor := func(n int) bool {
+		deadline := time.Now().Add(5 * time.Second)
+		for s.submitted() < n && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		return s.submitted() >= n
+	}
+	if !waitFor(1) {
+		t.Fatal("first reading not submitted")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := s.submitted(); n != 1 {
+		t.Fatalf("submitted %d readings before the clock reached the next poll, want 1", n)
+	}
+	clock.Advance(time.Hour)
+	if !waitFor(2) {
+		t.Fatal("second reading not submitted once the clock reached the next poll")
+	}
+	cancel()
+	if err := <-returned; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConfigValidateReportsEveryProblem(t *testing.T) {
+	cfg := Config{AdaptivePoll: true, AdaptiveMinInterval: time.Minute, AdaptiveMaxInterval: time.Second}
+	var invalid *ValidationError
+	if err := cfg.Validate(); !errors.As(err, &invalid) || len(invalid.Problems) != 3 {
+		t.Fatalf("Validate() = %v, want no sensor, poll interval and adaptive interval problems", err)
+	}
+}
+
+func TestRunKeepsPortAfterTimeout(t *testing.T) {
+	// the sensor times out, then answers on the same port, which cannot be
+	// reopened as it was not opened from a serial device
+	s := runSession(t, Config{}, "", "123456789012, 2, 21, 39, 27890, 23189, 28405, 00, 01, 00, 00\r\n")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.failures) < 1 || s.failures[0] != iotco1000.FailureCategory(iotco1000.ErrReadTimeout) || s.detached != 0 {
+		t.Errorf("submitted read failures %v and %d detachments, want a timeout and no detachment", s.failures, s.detached)
+	}
+	if len(s.readings) != 1 {
+		t.Errorf("submitted %d readings after the timeout, want 1", len(s.readings))
+	}
+}
+
+func TestRunSubmitsDeviceDetachedOnce(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "ttyUSB0")
+	if err := syscall.Mkfifo(path, 0600); err != nil {
+		t.Skipf("cannot create a FIFO: %s", err)
+	}
+	clock := clocktest.NewFakeClock(time.Date(2024, time.June, 1, 0, 0, 0, 0, time.UTC))
+	sensor, err := iotco1000.New(path, iotco1000.WithDeviceGlob(filepath.Join(dir, "ttyUSB*")), iotco1000.WithClock(clock), iotco1000.WithSettleDelay(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the sensor answers nothing, and its device is gone when it is reopened
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	sensor.SerialPort.Close()
+	sensor.SerialPort = &scriptedPort{responses: []string{"", "", ""}, done: cancel}
+	os.Remove(path)
+	s := &fakeSink{}
+	cfg := Config{Sensor: sensor, Logger: log.New(ioutil.Discard, "", 0), PollInterval: time.Millisecond}
+	if err := Run(ctx, cfg, s); err != nil {
+		t.Fatal(err)
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		t.Fatal("session did not finish")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.failures) < 3 || s.detached != 1 {
+		t.Errorf("submitted %d read failures and %d detachments, want at least 3 and one detachment", len(s.failures), s.detached)
+	}
+}
@@ -0,0 +1,66 @@
+package daemon
+
+// HEALTH_SCORE_WINDOW is the number of most recent reads the read success
+// rate of DeviceHealthScore is computed over.
+const HEALTH_SCORE_WINDOW = 20
+
+// HealthScoreWeights weigh the components of a device's health score. Each
+// component is between 0 and 1; the score is their weighted mean, scaled to
+// 0–100.
+type HealthScoreWeights struct {
+	// ReadSuccess weighs the proportion of the last HEALTH_SCORE_WINDOW
+	// reads that succeeded.
+	ReadSuccess float64
+
+	// WarmedUp weighs whether the sensor is warmed up.
+	WarmedUp float64
+
+	// Fresh weighs whether the reading is a measurement, rather than the
+	// last good reading held in place of a failed read.
+	Fresh float64
+}
+
+// DEFAULT_HEALTH_SCORE_WEIGHTS favors read success, since failing reads are
+// the most common sign of a failing device.
+var DEFAULT_HEALTH_SCORE_WEIGHTS = HealthScoreWeights{ReadSuccess: 60, WarmedUp: 20, Fresh: 20}
+
+// healthScore tracks the outcome of recent reads to score a device's health.
+type healthScore struct {
+	weights HealthScoreWeights
+	reads   []bool
+}
+
+// read records whether a read succeeded.
+func (h *healthScore) read(ok bool) {
+	h.reads = append(h.reads, ok)
+	if len(h.reads) > HEALTH_SCORE_WINDOW {
+		h.reads = h.reads[len(h.reads)-HEALTH_SCORE_WINDOW:]
+	}
+}
+
+// score returns the health score, from 0 to 100, of a device whose latest
+// reading is as given, and false if the weights are all zero.
+func (h *healthScore) score(warmedUp, stale bool) (float64, bool) {
+	total := h.weights.ReadSuccess + h.weights.WarmedUp + h.weights.Fresh
+	if total <= 0 {
+		return 0, false
+	}
+	succeeded := 0
+	for _, ok := range h.reads {
+		if ok {
+			succeeded++
+		}
+	}
+	successRate := 1.0
+	if len(h.reads) > 0 {
+		successRate = float64(succeeded) / float64(len(h.reads))
+	}
+	score := h.weights.ReadSuccess * successRate
+	if warmedUp {
+		score += h.weights.WarmedUp
+	}
+	if !stale {
+		score += h.weights.Fresh
+	}
+	return 100 * score / total, true
+}
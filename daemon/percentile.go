@@ -0,0 +1,67 @@
+package daemon
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/jkoelndorfer/aqgo/sink"
+)
+
+// coPercentiles tracks each sensor's CO readings over a sliding time window
+// and computes percentiles of them. The window is kept in full and sorted on
+// each update, which is cheap for the few hundred readings a window of
+// minutes holds at typical poll intervals.
+type coPercentiles struct {
+	window      time.Duration
+	percentiles []float64
+	sensors     map[string][]coSample
+}
+
+type coSample struct {
+	t  time.Time
+	co float64
+}
+
+func newCOPercentiles(window time.Duration, percentiles []float64) *coPercentiles {
+	return &coPercentiles{
+		window:      window,
+		percentiles: percentiles,
+		sensors:     map[string][]coSample{},
+	}
+}
+
+// update adds co, measured at t, to the window of sensor, drops readings that
+// have left the window, and returns the percentiles of those that remain.
+func (p *coPercentiles) update(sensor string, t time.Time, co float64) []sink.COPercentile {
+	samples := append(p.sensors[sensor], coSample{t, co})
+	start := 0
+	for start < len(samples) && t.Sub(samples[start].t) > p.window {
+		start++
+	}
+	samples = samples[start:]
+	p.sensors[sensor] = samples
+
+	sorted := make([]float64, len(samples))
+	for i, s := range samples {
+		sorted[i] = s.co
+	}
+	sort.Float64s(sorted)
+	result := []sink.COPercentile{}
+	for _, percentile := range p.percentiles {
+		result = append(result, sink.COPercentile{
+			Percentile: percentile,
+			Value:      interpolatePercentile(sorted, percentile),
+		})
+	}
+	return result
+}
+
+// interpolatePercentile returns the percentile of sorted, interpolating
+// linearly between the closest ranks.
+func interpolatePercentile(sorted []float64, percentile float64) float64 {
+	rank := percentile / 100 * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	return sorted[lower] + (sorted[upper]-sorted[lower])*(rank-float64(lower))
+}
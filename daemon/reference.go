@@ -0,0 +1,97 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Reference polls an HTTP endpoint for the CO concentration measured by a
+// reference monitor, so that readings can be compared against it. The
+// endpoint responds with the concentration in ppb, either as a bare JSON
+// number or as the co_ppb field of a JSON object.
+type Reference struct {
+	URL      string
+	Interval time.Duration
+	Client   *http.Client
+	Logger   *log.Logger
+
+	mu    sync.Mutex
+	value float64
+	ok    bool
+}
+
+func NewReference(url string, interval time.Duration, logger *log.Logger) *Reference {
+	return &Reference{
+		URL:      url,
+		Interval: interval,
+		Client:   &http.Client{Timeout: 10 * time.Second},
+		Logger:   logger,
+	}
+}
+
+// Run polls the endpoint every Interval until ctx is cancelled.
+func (r *Reference) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+	for {
+		value, err := r.fetch(ctx)
+		r.mu.Lock()
+		wasOK := r.ok
+		r.value, r.ok = value, err == nil
+		r.mu.Unlock()
+		if err != nil && ctx.Err() == nil {
+			r.Logger.Printf("failed fetching reference CO concentration; skipping CODeltaFromReference until it succeeds: %s\n", err)
+		} else if err == nil && !wasOK {
+			r.Logger.Printf("fetched reference CO concentration %g ppb\n", value)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Value returns the reference CO concentration in ppb from the last poll,
+// and false if it failed or none has completed yet.
+func (r *Reference) Value() (float64, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.value, r.ok
+}
+
+func (r *Reference) fetch(ctx context.Context) (float64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.URL, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := r.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("%s returned %s", r.URL, resp.Status)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+	var value float64
+	if err := json.Unmarshal(body, &value); err == nil {
+		return value, nil
+	}
+	var object struct {
+		COPPB *float64 `json:"co_ppb"`
+	}
+	if err := json.Unmarshal(body, &object); err != nil || object.COPPB == nil {
+		return 0, fmt.Errorf("%s returned neither a number nor an object with co_ppb", r.URL)
+	}
+	return *object.COPPB, nil
+}
@@ -0,0 +1,101 @@
+package daemon
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// SerialRegistry records, in a directory shared by every daemon on the host,
+// which device each sensor serial number is being read from, so that two
+// sensors polled at the same time that report the same serial number can be
+// detected. Each claim is a file named for the serial number and device,
+// holding the process ID of the daemon reading it.
+type SerialRegistry struct {
+	Dir string
+
+	pid int
+	// claim is the file claiming the serial number and device last
+	// registered.
+	claim string
+}
+
+// serialFileUnsafe matches the characters of a serial number or device path
+// that are replaced in the name of a claim file.
+var serialFileUnsafe = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+func NewSerialRegistry(dir string) (*SerialRegistry, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed creating serial registry %s: %s", dir, err)
+	}
+	return &SerialRegistry{Dir: dir, pid: os.Getpid()}, nil
+}
+
+// Register claims serial for device and returns the other devices that are
+// being read, by this or another running daemon, with the same serial
+// number.
+func (r *SerialRegistry) Register(serial, device string) ([]string, error) {
+	prefix := serialFileUnsafe.ReplaceAllString(serial, "_") + "@"
+	claim := filepath.Join(r.Dir, prefix+serialFileUnsafe.ReplaceAllString(device, "_"))
+	if claim != r.claim {
+		r.Release()
+		if err := ioutil.WriteFile(claim, []byte(strconv.Itoa(r.pid)+"\n"+device+"\n"), 0644); err != nil {
+			return nil, fmt.Errorf("failed registering serial %s: %s", serial, err)
+		}
+		r.claim = claim
+	}
+	matches, err := filepath.Glob(filepath.Join(r.Dir, prefix+"*"))
+	if err != nil {
+		return nil, err
+	}
+	duplicates := []string{}
+	for _, m := range matches {
+		if m == claim {
+			continue
+		}
+		pid, other, ok := readClaim(m)
+		if !ok || pid == r.pid || !processAlive(pid) {
+			// left behind by a daemon that has exited, or by this one
+			// before its device changed
+			os.Remove(m)
+			continue
+		}
+		duplicates = append(duplicates, other)
+	}
+	return duplicates, nil
+}
+
+// Release withdraws the last claim made by Register.
+func (r *SerialRegistry) Release() {
+	if r.claim != "" {
+		os.Remove(r.claim)
+		r.claim = ""
+	}
+}
+
+// readClaim returns the process ID and device recorded in a claim file.
+func readClaim(path string) (int, string, bool) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, "", false
+	}
+	lines := strings.SplitN(strings.TrimRight(string(b), "\n"), "\n", 2)
+	if len(lines) != 2 {
+		return 0, "", false
+	}
+	pid, err := strconv.Atoi(lines[0])
+	if err != nil {
+		return 0, "", false
+	}
+	return pid, lines[1], true
+}
+
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
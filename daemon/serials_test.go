@@ -0,0 +1,71 @@
+package daemon
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// writeClaim leaves a claim of serial for device by the process pid in dir,
+// as another daemon would.
+func writeClaim(t *testing.T, dir, serial, device string, pid int) string {
+	t.Helper()
+	path := filepath.Join(dir, serial+"@"+serialFileUnsafe.ReplaceAllString(device, "_"))
+	if err := ioutil.WriteFile(path, []byte(strconv.Itoa(pid)+"\n"+device+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// exitedPID returns the process ID of a process that has exited.
+func exitedPID(t *testing.T) int {
+	t.Helper()
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Skipf("cannot run a process: %s", err)
+	}
+	return cmd.Process.Pid
+}
+
+func TestSerialRegistryRegister(t *testing.T) {
+	dir := t.TempDir()
+	r, err := NewSerialRegistry(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	live := writeClaim(t, dir, "123456789012", "/dev/ttyUSB1", os.Getppid())
+	stale := writeClaim(t, dir, "123456789012", "/dev/ttyUSB2", exitedPID(t))
+
+	duplicates, err := r.Register("123456789012", "/dev/ttyUSB0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(duplicates) != 1 || duplicates[0] != "/dev/ttyUSB1" {
+		t.Errorf("Register() = %v, want the device claimed by the live process", duplicates)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("claim of an exited process was not deleted: %v", err)
+	}
+	if _, err := os.Stat(live); err != nil {
+		t.Errorf("claim of a live process was deleted: %s", err)
+	}
+
+	// the serial number is now read from another device
+	first := r.claim
+	os.Remove(live)
+	if duplicates, err := r.Register("123456789012", "/dev/ttyUSB3"); err != nil || len(duplicates) != 0 {
+		t.Errorf("Register() = %v, %v after the device changed, want no duplicates", duplicates, err)
+	}
+	if _, err := os.Stat(first); !os.IsNotExist(err) {
+		t.Errorf("claim of the previous device was not withdrawn: %v", err)
+	}
+
+	claim := r.claim
+	r.Release()
+	if _, err := os.Stat(claim); !os.IsNotExist(err) {
+		t.Errorf("claim was not withdrawn by Release: %v", err)
+	}
+}
@@ -0,0 +1,169 @@
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// STATE_VERSION is the version of the state file schema. It is incremented
+// whenever the schema changes incompatibly, and state files written by older
+// versions are migrated when loaded.
+const STATE_VERSION = 1
+
+// State is the state that persists across restarts, kept in a single JSON
+// file.
+type State struct {
+	Path string
+
+	mu   sync.Mutex
+	file stateFile
+}
+
+type stateFile struct {
+	Version int                     `json:"version"`
+	Sensors map[string]*SensorState `json:"sensors"`
+}
+
+// SensorState is the persisted state of a single sensor.
+type SensorState struct {
+	// LastCalibration is when the sensor was last zero calibrated.
+	LastCalibration time.Time `json:"last_calibration,omitempty"`
+
+	// WarmedUp is whether the sensor was warmed up as of its last reading,
+	// so that warm up completing is only reported once across restarts.
+	WarmedUp *bool `json:"warmed_up,omitempty"`
+}
+
+// LoadState reads the state from the file at path. A file that does not
+// exist yet is treated as empty. A file written before the schema was
+// versioned, which held only calibration times, is migrated. A file written
+// by a newer version is an error, rather than being overwritten.
+func LoadState(path string) (*State, error) {
+	s := &State{
+		Path: path,
+		file: stateFile{Version: STATE_VERSION, Sensors: map[string]*SensorState{}},
+	}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var version struct {
+		Version int `json:"version"`
+	}
+	if err := json.Unmarshal(b, &version); err != nil {
+		return nil, err
+	}
+	switch {
+	case version.Version == 0:
+		calibrations := map[string]time.Time{}
+		if err := json.Unmarshal(b, &calibrations); err != nil {
+			return nil, fmt.Errorf("unrecognized state file %s: %s", path, err)
+		}
+		for serial, t := range calibrations {
+			s.file.Sensors[serial] = &SensorState{LastCalibration: t}
+		}
+	case version.Version > STATE_VERSION:
+		return nil, fmt.Errorf("state file %s has version %d; this version of aqgo understands up to version %d", path, version.Version, STATE_VERSION)
+	default:
+		if err := json.Unmarshal(b, &s.file); err != nil {
+			return nil, err
+		}
+		s.file.Version = STATE_VERSION
+		if s.file.Sensors == nil {
+			s.file.Sensors = map[string]*SensorState{}
+		}
+	}
+	return s, nil
+}
+
+func (s *State) sensor(serial string) *SensorState {
+	state, ok := s.file.Sensors[serial]
+	if !ok {
+		state = &SensorState{}
+		s.file.Sensors[serial] = state
+	}
+	return state
+}
+
+// LastCalibration returns when the sensor with serial number serial was last
+// calibrated, or the zero time if that is not known.
+func (s *State) LastCalibration(serial string) time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sensor(serial).LastCalibration
+}
+
+// RecordCalibration records that the sensor with serial number serial was
+// calibrated at t, and saves the state.
+func (s *State) RecordCalibration(serial string, t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sensor(serial).LastCalibration = t
+	return s.save()
+}
+
+// WarmedUp returns whether the sensor with serial number serial was warmed up
+// as of its last reading, and whether that is known.
+func (s *State) WarmedUp(serial string) (warmedUp bool, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if w := s.sensor(serial).WarmedUp; w != nil {
+		return *w, true
+	}
+	return false, false
+}
+
+// RecordWarmedUp records whether the sensor with serial number serial is
+// warmed up, saving the state if that has changed.
+func (s *State) RecordWarmedUp(serial string, warmedUp bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state := s.sensor(serial)
+	if state.WarmedUp != nil && *state.WarmedUp == warmedUp {
+		return nil
+	}
+	state.WarmedUp = &warmedUp
+	return s.save()
+}
+
+// Save saves the state.
+func (s *State) Save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.save()
+}
+
+// save writes the state to its file. The new contents are synced to disk
+// before the file is replaced atomically, so that neither a crash nor a power
+// loss can leave it truncated.
+func (s *State) save() error {
+	b, err := json.MarshalIndent(s.file, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(s.Path), filepath.Base(s.Path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), s.Path)
+}
@@ -0,0 +1,51 @@
+package daemon
+
+import (
+	"math"
+
+	"github.com/jkoelndorfer/aqgo/sink"
+)
+
+// coVariance tracks an exponentially weighted moving mean and variance of
+// each sensor's CO readings. Both are updated incrementally, as in Welford's
+// algorithm, so no history is kept.
+type coVariance struct {
+	alpha   float64
+	sensors map[string]*ewStats
+}
+
+type ewStats struct {
+	mean     float64
+	variance float64
+}
+
+// newCOVariance weights readings so that the statistics reflect roughly the
+// last window readings.
+func newCOVariance(window int) *coVariance {
+	return &coVariance{
+		alpha:   2 / (float64(window) + 1),
+		sensors: map[string]*ewStats{},
+	}
+}
+
+// update adds co to the statistics of sensor and returns them. The z-score
+// compares co to the statistics as they were before it was added.
+func (v *coVariance) update(sensor string, co float64) *sink.COStatistics {
+	stats, ok := v.sensors[sensor]
+	if !ok {
+		v.sensors[sensor] = &ewStats{mean: co}
+		return &sink.COStatistics{}
+	}
+	zScore := 0.0
+	if stats.variance > 0 {
+		zScore = (co - stats.mean) / math.Sqrt(stats.variance)
+	}
+	diff := co - stats.mean
+	increment := v.alpha * diff
+	stats.mean += increment
+	stats.variance = (1 - v.alpha) * (stats.variance + diff*increment)
+	return &sink.COStatistics{
+		Variance: stats.variance,
+		ZScore:   zScore,
+	}
+}
@@ -3,9 +3,21 @@ module github.com/jkoelndorfer/aqgo
 go 1.16
 
 require (
-	github.com/aws/aws-sdk-go-v2 v1.3.4 // indirect
+	github.com/aws/aws-sdk-go-v2 v1.3.4
 	github.com/aws/aws-sdk-go-v2/config v1.1.6
 	github.com/aws/aws-sdk-go-v2/service/cloudwatch v1.3.1
+	github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs v1.2.2
+	github.com/aws/aws-sdk-go-v2/service/s3 v1.5.0
+	github.com/aws/aws-sdk-go-v2/service/sqs v1.3.1
+	github.com/aws/aws-sdk-go-v2/service/sts v1.3.0
+	github.com/eclipse/paho.mqtt.golang v1.3.5
+	github.com/gorilla/websocket v1.4.2
+	github.com/klauspost/compress v1.15.9
+	github.com/nats-io/nats-server/v2 v2.6.1 // indirect
+	github.com/nats-io/nats.go v1.13.0
+	github.com/segmentio/kafka-go v0.4.38
 	github.com/tarm/serial v0.0.0-20180830185346-98f6abe2eb07
-	golang.org/x/sys v0.0.0-20200909081042-eff7692f9009 // indirect
+	github.com/xitongsys/parquet-go v1.6.2
+	google.golang.org/grpc v1.38.0
+	google.golang.org/protobuf v1.26.0
 )
@@ -0,0 +1,46 @@
+package iotco1000
+
+import "time"
+
+// A Clock tells the time and waits. Timing is done through a Clock, rather
+// than the time package directly, so that tests can control it.
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+	After(d time.Duration) <-chan time.Time
+	NewTicker(d time.Duration) Ticker
+}
+
+// A Ticker delivers ticks every period, as a time.Ticker does, on the channel
+// returned by C.
+type Ticker interface {
+	C() <-chan time.Time
+	Reset(d time.Duration)
+	Stop()
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) Sleep(d time.Duration)                  { time.Sleep(d) }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (realClock) NewTicker(d time.Duration) Ticker       { return realTicker{time.NewTicker(d)} }
+
+type realTicker struct {
+	t *time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time   { return t.t.C }
+func (t realTicker) Reset(d time.Duration) { t.t.Reset(d) }
+func (t realTicker) Stop()                 { t.t.Stop() }
+
+// RealClock is the system clock. It is the default.
+var RealClock Clock = realClock{}
+
+// WithClock makes the sensor timestamp measurements, wait for responses and
+// pace Readings using clock.
+func WithClock(clock Clock) Option {
+	return func(co *IOTCO1000) {
+		co.clock = clock
+	}
+}
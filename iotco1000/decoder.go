@@ -0,0 +1,58 @@
+package iotco1000
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// MAX_DECODE_LINE_BYTES is the longest line a MeasurementDecoder reads. Saved
+// logs may hold lines far longer than a response, e.g. with timestamps or
+// other output interleaved.
+const MAX_DECODE_LINE_BYTES = 1024 * 1024
+
+// MeasurementDecoder reads measurements from a stream of sensor responses,
+// one per line, such as a saved device log.
+type MeasurementDecoder struct {
+	scanner *bufio.Scanner
+	parser  Parser
+}
+
+// NewMeasurementDecoder returns a MeasurementDecoder reading responses whose
+// fields are separated by delimiter, usually RESPONSE_DELIMITER.
+func NewMeasurementDecoder(r io.Reader, delimiter string) *MeasurementDecoder {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), MAX_DECODE_LINE_BYTES)
+	return &MeasurementDecoder{
+		scanner: scanner,
+		parser:  DelimitedParser(delimiter, 0),
+	}
+}
+
+// Decode parses the next non-blank line. It returns io.EOF once the stream is
+// exhausted. A line that cannot be parsed returns an error wrapping
+// ErrMalformedResponse, ErrSensorNotReady or ErrSensorReportedError, and
+// decoding may continue with the next line; any other error, such as a
+// failure to read the stream, ends decoding. MeasurementTime is not populated, as it is not
+// part of a response.
+func (d *MeasurementDecoder) Decode() (*AirQualityMeasurement, error) {
+	for d.scanner.Scan() {
+		line := d.scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if err := checkErrorResponse(line); err != nil {
+			return nil, err
+		}
+		aq, err := d.parser(line)
+		if err != nil {
+			return nil, err
+		}
+		aq.ResponseBytes = len(line)
+		return aq, nil
+	}
+	if err := d.scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
@@ -0,0 +1,58 @@
+package iotco1000_test
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/jkoelndorfer/aqgo/iotco1000"
+)
+
+func TestMeasurementDecoder(t *testing.T) {
+	log := strings.Join([]string{
+		response,
+		"",
+		"123456789012, garbled",
+		"ERROR: sensor not ready",
+		strings.Replace(response, ", 2, ", ", 5, ", 1),
+	}, "\n")
+	d := iotco1000.NewMeasurementDecoder(strings.NewReader(log), iotco1000.RESPONSE_DELIMITER)
+	co := []int{}
+	skipped := 0
+	for {
+		aq, err := d.Decode()
+		if err == io.EOF {
+			break
+		}
+		if errors.Is(err, iotco1000.ErrMalformedResponse) || errors.Is(err, iotco1000.ErrSensorNotReady) {
+			skipped++
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		co = append(co, aq.COConcentrationPPB)
+	}
+	if len(co) != 2 || co[0] != 2 || co[1] != 5 || skipped != 2 {
+		t.Errorf("decoded CO %v and skipped %d lines, want [2 5] and 2", co, skipped)
+	}
+}
+
+func TestMeasurementDecoderLongLines(t *testing.T) {
+	// longer than bufio.Scanner reads by default
+	long := strings.Repeat("x", 100*1024)
+	d := iotco1000.NewMeasurementDecoder(strings.NewReader(long+"\n"+response), iotco1000.RESPONSE_DELIMITER)
+	if _, err := d.Decode(); !errors.Is(err, iotco1000.ErrMalformedResponse) {
+		t.Fatalf("got error %v for a long line, want %v", err, iotco1000.ErrMalformedResponse)
+	}
+	if _, err := d.Decode(); err != nil {
+		t.Fatalf("failed decoding the line after a long line: %s", err)
+	}
+
+	d = iotco1000.NewMeasurementDecoder(strings.NewReader(strings.Repeat("x", iotco1000.MAX_DECODE_LINE_BYTES+1)), iotco1000.RESPONSE_DELIMITER)
+	if _, err := d.Decode(); !errors.Is(err, bufio.ErrTooLong) {
+		t.Fatalf("got error %v for a line over the maximum, want %v", err, bufio.ErrTooLong)
+	}
+}
@@ -0,0 +1,93 @@
+package iotco1000
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SERIAL_DEVICE_GLOBS are searched, in order, for serial devices to which a
+// sensor may be attached. Stable /dev/serial/by-id names are preferred over
+// the kernel device names they link to.
+var SERIAL_DEVICE_GLOBS = []string{
+	"/dev/serial/by-id/*",
+	"/dev/ttyUSB*",
+	"/dev/ttyACM*",
+}
+
+// DetectSerialDevices returns the serial devices present on the system. Each
+// device is reported once, even if it matches several globs.
+func DetectSerialDevices() ([]string, error) {
+	devices := []string{}
+	seen := map[string]bool{}
+	for _, glob := range SERIAL_DEVICE_GLOBS {
+		matches, err := filepath.Glob(glob)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range matches {
+			target, err := filepath.EvalSymlinks(m)
+			if err != nil {
+				continue
+			}
+			if seen[target] {
+				continue
+			}
+			seen[target] = true
+			devices = append(devices, m)
+		}
+	}
+	return devices, nil
+}
+
+// ErrNoDevice is returned when the device to open does not exist, as when a
+// USB serial adapter has been unplugged.
+var ErrNoDevice = errors.New("no such serial device")
+
+// ErrNoDeviceMatches is returned when no device matches the glob given to
+// WithDeviceGlob. It wraps ErrNoDevice.
+var ErrNoDeviceMatches = fmt.Errorf("%w: no device matches glob", ErrNoDevice)
+
+// WithDeviceGlob opens the first device matching glob, e.g. /dev/ttyUSB*,
+// rather than the path given to New. The glob is matched again each time the
+// sensor is reopened, so that an adapter that is unplugged and replaced by
+// another is picked up.
+func WithDeviceGlob(glob string) Option {
+	return func(co *IOTCO1000) {
+		co.deviceGlob = glob
+	}
+}
+
+// devicePath returns the path of the device to open: the first match of the
+// device glob if one is set, and otherwise the configured path. It returns an
+// error wrapping ErrNoDevice if there is no such device.
+func (co *IOTCO1000) devicePath() (string, error) {
+	if co.deviceGlob == "" {
+		path := co.config.Name
+		if strings.HasPrefix(path, TCP_URL_PREFIX) {
+			return path, nil
+		}
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return "", fmt.Errorf("%w %s", ErrNoDevice, path)
+		}
+		return path, nil
+	}
+	matches, err := filepath.Glob(co.deviceGlob)
+	if err != nil {
+		return "", err
+	}
+	if len(matches) == 0 {
+		return "", fmt.Errorf("%w %s", ErrNoDeviceMatches, co.deviceGlob)
+	}
+	return matches[0], nil
+}
+
+// DevicePath returns the path of the device the sensor was last opened from.
+func (co *IOTCO1000) DevicePath() string {
+	if co.config == nil {
+		return ""
+	}
+	return co.config.Name
+}
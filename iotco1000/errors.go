@@ -0,0 +1,96 @@
+package iotco1000
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrMalformedResponse is returned when a response from the sensor cannot be
+// parsed.
+var ErrMalformedResponse = errors.New("malformed response")
+
+// ErrResponseTruncated is returned when a response fills the read buffer
+// without a terminator, which suggests a protocol mismatch such as the wrong
+// terminator. It wraps ErrMalformedResponse.
+var ErrResponseTruncated = fmt.Errorf("%w: response truncated", ErrMalformedResponse)
+
+// ErrReadTimeout is returned when the sensor does not send a complete
+// response in time.
+var ErrReadTimeout = errors.New("timed out reading from sensor")
+
+// ErrSerialWrite is returned when a command cannot be written to the serial
+// device.
+var ErrSerialWrite = errors.New("failed writing to serial device")
+
+// ErrSerialRead is returned when the serial device cannot be read.
+var ErrSerialRead = errors.New("failed reading from serial device")
+
+// ErrSensorNotReady is returned when the sensor responds that it is not yet
+// ready to take a measurement.
+var ErrSensorNotReady = errors.New("sensor not ready")
+
+// ErrSensorReportedError is returned when the sensor responds with an error
+// other than ErrSensorNotReady.
+var ErrSensorReportedError = errors.New("sensor reported an error")
+
+// ErrMeasurementRejected is returned when a validator registered with
+// WithValidator rejects a measurement.
+var ErrMeasurementRejected = errors.New("measurement rejected")
+
+// A FieldError is returned by a Parser when some of the CO concentration,
+// temperature and humidity fields of a response could not be converted, but
+// the rest of it could. Measurement holds the values that were converted.
+// It wraps ErrMalformedResponse.
+type FieldError struct {
+	// Fields are the names of the fields that could not be converted:
+	// co, temperature or humidity.
+	Fields      []string
+	Measurement *AirQualityMeasurement
+
+	err error
+}
+
+func (e *FieldError) Error() string {
+	return e.err.Error()
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.err
+}
+
+// checkErrorResponse returns an error if response is an error message from the
+// sensor, e.g. "ERROR: sensor not ready", rather than a measurement.
+func checkErrorResponse(response string) error {
+	line := strings.TrimSpace(strings.TrimRight(response, "\x00"))
+	if !strings.HasPrefix(strings.ToUpper(line), "ERROR") {
+		return nil
+	}
+	if strings.Contains(strings.ToLower(line), "not ready") {
+		return fmt.Errorf("%w: %s", ErrSensorNotReady, line)
+	}
+	return fmt.Errorf("%w: %s", ErrSensorReportedError, line)
+}
+
+// FailureCategory classifies an error returned when taking a measurement as
+// one of timeout, truncated, malformed, serial, not-ready, sensor-error or rejected, or
+// other if it is none of these.
+func FailureCategory(err error) string {
+	switch {
+	case errors.Is(err, ErrReadTimeout):
+		return "timeout"
+	case errors.Is(err, ErrResponseTruncated):
+		return "truncated"
+	case errors.Is(err, ErrMalformedResponse):
+		return "malformed"
+	case errors.Is(err, ErrSerialRead), errors.Is(err, ErrSerialWrite):
+		return "serial"
+	case errors.Is(err, ErrSensorNotReady):
+		return "not-ready"
+	case errors.Is(err, ErrSensorReportedError):
+		return "sensor-error"
+	case errors.Is(err, ErrMeasurementRejected):
+		return "rejected"
+	}
+	return "other"
+}
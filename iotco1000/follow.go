@@ -0,0 +1,86 @@
+package iotco1000
+
+import (
+	"context"
+	"io"
+	"os"
+	"time"
+)
+
+// FOLLOW_POLL_INTERVAL is how often a followed file is checked for new data.
+const FOLLOW_POLL_INTERVAL = 250 * time.Millisecond
+
+// FollowReader reads a file that is still being written, like tail -f: at the
+// end of the file it waits for more to be written rather than returning
+// io.EOF. A file that is truncated is read again from the start, and once a
+// file has been replaced, as when it is rotated, the rest of it is read and
+// then the new file from its start. Read returns io.EOF once ctx is
+// cancelled.
+type FollowReader struct {
+	ctx    context.Context
+	path   string
+	f      *os.File
+	offset int64
+}
+
+func NewFollowReader(ctx context.Context, path string) (*FollowReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &FollowReader{ctx: ctx, path: path, f: f}, nil
+}
+
+func (r *FollowReader) Read(b []byte) (int, error) {
+	for {
+		n, err := r.f.Read(b)
+		r.offset += int64(n)
+		if n > 0 {
+			return n, nil
+		}
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		if err := r.reopen(); err != nil {
+			return 0, err
+		}
+		select {
+		case <-r.ctx.Done():
+			return 0, io.EOF
+		case <-time.After(FOLLOW_POLL_INTERVAL):
+		}
+	}
+}
+
+// reopen starts reading from the start of the file if it has been truncated,
+// or from the start of its replacement if it has been replaced. A file that
+// has been moved away but not yet replaced is kept open.
+func (r *FollowReader) reopen() error {
+	fi, err := r.f.Stat()
+	if err != nil {
+		return err
+	}
+	if fi.Size() < r.offset {
+		if _, err := r.f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		r.offset = 0
+		return nil
+	}
+	current, err := os.Stat(r.path)
+	if err != nil || os.SameFile(fi, current) {
+		return nil
+	}
+	f, err := os.Open(r.path)
+	if err != nil {
+		return nil
+	}
+	r.f.Close()
+	r.f = f
+	r.offset = 0
+	return nil
+}
+
+func (r *FollowReader) Close() error {
+	return r.f.Close()
+}
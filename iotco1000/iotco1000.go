@@ -6,18 +6,235 @@ package iotco1000
 // See https://www.spec-sensors.com/product/iot-co-1000-digital-co-sensor-module/
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
-	"strconv"
+	"regexp"
 	"strings"
 	"time"
 
 	"github.com/tarm/serial"
 )
 
+// MEASURE_COMMAND triggers a single measurement.
+const MEASURE_COMMAND = "\r\n"
+
+// FIRMWARE_INFO_COMMAND requests the firmware version of the sensor.
+const FIRMWARE_INFO_COMMAND = "f"
+
+// ZERO_CALIBRATION_COMMAND instructs the sensor to treat the current CO
+// reading as zero.
+const ZERO_CALIBRATION_COMMAND = "Z"
+
+// ZERO_CALIBRATION_ACK appears in the sensor's response once zero calibration
+// has completed.
+const ZERO_CALIBRATION_ACK = "done"
+
+// MAX_RESPONSE_BYTES is the default size of the buffer a response is read
+// into. See WithMaxResponseBytes.
+const MAX_RESPONSE_BYTES = 256
+
+// RESPONSE_TIMEOUT is how long to wait for a complete response from the
+// sensor after sending it a command.
+const RESPONSE_TIMEOUT = 5 * time.Second
+
+// WARM_UP_DURATION is how long the sensor must be powered on before its
+// readings are accurate.
+const WARM_UP_DURATION = 2 * time.Hour
+
 type IOTCO1000 struct {
 	SerialPort io.ReadWriteCloser
+	config     *serial.Config
+	parser     Parser
+	keepRaw    bool
+
+	// settleDelay is how long to wait after sending a command before reading
+	// the response.
+	settleDelay time.Duration
+
+	// trigger is sent to the sensor to request a measurement, and terminator
+	// marks the end of each response.
+	trigger    []byte
+	terminator byte
+
+	// openRetryTimeout is how long New keeps retrying to open the serial port.
+	openRetryTimeout time.Duration
+
+	serialOverride string
+	intervalField  int
+	pressureField  int
+	vocField       int
+	delimiter      string
+	validators     []func(*AirQualityMeasurement) error
+
+	// maxResponseBytes is the size of the buffer responses are read into.
+	maxResponseBytes int
+
+	// epoch is when the sensor was opened, from which monotonic timestamps
+	// are measured.
+	timestampSource TimestampSource
+	epoch           time.Time
+
+	// deviceGlob, if set, is matched to find the device to open in place of
+	// the path given to New.
+	deviceGlob string
+
+	lenient bool
+
+	clock Clock
+
+	skipEcho bool
+
+	// readDuration is how long reading the last response took.
+	readDuration time.Duration
+}
+
+// Option configures optional behavior of an IOTCO1000.
+type Option func(*IOTCO1000)
+
+// WithRegexParser parses sensor responses using re rather than splitting
+// them on a delimiter. See RegexParser for the named groups re must define.
+func WithRegexParser(re *regexp.Regexp) Option {
+	return func(co *IOTCO1000) {
+		co.parser = RegexParser(re)
+	}
+}
+
+// WithSerialOverride reports serial as the serial number of every
+// measurement, for sensors whose responses lack a usable serial number.
+func WithSerialOverride(serial string) Option {
+	return func(co *IOTCO1000) {
+		co.serialOverride = serial
+	}
+}
+
+// WithIntervalField reads the sensor's own measurement interval, in seconds,
+// from the response field at index field into ReportedInterval. Firmware
+// that reports it typically does so after the standard fields.
+func WithIntervalField(field int) Option {
+	return func(co *IOTCO1000) {
+		co.intervalField = field
+	}
+}
+
+// WithPressureField reads the barometric pressure, in hPa, from the response
+// field at index field into PressurehPa, for variants and companion boards
+// that append it.
+func WithPressureField(field int) Option {
+	return func(co *IOTCO1000) {
+		co.pressureField = field
+	}
+}
+
+// WithVOCField reads a VOC index from the response field at index field into
+// VOCIndex, for variants and companion boards that append it.
+func WithVOCField(field int) Option {
+	return func(co *IOTCO1000) {
+		co.vocField = field
+	}
+}
+
+// WithParser parses sensor responses using p. Combined with WithTrigger and
+// WithTerminator, this allows firmware using a non-CSV or binary protocol to
+// be supported.
+func WithParser(p Parser) Option {
+	return func(co *IOTCO1000) {
+		co.parser = p
+	}
+}
+
+// WithDelimiter sets the delimiter between the fields of a response, for
+// firmware that does not use RESPONSE_DELIMITER. It has no effect on the
+// parsing of a parser set by WithParser or WithRegexParser.
+func WithDelimiter(delimiter string) Option {
+	return func(co *IOTCO1000) {
+		co.delimiter = delimiter
+	}
+}
+
+// WithValidator registers v to validate, transform or enrich each
+// measurement. Validators run in the order they were registered, after the
+// response has been parsed and checked by the parser and every field of the
+// measurement, including MeasurementTime, has been populated. If v returns an
+// error, the measurement is dropped and AnalyzeAirQuality returns the error
+// wrapped in ErrMeasurementRejected.
+func WithValidator(v func(*AirQualityMeasurement) error) Option {
+	return func(co *IOTCO1000) {
+		co.validators = append(co.validators, v)
+	}
+}
+
+// WithMaxResponseBytes sets the size of the buffer a response is read into.
+// A response that fills it without a terminator returns an error wrapping
+// ErrResponseTruncated. The default is MAX_RESPONSE_BYTES.
+func WithMaxResponseBytes(n int) Option {
+	return func(co *IOTCO1000) {
+		co.maxResponseBytes = n
+	}
+}
+
+// WithTrigger sets the bytes sent to the sensor to request a measurement. The
+// default is MEASURE_COMMAND.
+func WithTrigger(trigger []byte) Option {
+	return func(co *IOTCO1000) {
+		co.trigger = trigger
+	}
+}
+
+// WithTerminator sets the byte that marks the end of a response from the
+// sensor. The default is '\n'.
+func WithTerminator(terminator byte) Option {
+	return func(co *IOTCO1000) {
+		co.terminator = terminator
+	}
+}
+
+// WithEchoSkipping sets whether lines preceding a response that are empty or
+// echo the command sent, as some serial setups do, are skipped rather than
+// taken as the response. Lines preceding a measurement that do not parse are
+// skipped as well, as long as another line follows. It is enabled by
+// default.
+func WithEchoSkipping(skip bool) Option {
+	return func(co *IOTCO1000) {
+		co.skipEcho = skip
+	}
+}
+
+// WithOpenRetry makes New retry opening the serial port, with backoff, for up
+// to timeout. This accommodates devices that have not yet been enumerated
+// when the process starts, e.g. at boot. A negative timeout retries
+// indefinitely.
+func WithOpenRetry(timeout time.Duration) Option {
+	return func(co *IOTCO1000) {
+		co.openRetryTimeout = timeout
+	}
+}
+
+// WithLenientParsing makes AnalyzeAirQuality return responses in which only
+// some of the CO concentration, temperature and humidity could be parsed,
+// with the rest listed in InvalidFields, rather than failing them. This
+// requires a parser, such as the default, that returns a *FieldError.
+func WithLenientParsing() Option {
+	return func(co *IOTCO1000) {
+		co.lenient = true
+	}
+}
+
+// WithSettleDelay sets how long to wait after sending a command before
+// reading the sensor's response. The default is one second.
+func WithSettleDelay(d time.Duration) Option {
+	return func(co *IOTCO1000) {
+		co.settleDelay = d
+	}
+}
+
+// WithKeepRaw populates RawResponse on each measurement with the response
+// it was parsed from.
+func WithKeepRaw() Option {
+	return func(co *IOTCO1000) {
+		co.keepRaw = true
+	}
 }
 
 type AirQualityMeasurement struct {
@@ -27,94 +244,394 @@ type AirQualityMeasurement struct {
 	RelativeHumidity   int
 	Uptime             time.Duration
 	MeasurementTime    time.Time
+
+	// TemperatureCFloat and RelativeHumidityFloat retain the fractional part
+	// reported by firmware that includes one. TemperatureC and
+	// RelativeHumidity hold the same values rounded to the nearest integer.
+	TemperatureCFloat     float64
+	RelativeHumidityFloat float64
+
+	// ResponseBytes is the length of the response the measurement was parsed
+	// from. Unusually short or long responses suggest a corrupted read.
+	ResponseBytes int
+
+	// ReadDuration is how long reading the response took, from the first
+	// read after the settle delay to the terminator.
+	ReadDuration time.Duration
+
+	// RawResponse is the response the measurement was parsed from, without
+	// trailing NUL padding. It is only populated when WithKeepRaw is set.
+	RawResponse string
+
+	// ReportedInterval is how often the sensor takes measurements
+	// internally, if known. It is only populated when WithIntervalField is
+	// set and the response includes the field.
+	ReportedInterval time.Duration
+
+	// PressurehPa and VOCIndex are the barometric pressure in hPa and the
+	// VOC index, for responses that include them. They are nil unless
+	// WithPressureField or WithVOCField is set and the field could be parsed.
+	PressurehPa *float64
+	VOCIndex    *float64
+
+	// Placeholder is set when the CO, temperature and humidity values are
+	// those the firmware reports while it is still booting, rather than
+	// measurements. Only the uptime of such a measurement is meaningful.
+	Placeholder bool
+
+	// InvalidFields names the fields, of FIELD_CO, FIELD_TEMPERATURE and
+	// FIELD_HUMIDITY, that could not be parsed from the response. Their
+	// values are zero and should not be submitted. It is only populated when
+	// WithLenientParsing is set.
+	InvalidFields []string
+
+	// UptimeComponents are the days, hours, minutes and seconds fields of
+	// the response that Uptime was computed from.
+	UptimeComponents UptimeComponents
+}
+
+// UptimeComponents holds the uptime fields of a response as reported.
+type UptimeComponents struct {
+	Days    int
+	Hours   int
+	Minutes int
+	Seconds int
+}
+
+// FieldValid reports whether field, one of FIELD_CO, FIELD_TEMPERATURE and
+// FIELD_HUMIDITY, was parsed from the response.
+func (aq *AirQualityMeasurement) FieldValid(field string) bool {
+	for _, f := range aq.InvalidFields {
+		if f == field {
+			return false
+		}
+	}
+	return true
+}
+
+// WarmedUp reports whether the sensor had been powered on for at least
+// threshold when the measurement was taken. Use WARM_UP_DURATION unless the
+// application calls for a different threshold.
+func (aq *AirQualityMeasurement) WarmedUp(threshold time.Duration) bool {
+	return aq.Uptime >= threshold
+}
+
+func (aq *AirQualityMeasurement) String() string {
+	return fmt.Sprintf("sensor %s at %s: CO %d ppb, temperature %g °C, relative humidity %g%%, uptime %s",
+		aq.SensorSerialNumber,
+		aq.MeasurementTime.Format(time.RFC3339),
+		aq.COConcentrationPPB,
+		aq.TemperatureCFloat,
+		aq.RelativeHumidityFloat,
+		aq.Uptime,
+	)
 }
 
-func New(serialDevicePath string) (*IOTCO1000, error) {
-	config := &serial.Config{
+// SerialThroughput returns the rate in bytes per second at which the response
+// was read, and false if the read was too quick to measure.
+func (aq *AirQualityMeasurement) SerialThroughput() (float64, bool) {
+	if aq.ReadDuration <= 0 {
+		return 0, false
+	}
+	return float64(aq.ResponseBytes) / aq.ReadDuration.Seconds(), true
+}
+
+// SameReading reports whether aq and other are from the same sensor and
+// measured the same CO concentration, temperature and humidity, regardless of
+// when they were taken.
+func (aq *AirQualityMeasurement) SameReading(other *AirQualityMeasurement) bool {
+	return aq.SensorSerialNumber == other.SensorSerialNumber &&
+		aq.COConcentrationPPB == other.COConcentrationPPB &&
+		aq.TemperatureCFloat == other.TemperatureCFloat &&
+		aq.RelativeHumidityFloat == other.RelativeHumidityFloat
+}
+
+// New opens the sensor at serialDevicePath, which may be a serial device, a
+// named pipe, or a tcp://host:port URL.
+func New(serialDevicePath string, opts ...Option) (*IOTCO1000, error) {
+	iotco1000 := withOptions(opts)
+	iotco1000.config = &serial.Config{
 		Name:        serialDevicePath,
 		Baud:        9600,
 		Parity:      serial.ParityNone,
 		StopBits:    serial.Stop1,
-		ReadTimeout: 250 * time.Millisecond,
+		ReadTimeout: READ_TIMEOUT,
 	}
-	serialPort, err := serial.OpenPort(config)
-	if err != nil {
-		return nil, err
+	deadline := iotco1000.clock.Now().Add(iotco1000.openRetryTimeout)
+	backoff := 250 * time.Millisecond
+	for {
+		serialPort, err := iotco1000.open()
+		if err == nil {
+			iotco1000.SerialPort = serialPort
+			return iotco1000, nil
+		}
+		if iotco1000.openRetryTimeout >= 0 && iotco1000.clock.Now().Add(backoff).After(deadline) {
+			return nil, err
+		}
+		iotco1000.clock.Sleep(backoff)
+		if backoff < 5*time.Second {
+			backoff *= 2
+		}
 	}
+}
+
+// NewFromPort returns an IOTCO1000 that communicates over an already open
+// port, such as a network connection or a simulated sensor. Reopen is not
+// supported on the result.
+func NewFromPort(port io.ReadWriteCloser, opts ...Option) *IOTCO1000 {
+	iotco1000 := withOptions(opts)
+	iotco1000.SerialPort = port
+	return iotco1000
+}
+
+func withOptions(opts []Option) *IOTCO1000 {
 	iotco1000 := &IOTCO1000{
-		SerialPort: serialPort,
+		settleDelay: 1000 * time.Millisecond,
+		delimiter:   RESPONSE_DELIMITER,
+
+		maxResponseBytes: MAX_RESPONSE_BYTES,
+		timestampSource:  TimestampWallClock,
+		clock:            RealClock,
+		trigger:          []byte(MEASURE_COMMAND),
+		skipEcho:         true,
+		terminator:       '\n',
+
+		intervalField: -1,
+		pressureField: -1,
+		vocField:      -1,
+	}
+	for _, opt := range opts {
+		opt(iotco1000)
+	}
+	iotco1000.epoch = iotco1000.clock.Now()
+	if iotco1000.parser == nil {
+		iotco1000.parser = DelimitedParser(iotco1000.delimiter, 0)
 	}
-	return iotco1000, nil
+	return iotco1000
 }
 
 func (co *IOTCO1000) Close() error {
 	return co.SerialPort.Close()
 }
 
-func (co *IOTCO1000) AnalyzeAirQuality() (*AirQualityMeasurement, error) {
-	bytesWritten, err := co.SerialPort.Write([]byte("\r\n"))
+// Reopen closes and reopens the serial port, which may recover a sensor that
+// has stopped responding.
+func (co *IOTCO1000) Reopen() error {
+	if co.config == nil {
+		return errors.New("sensor was not opened from a serial device and cannot be reopened")
+	}
+	co.SerialPort.Close()
+	serialPort, err := co.open()
 	if err != nil {
-		return nil, err
-	} else if bytesWritten == 0 {
-		return nil, errors.New("failed to write to IOTCO1000 serial device")
+		return err
 	}
+	co.SerialPort = serialPort
+	return nil
+}
 
-	// Give the IOTCO1000 a little bit of time to produce a response.
-	time.Sleep(1000 * time.Millisecond)
+// CanReopen reports whether Reopen can reopen the sensor, which it can only
+// if the sensor was opened from a serial device rather than by NewFromPort.
+func (co *IOTCO1000) CanReopen() bool {
+	return co.config != nil
+}
 
-	byteBuffer := make([]byte, 256)
-	measurementTime := time.Now()
-	totalBytesRead := 0
-	for {
-		bytesRead, err := co.SerialPort.Read(byteBuffer)
-		totalBytesRead += bytesRead
-		if err != nil {
-			fmt.Printf("error is: %s", err)
-			return nil, err
-		}
-		if totalBytesRead == 0 {
-			// do nothing
-		} else if byteBuffer[totalBytesRead-1] == byte('\n') {
-			break
-		}
-		time.Sleep(50 * time.Millisecond)
+// open opens the device, updating the configured path if it was found by the
+// device glob.
+func (co *IOTCO1000) open() (io.ReadWriteCloser, error) {
+	path, err := co.devicePath()
+	if err != nil {
+		return nil, err
 	}
-	d := strings.Split(string(byteBuffer), ", ")
-	serialNumber, COConcentrationPPB, temperatureC, relativeHumidity, daysUp, hoursUp, minutesUp, secondsUp :=
-		d[0], d[1], d[2], d[3], d[7], d[8], d[9], d[10]
+	co.config.Name = path
+	return openDevice(path, co.config)
+}
 
-	COInt, err := strconv.ParseInt(COConcentrationPPB, 10, 32)
+func (co *IOTCO1000) AnalyzeAirQuality() (*AirQualityMeasurement, error) {
+	response, measurementTime, err := co.command(co.trigger, co.isMeasurement)
 	if err != nil {
-		return nil, fmt.Errorf("failed converting CO concentration (%s) to int", COConcentrationPPB)
+		return nil, err
+	}
+	if err := checkErrorResponse(response); err != nil {
+		return nil, err
+	}
+	aq, err := co.parser(response)
+	var fieldErr *FieldError
+	if co.lenient && errors.As(err, &fieldErr) {
+		aq, err = fieldErr.Measurement, nil
 	}
-	temperatureCInt, err := strconv.ParseInt(temperatureC, 10, 8)
 	if err != nil {
-		return nil, fmt.Errorf("failed converting temperature (%s) to int", temperatureC)
+		return nil, err
 	}
-	relativeHumidityInt, err := strconv.ParseInt(relativeHumidity, 10, 8)
+	if co.serialOverride != "" {
+		aq.SensorSerialNumber = co.serialOverride
+	}
+	if co.intervalField >= 0 {
+		aq.ReportedInterval = reportedInterval(response, co.delimiter, co.intervalField)
+	}
+	if co.pressureField >= 0 {
+		aq.PressurehPa = optionalField(response, co.delimiter, co.pressureField)
+	}
+	if co.vocField >= 0 {
+		aq.VOCIndex = optionalField(response, co.delimiter, co.vocField)
+	}
+	aq.MeasurementTime = co.timestamp(measurementTime)
+	aq.ResponseBytes = len(response)
+	aq.ReadDuration = co.readDuration
+	if co.keepRaw {
+		aq.RawResponse = strings.TrimRight(response, "\x00")
+	}
+	for _, v := range co.validators {
+		if err := v(aq); err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrMeasurementRejected, err)
+		}
+	}
+	return aq, nil
+}
+
+// RawMeasurement requests a measurement and returns the response verbatim,
+// less the line terminator, without parsing it.
+func (co *IOTCO1000) RawMeasurement() (string, error) {
+	response, _, err := co.command(co.trigger, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed converting relative humidity (%s) to int", relativeHumidity)
+		return "", err
 	}
-	daysUpInt, err := strconv.ParseInt(daysUp, 10, 16)
+	return strings.TrimRight(response, "\r\n\x00"), nil
+}
+
+// DetectDelimiter takes a measurement and picks the delimiter that separates
+// its fields with the package-level DetectDelimiter. If the measurement then
+// parses with the serial number at index serialField, the sensor uses the
+// delimiter from then on, and it is returned. Otherwise an error wrapping
+// ErrMalformedResponse is returned.
+func (co *IOTCO1000) DetectDelimiter(serialField int) (string, error) {
+	response, _, err := co.command(co.trigger, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed converting days up (%s) to int", daysUp)
+		return "", err
 	}
-	hoursUpInt, err := strconv.ParseInt(hoursUp, 10, 8)
+	if err := checkErrorResponse(response); err != nil {
+		return "", err
+	}
+	d := DetectDelimiter(response)
+	parser := DelimitedParser(d, serialField)
+	if _, err := parser(response); err != nil {
+		return "", fmt.Errorf("detected delimiter %q does not yield a parseable reading: %w", d, err)
+	}
+	co.delimiter = d
+	co.parser = parser
+	return d, nil
+}
+
+// FirmwareInfo queries the sensor for its firmware version and returns the
+// response verbatim, less the line terminator.
+func (co *IOTCO1000) FirmwareInfo() (string, error) {
+	response, _, err := co.command([]byte(FIRMWARE_INFO_COMMAND), nil)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(response, "\r\n\x00"), nil
+}
+
+// Calibrate re-zeroes the sensor so that the CO concentration of the air
+// around it reads as 0 ppb.
+//
+// Calibrate must only be run while the sensor sits in air known to be free of
+// CO; otherwise all subsequent readings will be offset by the concentration
+// present during calibration.
+func (co *IOTCO1000) Calibrate() error {
+	response, _, err := co.command([]byte(ZERO_CALIBRATION_COMMAND), nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed converting hours up (%s) to int", hoursUp)
+		return err
+	}
+	if !strings.Contains(strings.ToLower(response), ZERO_CALIBRATION_ACK) {
+		return fmt.Errorf("sensor did not acknowledge zero calibration: %q", strings.TrimRight(response, "\r\n\x00"))
 	}
-	uptimeDurationStr := fmt.Sprintf("%dh%sm%ss", daysUpInt*24+hoursUpInt, minutesUp, strings.Trim(secondsUp, " \r\n\x00"))
-	uptime, err := time.ParseDuration(uptimeDurationStr)
+	return nil
+}
+
+// command writes cmd to the sensor and returns its response, up to and
+// including the terminator, along with the time at which the response was
+// read. Unless echo skipping is disabled, lines that are empty or echo cmd
+// are skipped, as are lines that accept, if set, rejects; the last line
+// rejected is returned only if the sensor sends nothing after it, so that
+// the caller can report it as malformed.
+func (co *IOTCO1000) command(cmd []byte, accept func(line string) bool) (string, time.Time, error) {
+	bytesWritten, err := co.SerialPort.Write(cmd)
 	if err != nil {
-		return nil, fmt.Errorf("failed parsing duration string %s", uptimeDurationStr)
+		return "", time.Time{}, fmt.Errorf("%w: %s", ErrSerialWrite, err)
+	} else if bytesWritten == 0 {
+		return "", time.Time{}, fmt.Errorf("%w: no bytes written", ErrSerialWrite)
 	}
 
-	return &AirQualityMeasurement{
-		SensorSerialNumber: serialNumber,
-		COConcentrationPPB: int(COInt),
-		TemperatureC:       int(temperatureCInt),
-		RelativeHumidity:   int(relativeHumidityInt),
-		Uptime:             uptime,
-		MeasurementTime:    measurementTime,
-	}, nil
+	// Give the IOTCO1000 a little bit of time to produce a response.
+	co.clock.Sleep(co.settleDelay)
+
+	byteBuffer := make([]byte, co.maxResponseBytes)
+	readTime := co.clock.Now()
+	totalBytesRead := 0
+	// lineStart is where the line being read starts, and scanned is how far
+	// the buffer has been searched for terminators.
+	lineStart, scanned := 0, 0
+	rejected := ""
+	response := func(line string) (string, time.Time, error) {
+		co.readDuration = co.clock.Now().Sub(readTime)
+		return line, readTime, nil
+	}
+	for {
+		bytesRead, err := co.SerialPort.Read(byteBuffer[totalBytesRead:])
+		totalBytesRead += bytesRead
+		if err != nil {
+			return "", time.Time{}, fmt.Errorf("%w: %s", ErrSerialRead, err)
+		}
+		for ; scanned < totalBytesRead; scanned++ {
+			if byteBuffer[scanned] != co.terminator {
+				continue
+			}
+			line := string(byteBuffer[lineStart : scanned+1])
+			lineStart = scanned + 1
+			if !co.skipEcho {
+				return response(line)
+			}
+			if isEcho([]byte(line), cmd) {
+				continue
+			}
+			if accept == nil || accept(line) {
+				return response(line)
+			}
+			rejected = line
+		}
+		if bytesRead == 0 && rejected != "" {
+			return response(rejected)
+		}
+		if totalBytesRead == len(byteBuffer) {
+			if lineStart == 0 {
+				return "", time.Time{}, fmt.Errorf("%w: response reached %d bytes without a terminator", ErrResponseTruncated, len(byteBuffer))
+			}
+			// make room by discarding the lines skipped
+			totalBytesRead = copy(byteBuffer, byteBuffer[lineStart:totalBytesRead])
+			scanned -= lineStart
+			lineStart = 0
+		}
+		if co.clock.Now().Sub(readTime) > RESPONSE_TIMEOUT {
+			return "", time.Time{}, fmt.Errorf("%w: no complete response after %s (%d bytes read)", ErrReadTimeout, RESPONSE_TIMEOUT, totalBytesRead)
+		}
+		co.clock.Sleep(50 * time.Millisecond)
+	}
+}
+
+// isMeasurement reports whether line parses as a measurement, or is an error
+// response, rather than something preceding the response.
+func (co *IOTCO1000) isMeasurement(line string) bool {
+	if checkErrorResponse(line) != nil {
+		return true
+	}
+	_, err := co.parser(line)
+	var fieldErr *FieldError
+	return err == nil || errors.As(err, &fieldErr)
+}
+
+// isEcho reports whether line is empty or an echo of cmd, ignoring
+// surrounding whitespace and NUL padding.
+func isEcho(line, cmd []byte) bool {
+	trimmed := bytes.Trim(line, " \r\n\x00")
+	return len(trimmed) == 0 || bytes.Equal(trimmed, bytes.Trim(cmd, " \r\n\x00"))
 }
@@ -0,0 +1,380 @@
+package iotco1000_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jkoelndorfer/aqgo/internal/clocktest"
+	"github.com/jkoelndorfer/aqgo/iotco1000"
+)
+
+const response = "123456789012, 2, 21, 39, 27890, 23189, 28405, 00, 03, 00, 01\r\n"
+
+// fakePort is an in-memory serial port. Each read returns the next of reads,
+// or nothing once they run out, as a serial port does when its read times
+// out. A read that does not fit the buffer is returned over several reads.
+type fakePort struct {
+	reads    []string
+	readErr  error
+	writeErr error
+	written  bytes.Buffer
+}
+
+func (p *fakePort) Read(b []byte) (int, error) {
+	if p.readErr != nil {
+		return 0, p.readErr
+	}
+	if len(p.reads) == 0 {
+		return 0, nil
+	}
+	n := copy(b, p.reads[0])
+	if n < len(p.reads[0]) {
+		p.reads[0] = p.reads[0][n:]
+	} else {
+		p.reads = p.reads[1:]
+	}
+	return n, nil
+}
+
+func (p *fakePort) Write(b []byte) (int, error) {
+	if p.writeErr != nil {
+		return 0, p.writeErr
+	}
+	return p.written.Write(b)
+}
+
+func (p *fakePort) Close() error {
+	return nil
+}
+
+// newFakeSensor returns a sensor reading port, on a fake clock so that its
+// delays and timeouts pass instantly.
+func newFakeSensor(port *fakePort, opts ...iotco1000.Option) *iotco1000.IOTCO1000 {
+	clock := clocktest.NewFakeClock(time.Date(2024, time.June, 1, 0, 0, 0, 0, time.UTC))
+	return iotco1000.NewFromPort(port, append([]iotco1000.Option{iotco1000.WithClock(clock)}, opts...)...)
+}
+
+func TestAnalyzeAirQualityReads(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		port  *fakePort
+		opts  []iotco1000.Option
+		err   error
+		bytes int
+	}{
+		{
+			name:  "whole response",
+			port:  &fakePort{reads: []string{response}},
+			bytes: len(response),
+		},
+		{
+			name:  "partial reads",
+			port:  &fakePort{reads: []string{response[:5], "", response[5:30], response[30:]}},
+			bytes: len(response),
+		},
+		{
+			name:  "terminator in its own read",
+			port:  &fakePort{reads: []string{response[:len(response)-1], "\n"}},
+			bytes: len(response),
+		},
+		{
+			name:  "custom terminator",
+			port:  &fakePort{reads: []string{response[:len(response)-1]}},
+			opts:  []iotco1000.Option{iotco1000.WithTerminator('\r')},
+			bytes: len(response) - 1,
+		},
+		{
+			name: "NUL padding before the terminator",
+			port: &fakePort{reads: []string{response[:len(response)-2] + "\x00\x00\r\n"}},
+			// two bytes of padding more than the response
+			bytes: len(response) + 2,
+		},
+		{
+			name: "truncated",
+			port: &fakePort{reads: []string{response[:len(response)-2]}},
+			opts: []iotco1000.Option{iotco1000.WithMaxResponseBytes(32)},
+			err:  iotco1000.ErrResponseTruncated,
+		},
+		{
+			name: "wrong terminator fills the buffer",
+			port: &fakePort{reads: []string{response, response, response, response, response}},
+			opts: []iotco1000.Option{iotco1000.WithTerminator(';')},
+			err:  iotco1000.ErrResponseTruncated,
+		},
+		{
+			name: "no response",
+			port: &fakePort{},
+			err:  iotco1000.ErrReadTimeout,
+		},
+		{
+			name: "response without terminator",
+			port: &fakePort{reads: []string{response[:20]}},
+			err:  iotco1000.ErrReadTimeout,
+		},
+		{
+			name: "malformed",
+			port: &fakePort{reads: []string{"123456789012, garbled\r\n"}},
+			err:  iotco1000.ErrMalformedResponse,
+		},
+		{
+			name: "read error",
+			port: &fakePort{readErr: errors.New("device unplugged")},
+			err:  iotco1000.ErrSerialRead,
+		},
+		{
+			name: "write error",
+			port: &fakePort{writeErr: errors.New("device unplugged")},
+			err:  iotco1000.ErrSerialWrite,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			aq, err := newFakeSensor(tc.port, tc.opts...).AnalyzeAirQuality()
+			if tc.err != nil {
+				if !errors.Is(err, tc.err) {
+					t.Fatalf("got error %v, want %v", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if aq.SensorSerialNumber != "123456789012" || aq.COConcentrationPPB != 2 || aq.TemperatureC != 21 || aq.RelativeHumidity != 39 || aq.Uptime != 3*time.Hour+time.Second {
+				t.Errorf("parsed %s", aq)
+			}
+			if aq.ResponseBytes != tc.bytes {
+				t.Errorf("got %d response bytes, want %d", aq.ResponseBytes, tc.bytes)
+			}
+			if got := tc.port.written.String(); got != iotco1000.MEASURE_COMMAND {
+				t.Errorf("wrote %q, want %q", got, iotco1000.MEASURE_COMMAND)
+			}
+		})
+	}
+}
+
+func TestAnalyzeAirQualityTimeoutTakesResponseTimeout(t *testing.T) {
+	clock := clocktest.NewFakeClock(time.Date(2024, time.June, 1, 0, 0, 0, 0, time.UTC))
+	sensor := iotco1000.NewFromPort(&fakePort{}, iotco1000.WithClock(clock), iotco1000.WithSettleDelay(0))
+	start := clock.Now()
+	if _, err := sensor.AnalyzeAirQuality(); !errors.Is(err, iotco1000.ErrReadTimeout) {
+		t.Fatalf("got error %v, want %v", err, iotco1000.ErrReadTimeout)
+	}
+	if elapsed := clock.Now().Sub(start); elapsed < iotco1000.RESPONSE_TIMEOUT || elapsed > iotco1000.RESPONSE_TIMEOUT+time.Second {
+		t.Errorf("gave up after %s, want about %s", elapsed, iotco1000.RESPONSE_TIMEOUT)
+	}
+}
+
+func TestAnalyzeAirQualityErrorResponse(t *testing.T) {
+	for _, tc := range []struct {
+		response string
+		err      error
+		category string
+	}{
+		{"ERROR: sensor not ready\r\n", iotco1000.ErrSensorNotReady, "not-ready"},
+		{"error: Sensor Not Ready \x00\r\n", iotco1000.ErrSensorNotReady, "not-ready"},
+		{"ERROR: heater fault\r\n", iotco1000.ErrSensorReportedError, "sensor-error"},
+	} {
+		_, err := newFakeSensor(&fakePort{reads: []string{tc.response}}).AnalyzeAirQuality()
+		if !errors.Is(err, tc.err) {
+			t.Errorf("%q: got error %v, want %v", tc.response, err, tc.err)
+		}
+		if errors.Is(err, iotco1000.ErrMalformedResponse) {
+			t.Errorf("%q: error %v is reported as malformed", tc.response, err)
+		}
+		if got := iotco1000.FailureCategory(err); got != tc.category {
+			t.Errorf("%q: failure category %s, want %s", tc.response, got, tc.category)
+		}
+	}
+}
+
+func TestWarmedUp(t *testing.T) {
+	for _, tc := range []struct {
+		uptime    time.Duration
+		threshold time.Duration
+		want      bool
+	}{
+		{iotco1000.WARM_UP_DURATION - time.Second, iotco1000.WARM_UP_DURATION, false},
+		{iotco1000.WARM_UP_DURATION - time.Nanosecond, iotco1000.WARM_UP_DURATION, false},
+		{iotco1000.WARM_UP_DURATION, iotco1000.WARM_UP_DURATION, true},
+		{iotco1000.WARM_UP_DURATION + time.Second, iotco1000.WARM_UP_DURATION, true},
+		{0, 0, true},
+		{0, time.Second, false},
+	} {
+		aq := &iotco1000.AirQualityMeasurement{Uptime: tc.uptime}
+		if got := aq.WarmedUp(tc.threshold); got != tc.want {
+			t.Errorf("uptime %s, threshold %s: WarmedUp = %t, want %t", tc.uptime, tc.threshold, got, tc.want)
+		}
+	}
+}
+
+func TestSameReading(t *testing.T) {
+	base := iotco1000.AirQualityMeasurement{
+		SensorSerialNumber:    "123456789012",
+		COConcentrationPPB:    2,
+		TemperatureC:          22,
+		RelativeHumidity:      40,
+		TemperatureCFloat:     21.5,
+		RelativeHumidityFloat: 39.5,
+		Uptime:                3 * time.Hour,
+		MeasurementTime:       time.Date(2024, time.June, 1, 0, 0, 0, 0, time.UTC),
+	}
+	for _, tc := range []struct {
+		name   string
+		change func(*iotco1000.AirQualityMeasurement)
+		want   bool
+	}{
+		{"identical", func(aq *iotco1000.AirQualityMeasurement) {}, true},
+		{"later", func(aq *iotco1000.AirQualityMeasurement) {
+			aq.MeasurementTime = aq.MeasurementTime.Add(time.Minute)
+			aq.Uptime += time.Minute
+		}, true},
+		{"different response", func(aq *iotco1000.AirQualityMeasurement) {
+			aq.ResponseBytes = 70
+			aq.RawResponse = "raw"
+		}, true},
+		{"other sensor", func(aq *iotco1000.AirQualityMeasurement) { aq.SensorSerialNumber = "123456789013" }, false},
+		{"CO by 1 ppb", func(aq *iotco1000.AirQualityMeasurement) { aq.COConcentrationPPB++ }, false},
+		{"CO negated", func(aq *iotco1000.AirQualityMeasurement) { aq.COConcentrationPPB = -2 }, false},
+		// the rounded values are the same, but the reported values are not
+		{"temperature by 0.1", func(aq *iotco1000.AirQualityMeasurement) { aq.TemperatureCFloat = 21.6 }, false},
+		{"humidity by 0.1", func(aq *iotco1000.AirQualityMeasurement) { aq.RelativeHumidityFloat = 39.4 }, false},
+	} {
+		other := base
+		tc.change(&other)
+		if got := base.SameReading(&other); got != tc.want {
+			t.Errorf("%s: SameReading = %t, want %t", tc.name, got, tc.want)
+		}
+		if got := other.SameReading(&base); got != tc.want {
+			t.Errorf("%s: SameReading is not symmetric", tc.name)
+		}
+	}
+}
+
+func TestWithDelimiter(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		delimiter string
+		response  string
+	}{
+		{"comma only", ",", "123456789012,2,21,39,27890,23189,28405,00,03,00,01\r\n"},
+		{"tab", "\t", "123456789012\t2\t21\t39\t27890\t23189\t28405\t00\t03\t00\t01\r\n"},
+		{"default", iotco1000.RESPONSE_DELIMITER, response},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var opts []iotco1000.Option
+			if tc.delimiter != iotco1000.RESPONSE_DELIMITER {
+				opts = append(opts, iotco1000.WithDelimiter(tc.delimiter))
+			}
+			aq, err := newFakeSensor(&fakePort{reads: []string{tc.response}}, opts...).AnalyzeAirQuality()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if aq.SensorSerialNumber != "123456789012" || aq.COConcentrationPPB != 2 || aq.TemperatureC != 21 || aq.RelativeHumidity != 39 || aq.Uptime != 3*time.Hour+time.Second {
+				t.Errorf("parsed %s", aq)
+			}
+			// the standard response does not split on the other delimiters
+			if tc.delimiter != iotco1000.RESPONSE_DELIMITER {
+				if _, err := iotco1000.ParseMeasurement(tc.response); !errors.Is(err, iotco1000.ErrMalformedResponse) {
+					t.Errorf("ParseMeasurement parsed a response delimited by %q", tc.delimiter)
+				}
+			}
+		})
+	}
+}
+
+func TestDetectDelimiter(t *testing.T) {
+	for _, tc := range []struct {
+		response string
+		want     string
+	}{
+		{response, iotco1000.RESPONSE_DELIMITER},
+		{"123456789012,2,21,39,27890,23189,28405,00,03,00,01\r\n", ","},
+		{"123456789012\t2\t21\t39\t27890\t23189\t28405\t00\t03\t00\t01\r\n", "\t"},
+	} {
+		if got := iotco1000.DetectDelimiter(tc.response); got != tc.want {
+			t.Errorf("DetectDelimiter(%q) = %q, want %q", tc.response, got, tc.want)
+		}
+	}
+}
+
+func TestSensorDetectDelimiter(t *testing.T) {
+	semicolons := "123456789012;2;21;39;27890;23189;28405;00;03;00;01\r\n"
+	sensor := newFakeSensor(&fakePort{reads: []string{semicolons, semicolons}}, iotco1000.WithSettleDelay(0))
+	d, err := sensor.DetectDelimiter(0)
+	if err != nil || d != ";" {
+		t.Fatalf("DetectDelimiter(0) = %q, %v; want \";\"", d, err)
+	}
+	if aq, err := sensor.AnalyzeAirQuality(); err != nil || aq.COConcentrationPPB != 2 {
+		t.Errorf("AnalyzeAirQuality() = %+v, %v after detecting the delimiter; want 2 ppb", aq, err)
+	}
+
+	// splits into the most fields by ", ", but has no serial number
+	sensor = newFakeSensor(&fakePort{reads: []string{"x, 2, 21\r\n"}}, iotco1000.WithSettleDelay(0))
+	if _, err := sensor.DetectDelimiter(0); !errors.Is(err, iotco1000.ErrMalformedResponse) {
+		t.Errorf("DetectDelimiter(0) = %v for an unparseable response, want ErrMalformedResponse", err)
+	}
+}
+
+func TestAnalyzeAirQualitySkipsEcho(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		port *fakePort
+		opts []iotco1000.Option
+		err  error
+	}{
+		{
+			name: "echoed trigger then data",
+			port: &fakePort{reads: []string{"\r\n" + response}},
+		},
+		{
+			name: "echoed trigger in its own read",
+			port: &fakePort{reads: []string{"\r\n", response}},
+		},
+		{
+			name: "echoed command then data",
+			port: &fakePort{reads: []string{"MEASURE\r\n" + response}},
+			opts: []iotco1000.Option{iotco1000.WithTrigger([]byte("MEASURE\r\n"))},
+		},
+		{
+			name: "noise then data",
+			port: &fakePort{reads: []string{"\x00\r\n", "boot ok\r\n" + response[:10], response[10:]}},
+		},
+		{
+			name: "data then padding",
+			port: &fakePort{reads: []string{response + "\x00\x00"}},
+		},
+		{
+			name: "echoes longer than the buffer",
+			port: &fakePort{reads: []string{"\r\n\r\n\r\n\r\n\r\n\r\n\r\n\r\n\r\n\r\n" + response}},
+			opts: []iotco1000.Option{iotco1000.WithMaxResponseBytes(len(response) + 4)},
+		},
+		{
+			name: "malformed with nothing after it",
+			port: &fakePort{reads: []string{"\r\n123456789012, garbled\r\n"}},
+			err:  iotco1000.ErrMalformedResponse,
+		},
+		{
+			name: "echo skipping disabled",
+			port: &fakePort{reads: []string{"\r\n" + response}},
+			opts: []iotco1000.Option{iotco1000.WithEchoSkipping(false)},
+			err:  iotco1000.ErrMalformedResponse,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			aq, err := newFakeSensor(tc.port, tc.opts...).AnalyzeAirQuality()
+			if tc.err != nil {
+				if !errors.Is(err, tc.err) {
+					t.Fatalf("got error %v, want %v", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if aq.SensorSerialNumber != "123456789012" || aq.COConcentrationPPB != 2 || aq.Uptime != 3*time.Hour+time.Second {
+				t.Errorf("parsed %s", aq)
+			}
+		})
+	}
+}
@@ -0,0 +1,277 @@
+package iotco1000
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A Parser converts a single response from the sensor into an
+// AirQualityMeasurement. MeasurementTime is populated by the caller.
+type Parser func(response string) (*AirQualityMeasurement, error)
+
+// DefaultResponseRegexp matches the IOT-CO-1000 response format while
+// tolerating variation in the delimiters and spacing between fields.
+var DefaultResponseRegexp = regexp.MustCompile(
+	`(?P<serial>\w+)\s*[,;\t ]\s*` +
+		`(?P<co>-?\d+)\s*[,;\t ]\s*` +
+		`(?P<temperature>-?\d+(?:\.\d+)?)\s*[,;\t ]\s*` +
+		`(?P<humidity>-?\d+(?:\.\d+)?)\s*[,;\t ]\s*` +
+		`(?:-?\d+\s*[,;\t ]\s*){3}` +
+		`(?P<days>\d+)\s*[,;\t ]\s*` +
+		`(?P<hours>\d+)\s*[,;\t ]\s*` +
+		`(?P<minutes>\d+)\s*[,;\t ]\s*` +
+		`(?P<seconds>\d+)`,
+)
+
+// RESPONSE_DELIMITER separates the fields of a response in the sensor's
+// standard format.
+const RESPONSE_DELIMITER = ", "
+
+// DELIMITER_CANDIDATES are the field delimiters recognized by
+// DetectDelimiter, in order of preference.
+var DELIMITER_CANDIDATES = []string{RESPONSE_DELIMITER, ",", ";", "\t", " "}
+
+// DetectDelimiter returns the candidate delimiter that splits response into
+// the most fields, preferring earlier candidates when several split it
+// equally.
+func DetectDelimiter(response string) string {
+	response = strings.Trim(response, " \r\n\x00")
+	best, bestFields := DELIMITER_CANDIDATES[0], 0
+	for _, d := range DELIMITER_CANDIDATES {
+		if n := len(strings.Split(response, d)); n > bestFields {
+			best, bestFields = d, n
+		}
+	}
+	return best
+}
+
+// The names of the measured fields of a response, as reported by
+// AirQualityMeasurement.InvalidFields.
+const (
+	FIELD_CO          = "co"
+	FIELD_TEMPERATURE = "temperature"
+	FIELD_HUMIDITY    = "humidity"
+)
+
+// responseFields holds the unconverted values of a sensor response.
+type responseFields struct {
+	serialNumber       string
+	COConcentrationPPB string
+	temperatureC       string
+	relativeHumidity   string
+	daysUp             string
+	hoursUp            string
+	minutesUp          string
+	secondsUp          string
+}
+
+// ParseMeasurement parses a response in the sensor's standard format. It
+// returns an error wrapping ErrMalformedResponse for any response it cannot
+// parse.
+func ParseMeasurement(response string) (*AirQualityMeasurement, error) {
+	return defaultParser(response)
+}
+
+var defaultParser = SplitParser(0)
+
+// SplitParser returns a Parser for the sensor's comma-separated response
+// format that reads the serial number from the field at index serialField.
+// The sensor itself reports its serial in field 0.
+func SplitParser(serialField int) Parser {
+	return DelimitedParser(RESPONSE_DELIMITER, serialField)
+}
+
+// DelimitedParser returns a Parser for responses in the sensor's standard
+// format, but with fields separated by delimiter and the serial number in the
+// field at index serialField.
+func DelimitedParser(delimiter string, serialField int) Parser {
+	fields := 11
+	if serialField >= fields {
+		fields = serialField + 1
+	}
+	return func(response string) (*AirQualityMeasurement, error) {
+		if serialField < 0 {
+			return nil, fmt.Errorf("%w: serial field index %d is negative", ErrMalformedResponse, serialField)
+		}
+		d := strings.Split(response, delimiter)
+		if len(d) < fields {
+			return nil, fmt.Errorf("%w: expected %d fields, got %d", ErrMalformedResponse, fields, len(d))
+		}
+		return measurementFromFields(&responseFields{
+			serialNumber:       strings.Trim(d[serialField], " \r\n\x00"),
+			COConcentrationPPB: d[1],
+			temperatureC:       d[2],
+			relativeHumidity:   d[3],
+			daysUp:             d[7],
+			hoursUp:            d[8],
+			minutesUp:          d[9],
+			secondsUp:          d[10],
+		})
+	}
+}
+
+// RegexParser returns a Parser that extracts fields from a response using re.
+// re must define the named groups serial, co, temperature, humidity, days,
+// hours, minutes and seconds.
+func RegexParser(re *regexp.Regexp) Parser {
+	return func(response string) (*AirQualityMeasurement, error) {
+		m := re.FindStringSubmatch(response)
+		if m == nil {
+			return nil, fmt.Errorf("%w: response (%q) does not match %s", ErrMalformedResponse, response, re)
+		}
+		group := func(name string) (string, error) {
+			i := re.SubexpIndex(name)
+			if i < 0 {
+				return "", fmt.Errorf("response regexp is missing named group %s", name)
+			}
+			return m[i], nil
+		}
+		f := &responseFields{}
+		for _, g := range []struct {
+			name  string
+			field *string
+		}{
+			{"serial", &f.serialNumber},
+			{"co", &f.COConcentrationPPB},
+			{"temperature", &f.temperatureC},
+			{"humidity", &f.relativeHumidity},
+			{"days", &f.daysUp},
+			{"hours", &f.hoursUp},
+			{"minutes", &f.minutesUp},
+			{"seconds", &f.secondsUp},
+		} {
+			v, err := group(g.name)
+			if err != nil {
+				return nil, err
+			}
+			*g.field = v
+		}
+		return measurementFromFields(f)
+	}
+}
+
+func measurementFromFields(f *responseFields) (*AirQualityMeasurement, error) {
+	if f.serialNumber == "" {
+		return nil, fmt.Errorf("%w: empty serial number", ErrMalformedResponse)
+	}
+	// Failures to convert the measured values are collected, rather than
+	// returned immediately, so that a FieldError can hold the rest.
+	fieldErr := &FieldError{}
+	invalid := func(field string, err error) {
+		if fieldErr.err == nil {
+			fieldErr.err = err
+		}
+		fieldErr.Fields = append(fieldErr.Fields, field)
+	}
+	COInt, err := strconv.ParseInt(f.COConcentrationPPB, 10, 32)
+	if err != nil {
+		invalid(FIELD_CO, fmt.Errorf("%w: failed converting CO concentration (%s) to int", ErrMalformedResponse, f.COConcentrationPPB))
+	}
+	temperatureC, err := parseDecimal(f.temperatureC)
+	if err != nil {
+		invalid(FIELD_TEMPERATURE, fmt.Errorf("%w: failed converting temperature (%s) to a number", ErrMalformedResponse, f.temperatureC))
+	}
+	relativeHumidity, err := parseDecimal(f.relativeHumidity)
+	if err != nil {
+		invalid(FIELD_HUMIDITY, fmt.Errorf("%w: failed converting relative humidity (%s) to a number", ErrMalformedResponse, f.relativeHumidity))
+	}
+	daysUpInt, err := strconv.ParseUint(f.daysUp, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed converting days up (%s) to int", ErrMalformedResponse, f.daysUp)
+	}
+	hoursUpInt, err := strconv.ParseUint(f.hoursUp, 10, 8)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed converting hours up (%s) to int", ErrMalformedResponse, f.hoursUp)
+	}
+	minutesUpInt, err := strconv.ParseUint(f.minutesUp, 10, 8)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed converting minutes up (%s) to int", ErrMalformedResponse, f.minutesUp)
+	}
+	secondsUp := strings.Trim(f.secondsUp, " \r\n\x00")
+	secondsUpInt, err := strconv.ParseUint(secondsUp, 10, 8)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed converting seconds up (%s) to int", ErrMalformedResponse, secondsUp)
+	}
+	uptime := time.Duration(daysUpInt*24+hoursUpInt)*time.Hour +
+		time.Duration(minutesUpInt)*time.Minute +
+		time.Duration(secondsUpInt)*time.Second
+
+	aq := &AirQualityMeasurement{
+		SensorSerialNumber: f.serialNumber,
+		COConcentrationPPB: int(COInt),
+		TemperatureC:       int(math.Round(temperatureC)),
+		RelativeHumidity:   int(math.Round(relativeHumidity)),
+		Uptime:             uptime,
+
+		TemperatureCFloat:     temperatureC,
+		RelativeHumidityFloat: relativeHumidity,
+
+		UptimeComponents: UptimeComponents{
+			Days:    int(daysUpInt),
+			Hours:   int(hoursUpInt),
+			Minutes: int(minutesUpInt),
+			Seconds: int(secondsUpInt),
+		},
+
+		// Zero humidity does not occur outside of a desiccator, so zero
+		// for all three is the booting firmware, not the environment.
+		Placeholder: COInt == 0 && temperatureC == 0 && relativeHumidity == 0 && fieldErr.err == nil,
+	}
+	if fieldErr.err != nil {
+		fieldErr.Measurement = aq
+		aq.InvalidFields = fieldErr.Fields
+		return nil, fieldErr
+	}
+	return aq, nil
+}
+
+// parseDecimal parses s as an integer, or as a decimal number if it has a
+// decimal point, as some firmware reports fractional values. Values must fit
+// in an int8 either way.
+func parseDecimal(s string) (float64, error) {
+	if !strings.Contains(s, ".") {
+		n, err := strconv.ParseInt(s, 10, 8)
+		return float64(n), err
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	if math.IsNaN(f) || f < math.MinInt8 || f > math.MaxInt8 {
+		return 0, fmt.Errorf("%s is out of range", s)
+	}
+	return f, nil
+}
+
+// reportedInterval parses the field at index field of response as the
+// sensor's measurement interval in seconds. It returns 0 if the field is
+// absent or not a positive integer, as not all firmware reports it.
+func reportedInterval(response, delimiter string, field int) time.Duration {
+	d := strings.Split(strings.TrimRight(response, " \r\n\x00"), delimiter)
+	if field < 0 || field >= len(d) {
+		return 0
+	}
+	seconds, err := strconv.Atoi(strings.TrimSpace(d[field]))
+	if err != nil || seconds <= 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// optionalField parses the response field at index field as a number,
+// returning nil if the response has no such field or it is not a number.
+func optionalField(response, delimiter string, field int) *float64 {
+	d := strings.Split(strings.TrimRight(response, " \r\n\x00"), delimiter)
+	if field < 0 || field >= len(d) {
+		return nil
+	}
+	v, err := strconv.ParseFloat(strings.TrimSpace(d[field]), 64)
+	if err != nil {
+		return nil
+	}
+	return &v
+}
@@ -0,0 +1,87 @@
+package iotco1000
+
+import (
+	"errors"
+	"testing"
+)
+
+// capturedResponses are responses captured from sensors, used to seed the
+// parser fuzz tests.
+var capturedResponses = []string{
+	"123456789012, 2, 21, 39, 27890, 23189, 28405, 00, 03, 00, 01\r\n",
+	"123456789012, -3, 24, 45, 27921, 23210, 28511, 12, 23, 59, 59\r\n",
+	"123456789012, 0, 0, 0, 0, 0, 0, 00, 00, 00, 05\r\n",
+	"123456789012, 14, 21.5, 38.7, 27890, 23189, 28405, 01, 02, 03, 04\r\n\x00\x00",
+	"123456789012,2,21,39,27890,23189,28405,00,03,00,01\r\n",
+	"123456789012\t2\t21\t39\t27890\t23189\t28405\t00\t03\t00\t01\r\n",
+	"ERROR: sensor not ready\r\n",
+	"123456789012, 2, 21\r\n",
+	"",
+}
+
+func FuzzParseMeasurement(f *testing.F) {
+	for _, r := range capturedResponses {
+		f.Add(r)
+	}
+	f.Fuzz(func(t *testing.T, response string) {
+		aq, err := ParseMeasurement(response)
+		if err != nil {
+			if !errors.Is(err, ErrMalformedResponse) {
+				t.Fatalf("ParseMeasurement(%q) returned an error not wrapping ErrMalformedResponse: %s", response, err)
+			}
+			return
+		}
+		if aq == nil {
+			t.Fatalf("ParseMeasurement(%q) returned neither a measurement nor an error", response)
+		}
+		if aq.SensorSerialNumber == "" {
+			t.Fatalf("ParseMeasurement(%q) returned a measurement without a serial number", response)
+		}
+	})
+}
+
+func FuzzDelimitedParser(f *testing.F) {
+	for _, r := range capturedResponses {
+		for _, d := range DELIMITER_CANDIDATES {
+			f.Add(r, d, 0)
+		}
+	}
+	f.Add(capturedResponses[0], ", ", -1)
+	f.Add(capturedResponses[0], ", ", 11)
+	f.Add(capturedResponses[0], "", 0)
+	f.Fuzz(func(t *testing.T, response, delimiter string, serialField int) {
+		aq, err := DelimitedParser(delimiter, serialField)(response)
+		if err != nil {
+			if !errors.Is(err, ErrMalformedResponse) {
+				t.Fatalf("parsing %q split on %q returned an error not wrapping ErrMalformedResponse: %s", response, delimiter, err)
+			}
+		} else if aq == nil {
+			t.Fatalf("parsing %q split on %q returned neither a measurement nor an error", response, delimiter)
+		}
+		DetectDelimiter(response)
+		optionalField(response, delimiter, serialField)
+		reportedInterval(response, delimiter, serialField)
+	})
+}
+
+func TestParseMeasurementSeeds(t *testing.T) {
+	for _, tc := range []struct {
+		response string
+		serial   string
+		co       int
+		uptime   string
+	}{
+		{capturedResponses[0], "123456789012", 2, "3h0m1s"},
+		{capturedResponses[1], "123456789012", -3, "311h59m59s"},
+		{capturedResponses[3], "123456789012", 14, "26h3m4s"},
+	} {
+		aq, err := ParseMeasurement(tc.response)
+		if err != nil {
+			t.Errorf("ParseMeasurement(%q): %s", tc.response, err)
+			continue
+		}
+		if aq.SensorSerialNumber != tc.serial || aq.COConcentrationPPB != tc.co || aq.Uptime.String() != tc.uptime {
+			t.Errorf("ParseMeasurement(%q) = serial %s, CO %d, uptime %s; want %s, %d, %s", tc.response, aq.SensorSerialNumber, aq.COConcentrationPPB, aq.Uptime, tc.serial, tc.co, tc.uptime)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package iotco1000
+
+import (
+	"context"
+	"time"
+)
+
+// Reading is the outcome of a single attempt to measure air quality. Exactly
+// one of Measurement and Err is set.
+type Reading struct {
+	Measurement *AirQualityMeasurement
+	Err         error
+}
+
+// Readings takes a measurement every interval and delivers the result on the
+// returned channel until ctx is cancelled, at which point the channel is
+// closed. The caller must keep receiving from the channel until it is closed
+// or ctx is cancelled.
+func (co *IOTCO1000) Readings(ctx context.Context, interval time.Duration) <-chan Reading {
+	ch := make(chan Reading)
+	go func() {
+		defer close(ch)
+		ticker := co.clock.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			aq, err := co.AnalyzeAirQuality()
+			select {
+			case ch <- Reading{Measurement: aq, Err: err}:
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case <-ticker.C():
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}
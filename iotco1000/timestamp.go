@@ -0,0 +1,48 @@
+package iotco1000
+
+import (
+	"fmt"
+	"time"
+)
+
+// TimestampSource selects how MeasurementTime is assigned.
+//
+// TimestampWallClock uses the system clock at the time of each reading. It is
+// correct whenever the clock is, but a clock that is stepped, e.g. when NTP
+// first synchronizes a device without a real-time clock, moves readings with
+// it.
+//
+// TimestampMonotonic uses the wall clock time the sensor was opened plus the
+// time elapsed since, measured by the monotonic clock. Readings keep their
+// relative spacing regardless of clock steps, at the cost of being offset by
+// however wrong the clock was when the sensor was opened.
+type TimestampSource string
+
+const (
+	TimestampWallClock TimestampSource = "wallclock"
+	TimestampMonotonic TimestampSource = "monotonic"
+)
+
+func ParseTimestampSource(s string) (TimestampSource, error) {
+	switch src := TimestampSource(s); src {
+	case TimestampWallClock, TimestampMonotonic:
+		return src, nil
+	}
+	return "", fmt.Errorf("unknown timestamp source %q; must be one of wallclock, monotonic", s)
+}
+
+// WithTimestampSource selects how MeasurementTime is assigned. The default is
+// TimestampWallClock.
+func WithTimestampSource(src TimestampSource) Option {
+	return func(co *IOTCO1000) {
+		co.timestampSource = src
+	}
+}
+
+// timestamp returns the MeasurementTime of a reading taken at t.
+func (co *IOTCO1000) timestamp(t time.Time) time.Time {
+	if co.timestampSource == TimestampMonotonic {
+		return co.epoch.Add(t.Sub(co.epoch)).Round(0)
+	}
+	return t
+}
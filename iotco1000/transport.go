@@ -0,0 +1,78 @@
+package iotco1000
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/tarm/serial"
+)
+
+// TCP_URL_PREFIX marks a device path as a TCP address, e.g. a ser2net port,
+// rather than a local serial device.
+const TCP_URL_PREFIX = "tcp://"
+
+// READ_TIMEOUT is how long a single read from the sensor blocks before
+// returning no data.
+const READ_TIMEOUT = 250 * time.Millisecond
+
+// deadlineReader is a connection whose reads can be given a deadline, such as
+// a net.Conn or a FIFO opened with os.OpenFile.
+type deadlineReader interface {
+	io.ReadWriteCloser
+	SetReadDeadline(t time.Time) error
+}
+
+// timeoutPort makes a deadlineReader behave like a serial port opened with a
+// read timeout: a read that times out returns no data rather than an error.
+type timeoutPort struct {
+	deadlineReader
+}
+
+func (p timeoutPort) Read(b []byte) (int, error) {
+	if err := p.SetReadDeadline(time.Now().Add(READ_TIMEOUT)); err != nil {
+		return 0, err
+	}
+	n, err := p.deadlineReader.Read(b)
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return n, nil
+	}
+	return n, err
+}
+
+// fifoPort is a timeoutPort reading responses from a named pipe. A FIFO opened
+// read-write reads back whatever is written to it, so commands are discarded
+// rather than sent: whatever feeds the pipe must write a response for each
+// poll unprompted.
+type fifoPort struct {
+	timeoutPort
+}
+
+func (p fifoPort) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+// openDevice opens devicePath as a TCP connection if it is a tcp:// URL, as a
+// named pipe if it is a FIFO, and as a serial port otherwise.
+func openDevice(devicePath string, config *serial.Config) (io.ReadWriteCloser, error) {
+	if strings.HasPrefix(devicePath, TCP_URL_PREFIX) {
+		conn, err := net.DialTimeout("tcp", strings.TrimPrefix(devicePath, TCP_URL_PREFIX), RESPONSE_TIMEOUT)
+		if err != nil {
+			return nil, err
+		}
+		return timeoutPort{conn}, nil
+	}
+	if fi, err := os.Stat(devicePath); err == nil && fi.Mode()&os.ModeNamedPipe != 0 {
+		// Opening read-write keeps the open from blocking until a writer
+		// appears, and keeps reads from seeing EOF when the writer goes away.
+		f, err := os.OpenFile(devicePath, os.O_RDWR, 0)
+		if err != nil {
+			return nil, err
+		}
+		return fifoPort{timeoutPort{f}}, nil
+	}
+	return serial.OpenPort(config)
+}
@@ -0,0 +1,52 @@
+package iotco1000
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestOpenDeviceFIFODiscardsCommands(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sensor")
+	if err := syscall.Mkfifo(path, 0600); err != nil {
+		t.Skipf("cannot create a FIFO: %s", err)
+	}
+	port, err := openDevice(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer port.Close()
+
+	if _, err := port.Write([]byte("\r")); err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, 64)
+	if n, err := port.Read(b); err != nil || n != 0 {
+		t.Fatalf("read %q, %v after sending a command; want nothing read back", b[:n], err)
+	}
+
+	writer, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+	response := "123456789012, 2, 21, 39, 27890, 23189, 28405, 00, 03, 00, 01\r\n"
+	if _, err := writer.Write([]byte(response)); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := port.Read(b); err != nil || string(b[:n]) != response {
+		t.Errorf("read %q, %v; want %q", b[:n], err, response)
+	}
+}
+
+func TestNewWithoutDeviceReturnsErrNoDevice(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := New(filepath.Join(dir, "ttyUSB0")); !errors.Is(err, ErrNoDevice) {
+		t.Errorf("New() = %v for a missing device, want ErrNoDevice", err)
+	}
+	if _, err := New("", WithDeviceGlob(filepath.Join(dir, "ttyUSB*"))); !errors.Is(err, ErrNoDevice) {
+		t.Errorf("New() = %v with no device matching the glob, want ErrNoDevice", err)
+	}
+}
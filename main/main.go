@@ -2,219 +2,1805 @@ package main
 
 import (
 	"context"
+	_ "embed"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"regexp"
+	"runtime/debug"
+	"sort"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
-	cwtypes "github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
-
+	"github.com/jkoelndorfer/aqgo/config"
+	"github.com/jkoelndorfer/aqgo/daemon"
 	"github.com/jkoelndorfer/aqgo/iotco1000"
+	"github.com/jkoelndorfer/aqgo/simulator"
+	"github.com/jkoelndorfer/aqgo/sink"
 )
 
-var CO_CONCENTRATION_PPB = "COConcentrationPPB"
-var TEMPERATURE_C = "TemperatureC"
-var RELATIVE_HUMIDITY = "RelativeHumidity"
-var UPTIME = "Uptime"
-var SENSOR_ID = "SensorID"
-var SENSOR_WARMED_UP = "SensorWarmedUp"
-
 type ApplicationArguments struct {
-	PollInterval     int
-	MetricNamespace  string
-	SerialDevicePath string
+	PollInterval           int
+	MetricNamespace        string
+	SerialDevicePath       string
+	ResponseRegexp         *regexp.Regexp
+	KafkaBrokers           []string
+	KafkaTopic             string
+	KafkaEncoding          sink.Encoding
+	SQSQueueURL            string
+	SQSBatchDelay          time.Duration
+	S3Bucket               string
+	S3Prefix               string
+	S3FlushInterval        time.Duration
+	ArchiveFormat          sink.ArchiveFormat
+	IoTEndpoint            string
+	IoTTopic               string
+	IoTThingName           string
+	IoTUpdateShadow        bool
+	IoTCertFile            string
+	IoTKeyFile             string
+	IoTCAFile              string
+	GRPCAddr               string
+	HTTPAddr               string
+	PushgatewayURL         string
+	PushgatewayJob         string
+	RemoteWriteURL         string
+	RemoteWriteUsername    string
+	RemoteWritePassword    string
+	RemoteWriteBearerToken string
+	Dedup                  bool
+	DedupMaxInterval       time.Duration
+	FirmwareInfo           bool
+	Calibrate              bool
+	AutoCalibrateInterval  time.Duration
+	CloudWatchTimeout      time.Duration
+	SubmitQueueDepth       int
+	Host                   string
+	Location               string
+	LocationMap            map[string]string
+	FallbackFile           string
+	SpoolCompress          bool
+	WarmupSubmitRaw        bool
+	Probe                  bool
+	ProbeTimeout           time.Duration
+	SettleDelay            time.Duration
+	RequireClockSync       bool
+	MaxMeasurementAge      time.Duration
+	Check                  bool
+	OpenRetryTimeout       time.Duration
+	LogGroup               string
+	LogStream              string
+	LogBatchDelay          time.Duration
+	Flags                  map[string]string
+	Config                 config.Config
+	Warnings               []string
+	SampleCount            int
+	MaxRuntime             time.Duration
+	Stdout                 bool
+	StdoutFormat           sink.StdoutFormat
+	DisplayTimezone        *time.Location
+	SubmitWorkers          int
+	SerialField            int
+	SerialOverride         string
+	JSONPretty             bool
+	COMinDelta             int
+	TemperatureMinDelta    float64
+	HumidityMinDelta       float64
+	IntervalField          int
+	PressureField          int
+	VOCField               int
+	AlignPollInterval      bool
+	MalformedRetries       int
+	WarmUpDuration         time.Duration
+	AWSTargets             map[string]sink.AWSTarget
+	Resolutions            map[string]int32
+	StartupEvent           bool
+	COVarianceWindow       int
+	DumpFields             bool
+	Delimiter              string
+	PrintConfig            bool
+	CloudWatchNamespaces   map[string]sink.AWSTarget
+	CONegativePolicy       sink.CONegativePolicy
+	COUnit                 sink.COUnit
+	OutputFile             string
+	FileRotation           sink.FileRotation
+	DisabledSinks          []string
+	MQTTBroker             string
+	MQTTTopic              string
+	MQTTClientID           string
+	MQTTUsername           string
+	MQTTPassword           string
+	MQTTTLS                sink.MQTTTLSOptions
+	KeepaliveInterval      time.Duration
+	AdaptivePoll           bool
+	AdaptivePollMin        time.Duration
+	AdaptivePollMax        time.Duration
+	AdaptivePollThreshold  int
+	MaxResponseBytes       int
+	StaticMetrics          map[string]float64
+	TimestampSource        iotco1000.TimestampSource
+	Provenance             bool
+	SerialDeviceGlob       string
+	AlertWebhookURL        string
+	AlertCOThreshold       int
+	AlertDuringWarmUp      bool
+	AlertNotifyActive      bool
+	ReadRetryDelay         time.Duration
+	Journal                bool
+	SuppressWarmUpMetrics  bool
+	ShutdownTimeout        time.Duration
+	StartupDelay           time.Duration
+	SubmitRawAlongside     bool
+	LenientParsing         bool
+	ConfigCheck            bool
+	SubmitUptimeComponents bool
+	StateFile              string
+	DelimiterAuto          bool
+	NATSURL                string
+	NATSSubject            string
+	NATSToken              string
+	NATSCredsFile          string
+	COPercentiles          []float64
+	COPercentileWindow     time.Duration
+	Read                   bool
+	ReadFormat             string
+	FlushAlign             bool
+	MaxDatumsPerRequest    int
+	HoldLastValue          time.Duration
+	ExitOnOpenFailure      bool
+	HealthScoreWeights     daemon.HealthScoreWeights
+	SkipEcho               bool
+	ReferenceURL           string
+	ReferenceInterval      time.Duration
+	MinUptimeBeforeSubmit  time.Duration
+	Environment            string
+	ReplayPath             string
+	ReplayFollow           bool
+	ReplaySpoolPath        string
+	DeviceAliases          map[string]string
+	SerialRegistryDir      string
 }
 
+//go:embed dashboard.html
+var dashboardHTML []byte
+
 func main() {
 	logger := log.New(os.Stderr, "", log.Ldate|log.Ltime|log.Lshortfile)
-	args, err := parseArguments()
+	command, argv := commandArguments(os.Args[1:])
+	switch command {
+	case "help":
+		printSubcommands(os.Stdout)
+		return
+	case "simulate":
+		if err := simulate(argv); err != nil {
+			logger.Fatal(err)
+		}
+		return
+	case "list-ports":
+		devices, err := iotco1000.DetectSerialDevices()
+		if err != nil {
+			logger.Fatal(err)
+		}
+		for _, device := range devices {
+			fmt.Println(device)
+		}
+		return
+	}
+	args, err := parseArguments(argv)
 	if err != nil {
 		logger.Fatal(err)
 	}
+	// warnings are logged once, not again as the configuration is reloaded
+	for _, warning := range args.Warnings {
+		logger.Printf("warning: %s\n", warning)
+	}
 
-	cw, err := newCloudWatchClient()
-	if err != nil {
-		logger.Fatal("failed creating CloudWatch client")
+	if args.PrintConfig {
+		out, err := json.MarshalIndent(redactedFlags(args.Flags), "", "  ")
+		if err != nil {
+			logger.Fatal(err)
+		}
+		fmt.Println(string(out))
+		return
+	}
+	if args.ConfigCheck {
+		if !configCheck(args, logger) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if args.Check {
+		cw, err := sink.NewCloudWatchSink(args.MetricNamespace, args.CloudWatchTimeout, args.Host)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		if err := cw.Check(context.TODO()); err != nil {
+			logger.Fatalf("pre-flight check failed: %s", err)
+		}
+		fmt.Println("pre-flight check passed")
+		return
+	}
+	if args.ReplayPath != "" {
+		if err := replay(args, logger); err != nil {
+			logger.Fatal(err)
+		}
+		return
+	}
+	if args.ReplaySpoolPath != "" {
+		if err := replaySpool(args, logger); err != nil {
+			logger.Fatal(err)
+		}
+		return
 	}
 
-	sensor, err := iotco1000.New(args.SerialDevicePath)
+	if args.SerialDevicePath == "" && args.SerialDeviceGlob == "" {
+		path, err := detectSerialDevice()
+		if err != nil {
+			logger.Fatal(err)
+		}
+		logger.Printf("using detected serial device %s\n", path)
+		args.SerialDevicePath = path
+	}
+
+	sensorOpts := []iotco1000.Option{
+		iotco1000.WithSettleDelay(args.SettleDelay),
+		iotco1000.WithOpenRetry(args.OpenRetryTimeout),
+		iotco1000.WithMaxResponseBytes(args.MaxResponseBytes),
+		iotco1000.WithTimestampSource(args.TimestampSource),
+	}
+	device := args.SerialDevicePath
+	// wait for a matching device to be plugged in
+	retryOpen := args.SerialDeviceGlob != "" && args.OpenRetryTimeout == 0
+	if args.SerialDeviceGlob != "" {
+		sensorOpts = append(sensorOpts, iotco1000.WithDeviceGlob(args.SerialDeviceGlob))
+		device = args.SerialDeviceGlob
+	}
+	if retryOpen || !args.ExitOnOpenFailure {
+		logger.Printf("will retry opening serial device %s until it can be opened\n", device)
+		sensorOpts = append(sensorOpts, iotco1000.WithOpenRetry(-1))
+	} else {
+		logger.Printf("will exit if serial device %s cannot be opened\n", device)
+	}
+	if args.ResponseRegexp != nil {
+		sensorOpts = append(sensorOpts, iotco1000.WithRegexParser(args.ResponseRegexp))
+	}
+	if args.Delimiter != iotco1000.RESPONSE_DELIMITER {
+		sensorOpts = append(sensorOpts, iotco1000.WithDelimiter(args.Delimiter))
+	}
+	if args.SerialField != 0 {
+		sensorOpts = append(sensorOpts, iotco1000.WithParser(iotco1000.DelimitedParser(args.Delimiter, args.SerialField)))
+	}
+	if args.IntervalField >= 0 {
+		sensorOpts = append(sensorOpts, iotco1000.WithIntervalField(args.IntervalField))
+	}
+	if args.PressureField >= 0 {
+		sensorOpts = append(sensorOpts, iotco1000.WithPressureField(args.PressureField))
+	}
+	if args.VOCField >= 0 {
+		sensorOpts = append(sensorOpts, iotco1000.WithVOCField(args.VOCField))
+	}
+	if args.SerialOverride != "" {
+		sensorOpts = append(sensorOpts, iotco1000.WithSerialOverride(args.SerialOverride))
+	}
+	if args.LogGroup != "" || args.Provenance {
+		sensorOpts = append(sensorOpts, iotco1000.WithKeepRaw())
+	}
+	if !args.SkipEcho {
+		sensorOpts = append(sensorOpts, iotco1000.WithEchoSkipping(false))
+	}
+	if args.LenientParsing {
+		sensorOpts = append(sensorOpts, iotco1000.WithLenientParsing())
+	}
+	sensor, err := iotco1000.New(args.SerialDevicePath, sensorOpts...)
 	if err != nil {
 		logger.Fatal(err)
 	}
+	if args.SerialDeviceGlob != "" {
+		logger.Printf("serial device %s attached\n", sensor.DevicePath())
+	}
 	defer sensor.Close()
 
-	ch := make(chan *iotco1000.AirQualityMeasurement)
-	go submitMetricsToCloudWatch(logger, cw, args.MetricNamespace, ch)
-	for {
-		aq, err := sensor.AnalyzeAirQuality()
+	if args.FirmwareInfo {
+		info, err := sensor.FirmwareInfo()
 		if err != nil {
-			logger.Println(err)
-		} else {
-			ch <- aq
+			logger.Fatal(err)
+		}
+		fmt.Println(info)
+		return
+	}
+	if args.DumpFields {
+		if err := dumpFields(sensor); err != nil {
+			logger.Fatal(err)
 		}
-		time.Sleep(time.Duration(args.PollInterval) * time.Millisecond)
+		return
 	}
+	if args.DelimiterAuto {
+		delimiter, err := sensor.DetectDelimiter(args.SerialField)
+		if err != nil {
+			logger.Fatalf("failed detecting delimiter: %s", err)
+		}
+		logger.Printf("detected field delimiter %q\n", delimiter)
+	}
+
+	if args.Read {
+		aq, err := probeSensor(sensor, args.ProbeTimeout)
+		if err != nil {
+			logger.Fatalf("failed reading sensor: %s", err)
+		}
+		if args.ReadFormat == "human" {
+			fmt.Println(aq)
+			return
+		}
+		r := &sink.Reading{
+			AirQualityMeasurement: aq,
+			SensorWarmedUp:        aq.WarmedUp(args.WarmUpDuration),
+			Location:              args.Location,
+			Environment:           args.Environment,
+		}
+		if err := sink.NewStdoutSink(sink.StdoutFormat(args.ReadFormat), args.DisplayTimezone).Submit(context.TODO(), r); err != nil {
+			logger.Fatal(err)
+		}
+		return
+	}
+	if args.Probe {
+		aq, err := probeSensor(sensor, args.ProbeTimeout)
+		if err != nil {
+			logger.Fatalf("probe failed: %s", err)
+		}
+		fmt.Printf("found sensor %s (uptime %s)\n", aq.SensorSerialNumber, aq.Uptime)
+		return
+	}
+	var state *daemon.State
+	if args.StateFile != "" {
+		state, err = daemon.LoadState(args.StateFile)
+		if err != nil {
+			logger.Fatalf("failed loading state: %s", err)
+		}
+	}
+	if args.Calibrate {
+		logger.Println("zero calibrating sensor; this must only be done in clean air")
+		if err := sensor.Calibrate(); err != nil {
+			logger.Fatal(err)
+		}
+		logger.Println("zero calibration complete")
+		if state != nil {
+			// the serial number the calibration is recorded under is only
+			// known from a reading
+			aq, err := sensor.AnalyzeAirQuality()
+			if err != nil {
+				logger.Fatalf("failed reading the sensor serial number to record the calibration: %s", err)
+			}
+			if err := state.RecordCalibration(aq.SensorSerialNumber, time.Now()); err != nil {
+				logger.Fatalf("failed saving state: %s", err)
+			}
+		}
+		return
+	}
+
+	sinks, _, err := newSinks(args, logger)
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	var httpServer *http.Server
+	if args.HTTPAddr != "" {
+		ws := sink.NewWebSocketSink()
+		status := sink.NewStatusSink(version(), args.WarmUpDuration)
+		sinks = append(sinks, ws, status)
+		httpServer = newHTTPServer(args.HTTPAddr, ws, status)
+		go func() {
+			if err := httpServer.ListenAndServe(); err != http.ErrServerClosed {
+				logger.Fatal(err)
+			}
+		}()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if args.MaxRuntime > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, args.MaxRuntime)
+		defer cancel()
+	}
+
+	fanout := sink.NewFanout(args.SubmitWorkers, sinks...)
+	fanout.QueueDepth = args.SubmitQueueDepth
+	fanout.Logger = logger
+	fanout.Disable(args.DisabledSinks)
+	logger.Printf("active sinks: %s\n", strings.Join(fanout.Active(), ", "))
+	var firmware string
+	if args.StartupEvent {
+		firmware, err = sensor.FirmwareInfo()
+		if err != nil {
+			logger.Printf("failed querying firmware info for the startup event: %s\n", err)
+		}
+	}
+	reload := make(chan daemon.Config)
+	go reloadArguments(ctx, logger, args, func(newArgs *ApplicationArguments) daemon.Config {
+		return runConfig(newArgs, logger, sensor, fanout)
+	}, reload)
+	cfg := runConfig(args, logger, sensor, fanout)
+	cfg.Reload = reload
+	cfg.State = state
+	if args.SerialRegistryDir != "" {
+		cfg.SerialRegistry, err = daemon.NewSerialRegistry(args.SerialRegistryDir)
+		if err != nil {
+			logger.Printf("%s; duplicate serial numbers will not be detected\n", err)
+		}
+	}
+	if args.ReferenceURL != "" {
+		cfg.Reference = daemon.NewReference(args.ReferenceURL, args.ReferenceInterval, logger)
+		go cfg.Reference.Run(ctx)
+	}
+	if args.StartupEvent {
+		cfg.OnStartup = func(r *sink.Reading) {
+			logStartupEvent(logger, args, r, firmware, fanout)
+		}
+	}
+	if err := daemon.Run(ctx, cfg, fanout); err != nil {
+		logger.Fatal(err)
+	}
+	stoppers := []stopper{}
+	if httpServer != nil {
+		stoppers = append(stoppers, stopper{"HTTP server", httpServer.Shutdown})
+	}
+	stoppers = append(stoppers, stopper{"sink queues", fanout.Drain})
+	for _, s := range fanout.Sinks {
+		s := s
+		stoppers = append(stoppers, stopper{"sink " + s.Name(), func(context.Context) error {
+			return s.Close()
+		}})
+	}
+	if state != nil {
+		stoppers = append(stoppers, stopper{"state file", func(context.Context) error {
+			return state.Save()
+		}})
+	}
+	shutdown(logger, args.ShutdownTimeout, stoppers...)
 }
 
-func submitMetricsToCloudWatch(logger *log.Logger, cw *cloudwatch.Client, ns string, ch chan *iotco1000.AirQualityMeasurement) {
-	loggedSensorNotWarmedUp := false
-	loggedSensorActive := false
-	warmUpDuration := time.Hour * 2
-	for {
-		aq := <-ch
-		var params *cloudwatch.PutMetricDataInput
-
-		if aq.Uptime < warmUpDuration {
-			if !loggedSensorNotWarmedUp {
-				// sensor readings made when the IOTCO1000 sensor has recently powered on are not accurate
-				logger.Printf("skipping metric submission because sensor has not been active for warm up duration %s\n", warmUpDuration)
-				loggedSensorNotWarmedUp = true
+// stopper is a subsystem that is stopped on shutdown.
+type stopper struct {
+	name string
+	stop func(ctx context.Context) error
+}
+
+// shutdown stops each of stoppers in order, giving each its own timeout, so
+// that one that overruns does not leave the rest no time at all. The context
+// of a stopper is cancelled once its timeout has elapsed, but it is still
+// waited for, since a sink that is closing may be flushing readings. Those
+// that fail or overrun are logged.
+func shutdown(logger *log.Logger, timeout time.Duration, stoppers ...stopper) {
+	for _, s := range stoppers {
+		ctx, cancel := context.Background(), context.CancelFunc(func() {})
+		if timeout > 0 {
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+		}
+		errc := make(chan error, 1)
+		go func(s stopper) {
+			errc <- s.stop(ctx)
+		}(s)
+		var err error
+		select {
+		case err = <-errc:
+		case <-ctx.Done():
+			logger.Printf("%s did not stop within shutdown timeout %s; waiting for it\n", s.name, timeout)
+			err = <-errc
+		}
+		cancel()
+		if err != nil {
+			logger.Printf("error stopping %s: %s\n", s.name, err)
+		}
+	}
+}
+
+// logStartupEvent logs a JSON summary of the device and its configuration,
+// once its first reading is about to be submitted.
+func logStartupEvent(logger *log.Logger, args *ApplicationArguments, r *sink.Reading, firmware string, fanout *sink.Fanout) {
+	sinks := []string{}
+	region := ""
+	for _, s := range fanout.Sinks {
+		sinks = append(sinks, s.Name())
+		if cw, ok := s.(*sink.CloudWatchSink); ok && region == "" {
+			region = cw.Region
+		}
+	}
+	event, err := json.Marshal(map[string]interface{}{
+		"event":                "startup",
+		"sensor_serial_number": r.SensorSerialNumber,
+		"firmware":             firmware,
+		"poll_interval_ms":     args.PollInterval,
+		"warmup_seconds":       args.WarmUpDuration.Seconds(),
+		"sinks":                sinks,
+		"aws_region":           region,
+	})
+	if err != nil {
+		logger.Printf("failed encoding startup event: %s\n", err)
+		return
+	}
+	logger.Println(string(event))
+}
+
+// SUBCOMMANDS are the subcommands aqgo accepts as its first argument. Those
+// that take the daemon's flags run the one-shot mode of the same name, so
+// "aqgo calibrate" is "aqgo -calibrate".
+var SUBCOMMANDS = []struct {
+	Name        string
+	Description string
+	DaemonFlags bool
+}{
+	{"run", "poll the sensor and submit readings; the default", true},
+	{"read", "take a single reading and print it", true},
+	{"calibrate", "zero calibrate the sensor", true},
+	{"probe", "verify that a sensor is attached", true},
+	{"firmware-info", "print the sensor firmware information", true},
+	{"dump-fields", "print each field of a raw sensor response", true},
+	{"config-check", "check the configuration and the reachability of each sink", true},
+	{"list-ports", "list the serial devices a sensor may be attached to", false},
+	{"simulate", "run a simulated sensor; see aqgo simulate -h", false},
+	{"help", "list the subcommands", false},
+}
+
+// commandArguments splits the command line arguments into the subcommand
+// and the arguments for it. Without a subcommand, as when the first argument
+// is a flag, the subcommand is run. One-shot subcommands are translated to
+// their flag so that they share the daemon's flags.
+func commandArguments(argv []string) (string, []string) {
+	if len(argv) == 0 || strings.HasPrefix(argv[0], "-") {
+		return "run", argv
+	}
+	for _, c := range SUBCOMMANDS {
+		if c.Name != argv[0] {
+			continue
+		}
+		if c.DaemonFlags && c.Name != "run" {
+			return c.Name, append([]string{"-" + c.Name}, argv[1:]...)
+		}
+		return c.Name, argv[1:]
+	}
+	fmt.Fprintf(os.Stderr, "unknown subcommand %q\n\n", argv[0])
+	printSubcommands(os.Stderr)
+	os.Exit(2)
+	return "", nil
+}
+
+func printSubcommands(w io.Writer) {
+	fmt.Fprintf(w, "usage: %s [subcommand] [flags]\n\nsubcommands:\n", os.Args[0])
+	for _, c := range SUBCOMMANDS {
+		fmt.Fprintf(w, "  %-14s %s\n", c.Name, c.Description)
+	}
+}
+
+// replay submits the responses saved in -replay-path to the sinks, as if
+// they had been read from the sensor just now. With -replay-follow, responses
+// appended to the file are submitted as they are written, until aqgo is
+// interrupted.
+func replay(args *ApplicationArguments, logger *log.Logger) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	var r io.ReadCloser
+	var err error
+	if args.ReplayFollow {
+		r, err = iotco1000.NewFollowReader(ctx, args.ReplayPath)
+	} else {
+		r, err = os.Open(args.ReplayPath)
+	}
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	sinks, _, err := newSinks(args, logger)
+	if err != nil {
+		return err
+	}
+	fanout := sink.NewFanout(args.SubmitWorkers, sinks...)
+	fanout.Disable(args.DisabledSinks)
+	defer fanout.Close()
+	logger.Printf("replaying %s to sinks: %s\n", args.ReplayPath, strings.Join(fanout.Active(), ", "))
+	decoder := iotco1000.NewMeasurementDecoder(r, args.Delimiter)
+	replayed := 0
+	for ctx.Err() == nil {
+		aq, err := decoder.Decode()
+		if err == io.EOF {
+			break
+		}
+		if errors.Is(err, iotco1000.ErrMalformedResponse) || errors.Is(err, iotco1000.ErrSensorNotReady) || errors.Is(err, iotco1000.ErrSensorReportedError) {
+			logger.Printf("skipping response that could not be parsed: %s\n", err)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed reading %s after %d reading(s): %s", args.ReplayPath, replayed, err)
+		}
+		aq.MeasurementTime = time.Now()
+		reading := &sink.Reading{
+			AirQualityMeasurement: aq,
+			SensorWarmedUp:        aq.WarmedUp(args.WarmUpDuration),
+			Location:              args.Location,
+			Environment:           args.Environment,
+		}
+		if err := fanout.Submit(ctx, reading); err != nil {
+			logger.Printf("error submitting replayed reading: %s\n", err)
+		}
+		replayed++
+	}
+	logger.Printf("replayed %d reading(s)\n", replayed)
+	return nil
+}
+
+// replaySpool resubmits the readings saved in -replay-spool, with their
+// original measurement times, to the sinks that failed to submit them.
+// Records that cannot be read, such as one left partially written by a
+// crash, are skipped, as are readings of sinks that are not active.
+func replaySpool(args *ApplicationArguments, logger *log.Logger) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	f, err := os.Open(args.ReplaySpoolPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sinks, _, err := newSinks(args, logger)
+	if err != nil {
+		return err
+	}
+	fanout := sink.NewFanout(args.SubmitWorkers, sinks...)
+	fanout.Disable(args.DisabledSinks)
+	defer fanout.Close()
+	names := map[string]bool{}
+	for _, name := range fanout.Active() {
+		names[name] = true
+	}
+	active := map[string]sink.MetricSink{}
+	for _, s := range sinks {
+		if names[s.Name()] {
+			active[s.Name()] = s
+		}
+	}
+	logger.Printf("resubmitting the readings of %s to sinks: %s\n", args.ReplaySpoolPath, strings.Join(fanout.Active(), ", "))
+	reader := sink.NewFallbackReader(f)
+	resubmitted, skipped := 0, 0
+	for ctx.Err() == nil {
+		r, sinkName, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if errors.Is(err, sink.ErrCorruptFallbackRecord) {
+			logger.Printf("skipping record: %s\n", err)
+			skipped++
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed reading %s after %d reading(s): %s", args.ReplaySpoolPath, resubmitted, err)
+		}
+		s, ok := active[sinkName]
+		if !ok {
+			logger.Printf("skipping reading taken at %s for sink %s, which is not active\n", r.MeasurementTime.Format(time.RFC3339), sinkName)
+			skipped++
+			continue
+		}
+		if err := s.Submit(ctx, r); err != nil {
+			logger.Printf("error resubmitting reading taken at %s to %s: %s\n", r.MeasurementTime.Format(time.RFC3339), sinkName, err)
+			skipped++
+			continue
+		}
+		resubmitted++
+	}
+	logger.Printf("resubmitted %d reading(s); skipped %d\n", resubmitted, skipped)
+	return nil
+}
+
+// simulate runs a simulated sensor that aqgo can read by setting
+// -serial-device-path to tcp:// and the listen address.
+func simulate(argv []string) error {
+	fs := flag.NewFlagSet(os.Args[0]+" simulate", flag.ExitOnError)
+	listen := fs.String("listen", "127.0.0.1:9600", "the address to accept connections on")
+	serial := fs.String("serial", "123456789012", "the serial number the simulated sensor reports")
+	startUptime := fs.Duration("start-uptime", 0, "the uptime the simulated sensor reports at startup")
+	uptimeScale := fs.Float64("uptime-scale", 1, "seconds of uptime that elapse per real second, to speed through warm up")
+	malformedEvery := fs.Int("malformed-every", 0, "if nonzero, make every nth response malformed")
+	resetEvery := fs.Duration("reset-every", 0, "if nonzero, reset the uptime this often, as if the sensor lost power")
+	fs.Parse(argv)
+
+	sim := simulator.New(*serial)
+	sim.StartUptime = *startUptime
+	sim.UptimeScale = *uptimeScale
+	sim.MalformedEvery = *malformedEvery
+	sim.ResetEvery = *resetEvery
+	l, err := net.Listen("tcp", *listen)
+	if err != nil {
+		return err
+	}
+	log.Printf("simulating sensor %s on %s; read it with -serial-device-path %s%s\n", *serial, l.Addr(), iotco1000.TCP_URL_PREFIX, l.Addr())
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return sim.Serve(ctx, l)
+}
+
+// dumpFields takes one reading and prints its fields, unconverted, with
+// their indices, to help map the fields of unfamiliar firmware.
+func dumpFields(sensor *iotco1000.IOTCO1000) error {
+	response, err := sensor.RawMeasurement()
+	if err != nil {
+		return err
+	}
+	type field struct {
+		Index    int    `json:"index"`
+		RawValue string `json:"raw_value"`
+	}
+	delimiter := iotco1000.DetectDelimiter(response)
+	fields := []field{}
+	for i, v := range strings.Split(strings.Trim(response, " \r\n\x00"), delimiter) {
+		fields = append(fields, field{i, v})
+	}
+	out, err := json.MarshalIndent(map[string]interface{}{
+		"response":    response,
+		"delimiter":   delimiter,
+		"field_count": len(fields),
+		"fields":      fields,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(out))
+	return nil
+}
+
+// runConfig returns the configuration of the polling loop described by args.
+// When it is applied as a reload, the sinks of fanout are enabled or disabled,
+// and its CloudWatch sinks take on the reloadable settings of args.
+func runConfig(args *ApplicationArguments, logger *log.Logger, sensor *iotco1000.IOTCO1000, fanout *sink.Fanout) daemon.Config {
+	return daemon.Config{
+		Sensor:                sensor,
+		Logger:                logger,
+		PollInterval:          time.Duration(args.PollInterval) * time.Millisecond,
+		SampleCount:           args.SampleCount,
+		MalformedRetries:      args.MalformedRetries,
+		ReadRetryDelay:        args.ReadRetryDelay,
+		SuppressWarmUpMetrics: args.SuppressWarmUpMetrics,
+		ShutdownTimeout:       args.ShutdownTimeout,
+		StartupDelay:          args.StartupDelay,
+		AlignPollInterval:     args.AlignPollInterval,
+		AutoCalibrateInterval: args.AutoCalibrateInterval,
+		AdaptivePoll:          args.AdaptivePoll,
+		AdaptiveMinInterval:   args.AdaptivePollMin,
+		AdaptiveMaxInterval:   args.AdaptivePollMax,
+		AdaptiveCOThreshold:   args.AdaptivePollThreshold,
+		SubmitQueueDepth:      args.SubmitQueueDepth,
+		WarmUpDuration:        args.WarmUpDuration,
+		SkipWarmUp:            args.WarmUpDuration == 0,
+		RequireClockSync:      args.RequireClockSync,
+		MaxMeasurementAge:     args.MaxMeasurementAge,
+		HoldLastValue:         args.HoldLastValue,
+		MinUptimeBeforeSubmit: args.MinUptimeBeforeSubmit,
+		HealthScoreWeights:    args.HealthScoreWeights,
+		Location:              args.Location,
+		LocationMap:           args.LocationMap,
+		DeviceAliases:         args.DeviceAliases,
+		Environment:           args.Environment,
+		Dedup:                 args.Dedup,
+		DedupMaxInterval:      args.DedupMaxInterval,
+		KeepaliveInterval:     args.KeepaliveInterval,
+		Provenance:            args.Provenance,
+		COMinDelta:            args.COMinDelta,
+		TemperatureMinDelta:   args.TemperatureMinDelta,
+		HumidityMinDelta:      args.HumidityMinDelta,
+		COVarianceWindow:      args.COVarianceWindow,
+		COPercentiles:         args.COPercentiles,
+		COPercentileWindow:    args.COPercentileWindow,
+		OnReload: func() {
+			fanout.Disable(args.DisabledSinks)
+			logger.Printf("active sinks: %s\n", strings.Join(fanout.Active(), ", "))
+			for _, s := range fanout.Sinks {
+				if cw, ok := s.(*sink.CloudWatchSink); ok {
+					cw.Host = args.Host
+					cw.WarmupSubmitRaw = args.WarmupSubmitRaw
+				}
 			}
-			params = metricDataInput(false, ns, aq)
+		},
+	}
+}
+
+// detectSerialDevice returns the only serial device present on the system.
+func detectSerialDevice() (string, error) {
+	devices, err := iotco1000.DetectSerialDevices()
+	if err != nil {
+		return "", err
+	}
+	switch len(devices) {
+	case 0:
+		return "", fmt.Errorf("no serial devices found in %s; set -serial-device-path", strings.Join(iotco1000.SERIAL_DEVICE_GLOBS, ", "))
+	case 1:
+		return devices[0], nil
+	}
+	return "", fmt.Errorf("multiple serial devices found (%s); set -serial-device-path", strings.Join(devices, ", "))
+}
+
+// probeSensor takes a single reading from sensor, giving up after timeout.
+func probeSensor(sensor *iotco1000.IOTCO1000, timeout time.Duration) (*iotco1000.AirQualityMeasurement, error) {
+	type result struct {
+		aq  *iotco1000.AirQualityMeasurement
+		err error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		aq, err := sensor.AnalyzeAirQuality()
+		ch <- result{aq, err}
+	}()
+	select {
+	case r := <-ch:
+		return r.aq, r.err
+	case <-time.After(timeout):
+		return nil, fmt.Errorf("no response from sensor within %s", timeout)
+	}
+}
+
+// newSinks creates the sinks configured by args. A sink that cannot be
+// created is logged and left out, so that the others still receive readings;
+// an error is returned only if every configured sink failed.
+func newSinks(args *ApplicationArguments, logger *log.Logger) ([]sink.MetricSink, []error, error) {
+	sinks := []sink.MetricSink{}
+	failures := []error{}
+	fail := func(format string, v ...interface{}) {
+		logger.Printf(format+"; continuing without it\n", v...)
+		failures = append(failures, fmt.Errorf(format, v...))
+	}
+
+	namespaces := []string{}
+	for ns := range args.CloudWatchNamespaces {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+	for i, ns := range append([]string{args.MetricNamespace}, namespaces...) {
+		var cw *sink.CloudWatchSink
+		var err error
+		if i == 0 {
+			cw, err = sink.NewCloudWatchSink(ns, args.CloudWatchTimeout, args.Host)
 		} else {
-			if !loggedSensorActive {
-				logger.Println("sensor has been active for warm up duration; will submit metrics")
-				loggedSensorActive = true
+			cw, err = sink.NewCloudWatchSinkForTarget(ns, args.CloudWatchNamespaces[ns], args.CloudWatchTimeout, args.Host)
+		}
+		if err != nil {
+			fail("failed creating CloudWatch client for namespace %s: %s", ns, err)
+			continue
+		}
+		// Readings that could not be submitted anywhere are recorded once,
+		// from the primary namespace.
+		if i == 0 && args.FallbackFile != "" {
+			cw.Fallback, err = sink.OpenFallbackFile(args.FallbackFile, args.FileRotation)
+			if err != nil {
+				fail("failed opening fallback file: %s", err)
+			} else {
+				cw.Fallback.Compress = args.SpoolCompress
 			}
-			params = metricDataInput(true, ns, aq)
 		}
+		cw.WarmupSubmitRaw = args.WarmupSubmitRaw
+		cw.CONegativePolicy = args.CONegativePolicy
+		cw.COUnit = args.COUnit
+		cw.Environment = args.Environment
+		cw.MaxDatumsPerRequest = args.MaxDatumsPerRequest
+		cw.SubmitRawAlongside = args.SubmitRawAlongside
+		cw.SubmitUptimeComponents = args.SubmitUptimeComponents
+		cw.StaticMetrics = args.StaticMetrics
+		cw.Resolutions = args.Resolutions
+		cw.SubmitWorkers = args.SubmitWorkers
+		cw.ConfiguredPollInterval = time.Duration(args.PollInterval) * time.Millisecond
+		cw.ConfiguredWarmUp = args.WarmUpDuration
+		if i == 0 {
+			cw.Targets = args.AWSTargets
+		}
+		sinks = append(sinks, cw)
+	}
 
-		_, err := cw.PutMetricData(context.TODO(), params)
+	if len(args.KafkaBrokers) > 0 {
+		sinks = append(sinks, sink.NewKafkaSink(args.KafkaBrokers, args.KafkaTopic, args.KafkaEncoding))
+	}
+	if args.SQSQueueURL != "" {
+		sqs, err := sink.NewSQSSink(args.SQSQueueURL, args.SQSBatchDelay)
 		if err != nil {
-			logger.Printf("error submitting metric data to cloudwatch: %s\n", err)
+			fail("failed creating SQS client: %s", err)
+		} else {
+			sinks = append(sinks, sqs)
+		}
+	}
+	if args.LogGroup != "" {
+		logs, err := sink.NewCloudWatchLogsSink(args.LogGroup, args.LogStream, args.LogBatchDelay)
+		if err != nil {
+			fail("failed creating CloudWatch Logs client: %s", err)
+		} else {
+			sinks = append(sinks, logs)
+		}
+	}
+	if args.S3Bucket != "" {
+		s3, err := sink.NewS3Sink(args.S3Bucket, args.S3Prefix, args.S3FlushInterval)
+		if err != nil {
+			fail("failed creating S3 client: %s", err)
+		} else {
+			s3.Format = args.ArchiveFormat
+			s3.FlushAlign = args.FlushAlign
+			sinks = append(sinks, s3)
+		}
+	}
+	if args.IoTEndpoint != "" {
+		iot, err := sink.NewIoTCoreSink(args.IoTEndpoint, args.IoTTopic, args.IoTThingName, args.IoTUpdateShadow, args.IoTCertFile, args.IoTKeyFile, args.IoTCAFile)
+		if err != nil {
+			fail("failed creating IoT Core sink: %s", err)
+		} else {
+			sinks = append(sinks, iot)
+		}
+	}
+	if args.GRPCAddr != "" {
+		g, err := sink.NewGRPCSink(args.GRPCAddr)
+		if err != nil {
+			fail("failed starting gRPC server: %s", err)
+		} else {
+			sinks = append(sinks, g)
+		}
+	}
+	if args.Stdout {
+		stdout := sink.NewStdoutSink(args.StdoutFormat, args.DisplayTimezone)
+		stdout.Pretty = args.JSONPretty
+		sinks = append(sinks, stdout)
+	}
+	if args.OutputFile != "" {
+		file, err := sink.NewFileSink(args.OutputFile, args.FileRotation, args.StdoutFormat, args.DisplayTimezone)
+		if err != nil {
+			fail("failed opening output file: %s", err)
+		} else {
+			file.Pretty = args.JSONPretty
+			sinks = append(sinks, file)
 		}
 	}
+	if args.Journal {
+		j, err := sink.NewJournalSink("aqgo")
+		if err != nil {
+			fail("failed creating journal sink: %s", err)
+		} else {
+			sinks = append(sinks, j)
+		}
+	}
+	if args.MQTTBroker != "" {
+		m, err := sink.NewMQTTSink(args.MQTTBroker, args.MQTTTopic, args.MQTTClientID, args.MQTTUsername, args.MQTTPassword, args.MQTTTLS)
+		if err != nil {
+			fail("failed creating MQTT sink: %s", err)
+		} else {
+			sinks = append(sinks, m)
+		}
+	}
+	if args.NATSURL != "" {
+		n, err := sink.NewNATSSink(args.NATSURL, args.NATSSubject, args.NATSToken, args.NATSCredsFile, logger)
+		if err != nil {
+			fail("failed creating NATS sink: %s", err)
+		} else {
+			sinks = append(sinks, n)
+		}
+	}
+	if args.PushgatewayURL != "" {
+		sinks = append(sinks, sink.NewPushgatewaySink(args.PushgatewayURL, args.PushgatewayJob))
+	}
+	if args.RemoteWriteURL != "" {
+		sinks = append(sinks, sink.NewRemoteWriteSink(args.RemoteWriteURL, args.RemoteWriteUsername, args.RemoteWritePassword, args.RemoteWriteBearerToken))
+	}
+	if args.AlertWebhookURL != "" {
+		sinks = append(sinks, sink.NewAlertSink(args.AlertWebhookURL, args.AlertCOThreshold, args.AlertDuringWarmUp, args.AlertNotifyActive))
+	}
+	if len(sinks) == 0 && len(failures) > 0 {
+		return nil, failures, errors.New("no sinks could be created")
+	}
+	return sinks, failures, nil
 }
 
-func metricDataInput(sensorWarmedUp bool, ns string, aq *iotco1000.AirQualityMeasurement) *cloudwatch.PutMetricDataInput {
-	var warmedUp float64
-	var params *cloudwatch.PutMetricDataInput
-	storageResolution := int32(1)
-	dimensions := []cwtypes.Dimension{
-		{
-			Name:  &SENSOR_ID,
-			Value: &aq.SensorSerialNumber,
-		},
+// configCheck creates each configured sink and checks those that can be
+// checked without submitting a reading, printing a PASS or FAIL line for
+// each. It returns whether all of them passed.
+func configCheck(args *ApplicationArguments, logger *log.Logger) bool {
+	fmt.Println("PASS configuration")
+	// Failures are reported below rather than logged as they happen.
+	sinks, failures, _ := newSinks(args, log.New(io.Discard, "", 0))
+	for _, err := range failures {
+		fmt.Printf("FAIL %s\n", err)
 	}
-	if sensorWarmedUp {
-		warmedUp = 1.0
-		coPPB := float64(aq.COConcentrationPPB)
-		if coPPB < 0 {
-			coPPB = 0
-		}
-		params = &cloudwatch.PutMetricDataInput{
-			Namespace: &ns,
-			MetricData: []cwtypes.MetricDatum{
-				{
-					MetricName:        &CO_CONCENTRATION_PPB,
-					Value:             &coPPB,
-					Dimensions:        dimensions,
-					Unit:              cwtypes.StandardUnitNone,
-					StorageResolution: &storageResolution,
-					Timestamp:         &aq.MeasurementTime,
-				},
-				{
-					MetricName:        &TEMPERATURE_C,
-					Value:             ifp(aq.TemperatureC),
-					Dimensions:        dimensions,
-					Unit:              cwtypes.StandardUnitNone,
-					StorageResolution: &storageResolution,
-					Timestamp:         &aq.MeasurementTime,
-				},
-				{
-					MetricName:        &RELATIVE_HUMIDITY,
-					Value:             ifp(aq.RelativeHumidity),
-					Dimensions:        dimensions,
-					Unit:              cwtypes.StandardUnitNone,
-					StorageResolution: &storageResolution,
-					Timestamp:         &aq.MeasurementTime,
-				},
-				{
-					MetricName:        &UPTIME,
-					Value:             ffp(aq.Uptime.Seconds()),
-					Dimensions:        dimensions,
-					Unit:              cwtypes.StandardUnitSeconds,
-					StorageResolution: &storageResolution,
-					Timestamp:         &aq.MeasurementTime,
-				},
-				{
-					MetricName:        &SENSOR_WARMED_UP,
-					Value:             &warmedUp,
-					Dimensions:        dimensions,
-					Unit:              cwtypes.StandardUnitNone,
-					StorageResolution: &storageResolution,
-					Timestamp:         &aq.MeasurementTime,
-				},
-			},
+	for _, s := range sinks {
+		checker, ok := s.(sink.Checker)
+		if !ok {
+			fmt.Printf("PASS %s (created; no check available)\n", s.Name())
+			continue
 		}
-	} else {
-		warmedUp = 0.0
-		params = &cloudwatch.PutMetricDataInput{
-			Namespace: &ns,
-			MetricData: []cwtypes.MetricDatum{
-				{
-					MetricName:        &UPTIME,
-					Value:             ffp(aq.Uptime.Seconds()),
-					Dimensions:        dimensions,
-					Unit:              cwtypes.StandardUnitSeconds,
-					StorageResolution: &storageResolution,
-					Timestamp:         &aq.MeasurementTime,
-				},
-				{
-					MetricName:        &SENSOR_WARMED_UP,
-					Value:             &warmedUp,
-					Dimensions:        dimensions,
-					Unit:              cwtypes.StandardUnitNone,
-					StorageResolution: &storageResolution,
-					Timestamp:         &aq.MeasurementTime,
-				},
-			},
-		}
-	}
-	return params
-}
-
-func newCloudWatchClient() (*cloudwatch.Client, error) {
-	cfg, err := config.LoadDefaultConfig(context.TODO())
-	if err != nil {
-		return nil, fmt.Errorf("error loading AWS default config: %s", err)
-	}
-	cloudwatchClient := cloudwatch.NewFromConfig(cfg)
-	return cloudwatchClient, nil
-}
-
-func parseArguments() (*ApplicationArguments, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), args.CloudWatchTimeout)
+		err := checker.Check(ctx)
+		cancel()
+		if err != nil {
+			fmt.Printf("FAIL %s: %s\n", s.Name(), err)
+			failures = append(failures, err)
+		} else {
+			fmt.Printf("PASS %s\n", s.Name())
+		}
+	}
+	for _, s := range sinks {
+		if err := s.Close(); err != nil {
+			logger.Printf("error closing %s: %s\n", s.Name(), err)
+		}
+	}
+	return len(failures) == 0
+}
+
+func newHTTPServer(addr string, ws *sink.WebSocketSink, status *sink.StatusSink) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/ws", ws)
+	mux.Handle("/status", status)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(dashboardHTML)
+	})
+	return &http.Server{
+		Addr:    addr,
+		Handler: mux,
+	}
+}
+
+// version returns the version of aqgo's module, as recorded in the binary.
+func version() string {
+	if info, ok := debug.ReadBuildInfo(); ok {
+		return info.Main.Version
+	}
+	return "unknown"
+}
+
+// ENVIRONMENT_PATTERN is what -environment must match, to make a sensible
+// CloudWatch dimension value and JSON label.
+var ENVIRONMENT_PATTERN = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// UNKNOWN_HOST is the Host dimension used when no host is given and the
+// hostname cannot be determined.
+const UNKNOWN_HOST = "unknown"
+
+// SECRET_FLAGS are the settings whose values are redacted by -print-config.
+var SECRET_FLAGS = map[string]bool{
+	"remote-write-password":     true,
+	"remote-write-bearer-token": true,
+	"mqtt-password":             true,
+	"nats-token":                true,
+	"alert-webhook-url":         true,
+}
+
+// redactedFlags returns a copy of flags with the values of SECRET_FLAGS, and
+// any credentials embedded in URLs, replaced.
+func redactedFlags(flags map[string]string) map[string]string {
+	redacted := map[string]string{}
+	for name, value := range flags {
+		if SECRET_FLAGS[name] && value != "" {
+			value = "REDACTED"
+		} else if u, err := url.Parse(value); err == nil && u.User != nil {
+			u.User = url.User("REDACTED")
+			value = u.String()
+		}
+		redacted[name] = value
+	}
+	return redacted
+}
+
+// RELOADABLE_FLAGS are the settings that take effect when the configuration
+// is reloaded on SIGHUP. Changes to any other setting require a restart.
+var RELOADABLE_FLAGS = map[string]bool{
+	"dedup":                   true,
+	"dedup-max-interval":      true,
+	"keepalive-interval":      true,
+	"co-min-delta-ppb":        true,
+	"temperature-min-delta-c": true,
+	"humidity-min-delta":      true,
+	"host":                    true,
+	"no-host-dimension":       true,
+	"disable-sinks":           true,
+	"location":                true,
+	"location-map":            true,
+	"max-measurement-age":     true,
+	"require-clock-sync":      true,
+	"warmup-submit-raw":       true,
+	"warmup-duration":         true,
+	"no-warmup-skip":          true,
+}
+
+// parseArguments parses the command line arguments in argv along with the
+// configuration file they name, if any. Problems that do not prevent running
+// are returned in Warnings for the caller to log.
+func parseArguments(argv []string) (*ApplicationArguments, error) {
 	args := ApplicationArguments{}
-	pollInterval := flag.Int("poll-interval", 5000, "how frequently to poll for and submit readings, in millseconds")
-	serialDevicePath := flag.String("serial-device-path", "", "the location of the serial device to poll for readings")
-	metricNamespace := flag.String("metric-namespace", "", "the CloudWatch metric namespace for which to submit readings")
-	flag.Parse()
-	missingArguments := []string{}
-	if *serialDevicePath == "" {
-		missingArguments = append(missingArguments, "serial-device-path")
+	fs := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	configFile := fs.String("config", "", "a JSON file of settings named by flag, e.g. {\"poll-interval\": 10000, \"warmup-duration\": \"1h\"}, as described by config.Config; flags given on the command line take precedence. The file is reloaded on SIGHUP")
+	pollInterval := fs.Int("poll-interval", 5000, "how frequently to poll for and submit readings, in millseconds")
+	serialDevicePath := fs.String("serial-device-path", "", "the location of the serial device to poll for readings; may also be a tcp://host:port URL, or a named pipe that responses are read from without sending commands; if omitted, the device is detected automatically")
+	metricNamespace := fs.String("metric-namespace", "", "the CloudWatch metric namespace for which to submit readings")
+	regexParser := fs.Bool("regex-parser", false, "parse sensor responses with a regular expression that tolerates delimiter variation")
+	responseRegexp := fs.String("response-regexp", "", "a custom regular expression with named groups (serial, co, temperature, humidity, days, hours, minutes, seconds) used to parse sensor responses; implies -regex-parser")
+	kafkaBrokers := fs.String("kafka-brokers", "", "a comma-separated list of Kafka brokers to publish readings to")
+	kafkaTopic := fs.String("kafka-topic", "", "the Kafka topic to publish readings to")
+	kafkaEncoding := fs.String("kafka-encoding", "json", "the encoding of messages published to Kafka (json or avro)")
+	sqsQueueURL := fs.String("sqs-queue-url", "", "the URL of an SQS queue to send readings to")
+	sqsBatchDelay := fs.Duration("sqs-batch-delay", time.Minute, "the longest a reading is held waiting for a full batch before it is sent to SQS")
+	s3Bucket := fs.String("s3-bucket", "", "an S3 bucket to periodically archive readings to")
+	s3Prefix := fs.String("s3-prefix", "", "the key prefix of objects archived to S3")
+	s3FlushInterval := fs.Duration("s3-flush-interval", time.Hour, "how frequently accumulated readings are uploaded to S3")
+	iotEndpoint := fs.String("iot-endpoint", "", "the AWS IoT Core data endpoint to publish readings to")
+	iotTopic := fs.String("iot-topic", "aqgo/readings", "the MQTT topic to publish readings to on AWS IoT Core")
+	iotThingName := fs.String("iot-thing-name", "", "the AWS IoT thing name; defaults to the sensor serial number")
+	iotUpdateShadow := fs.Bool("iot-update-shadow", false, "update the reported state of the device shadow with each reading")
+	iotCertFile := fs.String("iot-cert", "", "the client certificate used to authenticate to AWS IoT Core")
+	iotKeyFile := fs.String("iot-key", "", "the private key of the AWS IoT Core client certificate")
+	iotCAFile := fs.String("iot-ca", "", "the CA certificate used to verify AWS IoT Core")
+	grpcAddr := fs.String("grpc-addr", "", "the address on which to serve the gRPC reading stream, e.g. :50051")
+	httpAddr := fs.String("http-addr", "", "the address on which to serve the live dashboard, WebSocket feed and /status health summary, e.g. :8080")
+	pushgatewayURL := fs.String("pushgateway-url", "", "the URL of a Prometheus Pushgateway to push readings to")
+	pushgatewayJob := fs.String("pushgateway-job", "aqgo", "the job name readings are pushed to the Pushgateway under")
+	remoteWriteURL := fs.String("remote-write-url", "", "the URL of a Prometheus remote-write endpoint to send readings to")
+	remoteWriteUsername := fs.String("remote-write-username", "", "the basic auth username for the remote-write endpoint")
+	remoteWritePassword := fs.String("remote-write-password", "", "the basic auth password for the remote-write endpoint")
+	remoteWriteBearerToken := fs.String("remote-write-bearer-token", "", "the bearer token for the remote-write endpoint")
+	dedup := fs.Bool("dedup", false, "skip submitting readings whose CO, temperature and humidity are unchanged from the last submission")
+	dedupMaxInterval := fs.Duration("dedup-max-interval", 5*time.Minute, "with -dedup or a minimum delta, the longest to go without submitting a reading")
+	firmwareInfo := fs.Bool("firmware-info", false, "print the firmware information reported by the sensor and exit")
+	calibrate := fs.Bool("calibrate", false, "zero calibrate the sensor and exit; only run this while the sensor is in air known to be free of CO")
+	autoCalibrateInterval := fs.Duration("auto-calibrate-interval", 0, "zero calibrate the sensor on this interval; only use this if the sensor is in air known to be free of CO at those times")
+	cloudWatchTimeout := fs.Duration("cloudwatch-timeout", 10*time.Second, "how long to wait for each CloudWatch PutMetricData call")
+	submitQueueDepth := fs.Int("submit-queue-depth", 100, "the number of readings to queue for submission, and for each sink, before the oldest are dropped")
+	host := fs.String("host", "", "the value of the Host dimension added to CloudWatch metrics; defaults to the system hostname")
+	noHostDimension := fs.Bool("no-host-dimension", false, "do not add a Host dimension to CloudWatch metrics")
+	location := fs.String("location", "", "the location of the sensor, added to submitted metrics as the Location dimension")
+	locationMap := fs.String("location-map", "", "a comma-separated list of serial=location pairs giving the location of each sensor; overrides -location")
+	fallbackFile := fs.String("fallback-file", "", "a file to which readings are appended as JSON when they cannot be submitted to CloudWatch")
+	spoolCompress := fs.Bool("spool-compress", false, "gzip each reading appended to -fallback-file; read it with zcat, or resubmit it with -replay-spool")
+	warmupSubmitRaw := fs.Bool("warmup-submit-raw", false, "submit CO, temperature and humidity to CloudWatch while the sensor is warming up; these values are not trustworthy")
+	probe := fs.Bool("probe", false, "take a single reading to verify that a sensor is attached, then exit with a nonzero status if it could not be read")
+	probeTimeout := fs.Duration("probe-timeout", 5*time.Second, "how long -probe waits for a reading")
+	settleDelay := fs.Duration("settle-delay", time.Second, "how long to wait for the sensor to respond after sending it a command")
+	requireClockSync := fs.Bool("require-clock-sync", false, "skip submitting readings until the system clock has been set, for devices without a real-time clock")
+	maxMeasurementAge := fs.Duration("max-measurement-age", 0, "drop readings older than this when they are submitted; 0 disables the check")
+	check := fs.Bool("check", false, "verify that AWS credentials permit submitting metrics to the metric namespace, then exit")
+	openRetryTimeout := fs.Duration("open-retry-timeout", 0, "how long to keep retrying to open the serial device if it is not yet present")
+	logGroup := fs.String("log-group", "", "a CloudWatch Logs group to record each raw sensor response to")
+	logStream := fs.String("log-stream", "", "the CloudWatch Logs stream raw sensor responses are recorded to")
+	logBatchDelay := fs.Duration("log-batch-delay", time.Minute, "the longest a raw response is held waiting for a full batch before it is sent to CloudWatch Logs")
+	sampleCount := fs.Int("sample-count", 1, "the number of readings to take on each poll and average into a single submission; readings are taken back to back, each waiting -settle-delay, so the poll interval should allow for all of them")
+	maxRuntime := fs.Duration("max-runtime", 0, "shut down cleanly after running for this long; 0 runs until interrupted")
+	stdout := fs.Bool("stdout", false, "write each reading to standard output")
+	stdoutFormat := fs.String("stdout-format", "text", "the format readings are written to standard output and the output file in (text, json or csv)")
+	displayTimezone := fs.String("display-timezone", "UTC", "the time zone in which timestamps written to standard output are displayed: UTC, Local or an IANA time zone name")
+	submitWorkers := fs.Int("submit-workers", 4, "the number of submissions to sinks in progress at once; each sink takes readings from its own queue, and waits for a free worker to submit each")
+	serialField := fs.Int("serial-field", 0, "the index of the response field holding the sensor serial number")
+	serialOverride := fs.String("serial-override", "", "report this serial number for every reading instead of the one in the sensor response")
+	jsonPretty := fs.Bool("json-pretty", false, "with -stdout-format json, indent each reading rather than writing compact NDJSON")
+	coMinDelta := fs.Int("co-min-delta-ppb", 0, "skip submitting readings unless CO has changed by more than this from the last submission, or temperature or humidity has changed beyond its own delta")
+	temperatureMinDelta := fs.Float64("temperature-min-delta-c", 0, "skip submitting readings unless temperature has changed by more than this, e.g. 0.5, or another value has changed beyond its own delta")
+	humidityMinDelta := fs.Float64("humidity-min-delta", 0, "skip submitting readings unless relative humidity has changed by more than this, or another value has changed beyond its own delta")
+	intervalField := fs.Int("interval-field", -1, "the index of the response field holding the sensor's measurement interval in seconds, for firmware that reports it; -1 disables")
+	pressureField := fs.Int("pressure-field", -1, "the index of the response field holding the barometric pressure in hPa, for variants that report it; it is submitted as PressurehPa; -1 disables")
+	vocField := fs.Int("voc-field", -1, "the index of the response field holding a VOC index, for variants that report it; it is submitted as VOCIndex; -1 disables")
+	alignPollInterval := fs.Bool("align-poll-interval", false, "with -interval-field, lengthen the poll interval to the sensor's measurement interval if it is shorter")
+	malformedRetries := fs.Int("malformed-retries", 0, "how many times to immediately retry a reading when the sensor response is malformed")
+	warmUpDuration := fs.Duration("warmup-duration", iotco1000.WARM_UP_DURATION, "how long the sensor must be powered on before its readings are trusted; 0 trusts readings immediately")
+	noWarmUpSkip := fs.Bool("no-warmup-skip", false, "trust readings immediately, for sensors warmed up before deployment; equivalent to -warmup-duration 0")
+	awsTargets := fs.String("aws-targets", "", "a comma-separated list of serial=profile/region pairs directing the CloudWatch metrics of each sensor to the account of a shared config profile and a region; either may be empty to use the default")
+	resolution := fs.String("resolution", "", "a comma-separated list of metric=seconds pairs setting the CloudWatch storage resolution of those metrics to 1 (high resolution) or 60 seconds, e.g. COConcentrationPPB=1,Uptime=60; metrics default to 1")
+	startupEvent := fs.Bool("startup-event", false, "query the sensor firmware at startup, then log a summary of the device and configuration and submit a Startup metric with the first reading")
+	coVarianceWindow := fs.Int("co-variance-window", 0, "if nonzero, submit the moving variance and z-score of CO over roughly this many readings")
+	dumpFields := fs.Bool("dump-fields", false, "take one reading, print each of its fields with its index as JSON, and exit")
+	delimiter := fs.String("delimiter", iotco1000.RESPONSE_DELIMITER, "the delimiter between the fields of a sensor response; Go escape sequences such as \\t are interpreted")
+	printConfig := fs.Bool("print-config", false, "print the effective configuration, after applying the config file, as JSON with secrets redacted, and exit")
+	cloudWatchNamespaces := fs.String("cloudwatch-namespaces", "", "a comma-separated list of namespace=profile/region pairs, each submitting all metrics to another CloudWatch namespace in the account of a shared config profile and a region, in addition to metric-namespace; either may be empty to use the default")
+	coNegativePolicy := fs.String("co-negative-policy", string(sink.CONegativeClamp), "how to submit negative CO concentrations to CloudWatch: clamp to 0, drop, or pass as reported")
+	outputFile := fs.String("output-file", "", "if set, append each reading to this file, in the format given by -stdout-format")
+	fileRotateSize := fs.Int64("file-rotate-size", 0, "if nonzero, rotate the output and fallback files before they exceed this many bytes")
+	fileRotateInterval := fs.Duration("file-rotate-interval", 0, "if nonzero, rotate the output and fallback files this often")
+	fileRotateKeep := fs.Int("file-rotate-keep", 5, "how many rotated output and fallback files to keep")
+	fileRotateGzip := fs.Bool("file-rotate-gzip", false, "gzip the output and fallback files when they are rotated")
+	disableSinks := fs.String("disable-sinks", "", "a comma-separated list of sinks to suppress even though they are configured, e.g. cloudwatch,kafka")
+	mqttBroker := fs.String("mqtt-broker", "", "if set, publish readings to this MQTT broker, e.g. tcp://localhost:1883 or ssl://broker:8883")
+	mqttTopic := fs.String("mqtt-topic", "aqgo/readings", "the MQTT topic to publish readings to")
+	mqttClientID := fs.String("mqtt-client-id", "aqgo", "the MQTT client ID")
+	mqttUsername := fs.String("mqtt-username", "", "the username to authenticate to the MQTT broker with")
+	mqttPassword := fs.String("mqtt-password", "", "the password to authenticate to the MQTT broker with")
+	mqttTLSCA := fs.String("mqtt-tls-ca", "", "a PEM file of CA certificates to verify the MQTT broker with, instead of the system roots")
+	mqttTLSCert := fs.String("mqtt-tls-cert", "", "a PEM client certificate to authenticate to the MQTT broker with; requires mqtt-tls-key")
+	mqttTLSKey := fs.String("mqtt-tls-key", "", "the PEM private key of mqtt-tls-cert")
+	mqttTLSInsecure := fs.Bool("mqtt-tls-insecure", false, "do not verify the MQTT broker's certificate; only for self-signed development brokers")
+	keepaliveInterval := fs.Duration("keepalive-interval", 0, "with -dedup or a minimum delta, if nonzero, submit an unchanged reading after this long without a submission, and log that it was a keepalive")
+	adaptivePoll := fs.Bool("adaptive-poll", false, "lengthen the poll interval while CO is stable and shorten it when CO changes, to save power")
+	adaptivePollMin := fs.Duration("adaptive-poll-min", 5*time.Second, "with -adaptive-poll, the shortest poll interval, used while CO is changing")
+	adaptivePollMax := fs.Duration("adaptive-poll-max", 5*time.Minute, "with -adaptive-poll, the longest poll interval, reached while CO is stable")
+	adaptivePollThreshold := fs.Int("adaptive-poll-threshold-ppb", 2, "with -adaptive-poll, the largest change in CO between readings that is considered stable")
+	maxResponseBytes := fs.Int("max-response-bytes", iotco1000.MAX_RESPONSE_BYTES, "the longest sensor response accepted; longer responses are reported as truncated")
+	staticMetrics := listFlag{}
+	fs.Var(&staticMetrics, "static-metric", "a name=value CloudWatch metric submitted with every reading, e.g. DeviceCount=1 to count devices; may be repeated or comma-separated, and may not use the name of a built-in metric")
+	timestampSource := fs.String("timestamp-source", string(iotco1000.TimestampWallClock), "how reading timestamps are assigned: wallclock uses the system clock; monotonic uses the startup time plus elapsed time, keeping readings evenly spaced if the clock is stepped but offset if it was wrong at startup")
+	provenance := fs.Bool("provenance", false, "attach to each reading, in JSON output, the raw responses it was parsed from and how it was derived; for debugging data quality")
+	serialDeviceGlob := fs.String("serial-device-glob", "", "a glob, e.g. /dev/ttyUSB*, matched to find the serial device; the first match is opened, and the glob is matched again whenever the device must be reopened, so that a replaced adapter is picked up")
+	alertWebhookURL := fs.String("alert-webhook-url", "", "a URL to post a JSON notification to when the carbon monoxide concentration reaches -alert-co-threshold-ppb; readings taken while the sensor is warming up never alert unless -alert-during-warmup is given")
+	alertCOThreshold := fs.Int("alert-co-threshold-ppb", 35000, "the carbon monoxide concentration, in parts per billion, at which to alert")
+	alertDuringWarmUp := fs.Bool("alert-during-warmup", false, "alert on readings taken while the sensor is warming up, which are not accurate")
+	alertNotifyActive := fs.Bool("alert-notify-active", false, "post a monitoring_active notification to the alert webhook when each sensor completes warm up")
+	readRetryDelay := fs.Duration("read-retry-delay", 200*time.Millisecond, "how long to wait before each retry of a malformed response; retries that would run past the next poll are not attempted")
+	journal := fs.Bool("journal", false, "log readings to the systemd journal with structured fields such as AQGO_CO_PPB, for querying with journalctl -o json")
+	suppressWarmUpMetrics := fs.Bool("suppress-warmup-metrics", false, "submit nothing while the sensor is warming up, not even its uptime and warm-up status, which are otherwise submitted so that warm up can be followed")
+	shutdownTimeout := fs.Duration("shutdown-timeout", 10*time.Second, "on shutdown, how long to wait for queued readings to be submitted, and then how long each of the HTTP server, the sink queues, each sink and the state file is given to stop before its work in progress is cancelled; 0 never cancels it")
+	coUnit := fs.String("co-unit", string(sink.COUnitPPB), "the unit to submit CO concentrations to CloudWatch in: ppb, as COConcentrationPPB, or ppm, as COConcentrationPPM")
+	startupDelay := fs.Duration("startup-delay", 0, "how long after startup to take and discard readings before submitting any, as the first reads after the device is opened may be garbage; unlike the warm-up duration, this does not depend on the sensor's uptime")
+	submitRawAlongside := fs.Bool("submit-raw-alongside", false, "also submit the CO, temperature and humidity values reported by the sensor, before aggregation, clamping or unit conversion, to CloudWatch as metrics suffixed Raw; this adds to CloudWatch costs")
+	lenientParsing := fs.Bool("lenient-parsing", false, "when the CO concentration, temperature or humidity of a response cannot be parsed, submit the rest of the reading and a FieldParseError metric for the field, rather than discarding the reading")
+	checkConfig := fs.Bool("config-check", false, "validate the configuration, create each configured sink and check that it can connect, e.g. that AWS credentials are valid and the MQTT broker is reachable, then print a PASS or FAIL line for each and exit, nonzero if any failed")
+	submitUptimeComponents := fs.Bool("submit-uptime-components", false, "also submit the days, hours, minutes and seconds fields the sensor's uptime is computed from to CloudWatch, as UptimeDays, UptimeHours, UptimeMinutes and UptimeSeconds, for debugging odd uptimes")
+	archiveFormat := fs.String("archive-format", string(sink.ArchiveFormatNDJSON), "the format of objects archived to S3: ndjson, gzip-compressed with every field of each reading, or parquet, with the columns of the csv stdout format, for querying with Athena or Spark")
+	stateFile := fs.String("state-file", "", "a JSON file persisting state across restarts: when each sensor was last zero calibrated, by -calibrate or -auto-calibrate-interval, which is then submitted as SecondsSinceCalibration, and whether each sensor had warmed up")
+	calibrationStateFile := fs.String("calibration-state-file", "", "deprecated; use -state-file, which reads files written with this flag")
+	delimiterAuto := fs.Bool("delimiter-auto", false, "detect the field delimiter from the first reading, trying \", \", \",\", \";\", tab and space in turn, for fleets with differing firmware")
+	natsURL := fs.String("nats-url", "", "a NATS server URL, e.g. nats://localhost:4222, to publish readings to as JSON")
+	natsSubject := fs.String("nats-subject", "aqgo.{serial}.reading", "the NATS subject readings are published to; {serial} is replaced with the sensor serial number")
+	natsToken := fs.String("nats-token", "", "the token to authenticate to the NATS server with")
+	natsCredsFile := fs.String("nats-creds", "", "a NATS credentials file to authenticate to the NATS server with")
+	coPercentiles := fs.String("co-percentiles", "", "a comma-separated list of percentiles, e.g. 50,95,99, of each sensor's CO concentration over -co-percentile-window to submit with each reading, as COConcentrationPPBp95 and so on")
+	coPercentileWindow := fs.Duration("co-percentile-window", 15*time.Minute, "the sliding window -co-percentiles are computed over")
+	read := fs.Bool("read", false, "take a single reading, print it in the -format and exit")
+	readFormat := fs.String("format", "human", "the format -read prints the reading in (human, json or csv)")
+	flushAlign := fs.Bool("flush-align", false, "flush readings to S3 at each multiple of -s3-flush-interval in wall-clock time, e.g. at the top of each hour, rather than an interval after the previous flush")
+	maxDatumsPerRequest := fs.Int("max-datums-per-request", sink.MAX_DATUMS_PER_REQUEST, "the most datums sent to CloudWatch in a single PutMetricData request, from 1 to 1000; smaller requests are quicker and use less memory each, but more of them are made")
+	holdLastValue := fs.Duration("hold-last-value", 0, "if nonzero, resubmit the last good reading when a read fails, for up to this long after it was taken; held readings are submitted with a Stale metric of 1 and without SensorAlive, and with \"stale\": true in JSON")
+	exitOnOpenFailure := fs.Bool("exit-on-open-failure", true, "exit if the serial device cannot be opened at startup, after -open-retry-timeout; if false, keep retrying until it can be")
+	healthWeightReadSuccess := fs.Float64("health-weight-read-success", daemon.DEFAULT_HEALTH_SCORE_WEIGHTS.ReadSuccess, "the weight in DeviceHealthScore of the proportion of recent reads that succeeded")
+	healthWeightWarmedUp := fs.Float64("health-weight-warmed-up", daemon.DEFAULT_HEALTH_SCORE_WEIGHTS.WarmedUp, "the weight in DeviceHealthScore of the sensor having warmed up")
+	healthWeightFresh := fs.Float64("health-weight-fresh", daemon.DEFAULT_HEALTH_SCORE_WEIGHTS.Fresh, "the weight in DeviceHealthScore of the reading not being stale, as held by -hold-last-value; the score is the weighted mean of the three, from 0 to 100, and is not submitted if all weights are 0")
+	skipEcho := fs.Bool("skip-echo", true, "skip empty lines and echoes of the command sent that precede the sensor's response, as some serial setups produce")
+	referenceURL := fs.String("reference-url", "", "an HTTP endpoint returning the CO concentration in ppb measured by a nearby reference monitor, as a JSON number or the co_ppb field of a JSON object; warmed up readings less it are submitted as CODeltaFromReference")
+	referenceInterval := fs.Duration("reference-interval", time.Minute, "how often -reference-url is polled; its value is reused for the readings in between")
+	minUptimeBeforeSubmit := fs.Duration("min-uptime-before-submit", 0, "if nonzero, submit nothing at all, not even uptime or read failures, until the sensor has been up this long; unlike -warmup-duration, which is about whether readings can be trusted, this avoids the unparseable responses some firmware produces just after power on")
+	environment := fs.String("environment", "", "a deployment label, e.g. dev, staging or prod, added to CloudWatch metrics as the Environment dimension and to JSON readings, including those published over MQTT, as environment; up to 64 letters, digits, '.', '_' and '-'")
+	replayPath := fs.String("replay-path", "", "submit the sensor responses saved one per line in this file, timestamped as they are read, instead of reading a sensor")
+	replayFollow := fs.Bool("replay-follow", false, "keep reading -replay-path as it grows, like tail -f, rather than exiting at its end; the file may be truncated or rotated")
+	replaySpool := fs.String("replay-spool", "", "resubmit the readings saved in this -fallback-file, compressed or not, to the sinks that failed to submit them, instead of reading a sensor; records left partially written by a crash are skipped")
+	deviceAlias := fs.String("device-alias", "", "a comma-separated list of device=alias pairs; readings from the sensor on each device are submitted with the alias in place of the serial number, to tell apart sensors that report the same one")
+	serialRegistryDir := fs.String("serial-registry-dir", "", "the directory, shared by every aqgo on the host, in which the serial number read from each device is recorded, to detect sensors that report the same one; it should be writable only by the users aqgo runs as; if empty, duplicates are not detected")
+	fs.Parse(argv)
+	problems := []string{}
+	if *configFile != "" {
+		problems = append(problems, applyConfigFile(fs, *configFile)...)
 	}
-	if *metricNamespace == "" {
-		missingArguments = append(missingArguments, "metric-namespace")
+	delimiterValue, err := strconv.Unquote(`"` + *delimiter + `"`)
+	if err != nil || delimiterValue == "" {
+		problems = append(problems, fmt.Sprintf("invalid delimiter %q", *delimiter))
 	}
-	if len(missingArguments) > 0 {
-		return nil, errors.New(fmt.Sprint("missing required argument(s): ", strings.Join(missingArguments, ", ")))
+	args.Delimiter = delimiterValue
+	args.CONegativePolicy, err = sink.ParseCONegativePolicy(*coNegativePolicy)
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("invalid co-negative-policy: %s", err))
+	}
+	args.ArchiveFormat, err = sink.ParseArchiveFormat(*archiveFormat)
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("invalid archive-format: %s", err))
+	}
+	for _, s := range strings.Split(*coPercentiles, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		p, err := strconv.ParseFloat(s, 64)
+		if err != nil || p < 0 || p > 100 {
+			problems = append(problems, fmt.Sprintf("invalid co-percentiles: %q is not a percentile from 0 to 100", s))
+			continue
+		}
+		args.COPercentiles = append(args.COPercentiles, p)
 	}
+	args.COUnit, err = sink.ParseCOUnit(*coUnit)
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("invalid co-unit: %s", err))
+	}
+	if *responseRegexp != "" {
+		re, err := regexp.Compile(*responseRegexp)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("invalid response-regexp: %s", err))
+		}
+		args.ResponseRegexp = re
+	} else if *regexParser {
+		args.ResponseRegexp = iotco1000.DefaultResponseRegexp
+	}
+	if *kafkaBrokers != "" {
+		encoding, err := sink.ParseEncoding(*kafkaEncoding)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("invalid kafka-encoding: %s", err))
+		}
+		args.KafkaBrokers = strings.Split(*kafkaBrokers, ",")
+		args.KafkaTopic = *kafkaTopic
+		args.KafkaEncoding = encoding
+	}
+	args.SQSQueueURL = *sqsQueueURL
+	args.SQSBatchDelay = *sqsBatchDelay
+	args.S3Bucket = *s3Bucket
+	args.S3Prefix = *s3Prefix
+	args.S3FlushInterval = *s3FlushInterval
+	args.IoTEndpoint = *iotEndpoint
+	args.IoTTopic = *iotTopic
+	args.IoTThingName = *iotThingName
+	args.IoTUpdateShadow = *iotUpdateShadow
+	args.IoTCertFile = *iotCertFile
+	args.IoTKeyFile = *iotKeyFile
+	args.IoTCAFile = *iotCAFile
+	args.GRPCAddr = *grpcAddr
+	args.HTTPAddr = *httpAddr
+	args.PushgatewayURL = *pushgatewayURL
+	args.PushgatewayJob = *pushgatewayJob
+	args.RemoteWriteURL = *remoteWriteURL
+	args.RemoteWriteUsername = *remoteWriteUsername
+	args.RemoteWritePassword = *remoteWritePassword
+	args.RemoteWriteBearerToken = *remoteWriteBearerToken
+	args.Dedup = *dedup
+	args.DedupMaxInterval = *dedupMaxInterval
+	args.FirmwareInfo = *firmwareInfo
+	args.Calibrate = *calibrate
+	args.AutoCalibrateInterval = *autoCalibrateInterval
+	args.CloudWatchTimeout = *cloudWatchTimeout
+	args.SubmitQueueDepth = *submitQueueDepth
+	if !*noHostDimension {
+		args.Host = *host
+		if args.Host == "" {
+			hostname, err := os.Hostname()
+			if err != nil || hostname == "" {
+				// Minimal containers may have no hostname; that should not
+				// keep the sensor from being read.
+				args.Warnings = append(args.Warnings, fmt.Sprintf("failed determining hostname for the Host dimension; using %q (set -host or -no-host-dimension): %v", UNKNOWN_HOST, err))
+				hostname = UNKNOWN_HOST
+			}
+			args.Host = hostname
+		}
+	}
+	stdoutFmt, err := sink.ParseStdoutFormat(*stdoutFormat)
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("invalid stdout-format: %s", err))
+	}
+	args.StdoutFormat = stdoutFmt
+	tz, err := time.LoadLocation(*displayTimezone)
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("invalid display-timezone %q: %s", *displayTimezone, err))
+	}
+	args.DisplayTimezone = tz
+	args.AWSTargets, err = parseAWSTargets(*awsTargets)
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("invalid aws-targets: %s", err))
+	}
+	args.CloudWatchNamespaces, err = parseAWSTargets(*cloudWatchNamespaces)
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("invalid cloudwatch-namespaces: %s", err))
+	}
+	resolutions, err := parseKeyValues(*resolution)
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("invalid resolution: %s", err))
+	}
+	args.Resolutions = map[string]int32{}
+	for metric, seconds := range resolutions {
+		if seconds != "1" && seconds != "60" {
+			problems = append(problems, fmt.Sprintf("invalid resolution: %s must be 1 or 60 seconds, got %q", metric, seconds))
+			continue
+		}
+		if seconds == "1" {
+			args.Resolutions[metric] = 1
+		} else {
+			args.Resolutions[metric] = 60
+		}
+	}
+	lm, err := parseKeyValues(*locationMap)
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("invalid location-map: %s", err))
+	}
+	args.DeviceAliases, err = parseKeyValues(*deviceAlias)
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("invalid device-alias: %s", err))
+	}
+	args.Location = *location
+	args.LocationMap = lm
+	args.FallbackFile = *fallbackFile
+	args.SpoolCompress = *spoolCompress
+	args.WarmupSubmitRaw = *warmupSubmitRaw
+	args.Probe = *probe
+	args.ProbeTimeout = *probeTimeout
+	args.SettleDelay = *settleDelay
+	args.RequireClockSync = *requireClockSync
+	args.MaxMeasurementAge = *maxMeasurementAge
+	args.Check = *check
+	args.OpenRetryTimeout = *openRetryTimeout
+	args.LogGroup = *logGroup
+	args.LogStream = *logStream
+	args.LogBatchDelay = *logBatchDelay
+	args.SampleCount = *sampleCount
+	args.MaxRuntime = *maxRuntime
+	args.Stdout = *stdout
+	args.SubmitWorkers = *submitWorkers
+	args.SerialField = *serialField
+	args.SerialOverride = *serialOverride
+	args.JSONPretty = *jsonPretty
+	args.COMinDelta = *coMinDelta
+	args.TemperatureMinDelta = *temperatureMinDelta
+	args.HumidityMinDelta = *humidityMinDelta
+	args.IntervalField = *intervalField
+	args.PressureField = *pressureField
+	args.VOCField = *vocField
+	args.AlignPollInterval = *alignPollInterval
+	args.MalformedRetries = *malformedRetries
+	args.WarmUpDuration = *warmUpDuration
+	if *noWarmUpSkip {
+		args.WarmUpDuration = 0
+	}
+	args.StartupEvent = *startupEvent
+	args.COVarianceWindow = *coVarianceWindow
+	args.DumpFields = *dumpFields
+	args.PrintConfig = *printConfig
+	args.OutputFile = *outputFile
+	args.FileRotation = sink.FileRotation{
+		MaxSize:  *fileRotateSize,
+		Interval: *fileRotateInterval,
+		Keep:     *fileRotateKeep,
+		Gzip:     *fileRotateGzip,
+	}
+	args.DisabledSinks = []string{}
+	if *disableSinks != "" {
+		args.DisabledSinks = strings.Split(*disableSinks, ",")
+	}
+	args.MQTTBroker = *mqttBroker
+	args.MQTTTopic = *mqttTopic
+	args.MQTTClientID = *mqttClientID
+	args.MQTTUsername = *mqttUsername
+	args.MQTTPassword = *mqttPassword
+	args.MQTTTLS = sink.MQTTTLSOptions{
+		CAFile:             *mqttTLSCA,
+		CertFile:           *mqttTLSCert,
+		KeyFile:            *mqttTLSKey,
+		InsecureSkipVerify: *mqttTLSInsecure,
+	}
+	args.KeepaliveInterval = *keepaliveInterval
+	args.AdaptivePoll = *adaptivePoll
+	args.AdaptivePollMin = *adaptivePollMin
+	args.AdaptivePollMax = *adaptivePollMax
+	args.AdaptivePollThreshold = *adaptivePollThreshold
+	args.MaxResponseBytes = *maxResponseBytes
+	args.StaticMetrics = map[string]float64{}
+	for _, metric := range staticMetrics {
+		kv := strings.SplitN(metric, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			problems = append(problems, fmt.Sprintf("invalid static-metric: expected name=value, got %q", metric))
+			continue
+		}
+		value, err := strconv.ParseFloat(kv[1], 64)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("invalid static-metric: value of %s is not a number: %q", kv[0], kv[1]))
+			continue
+		}
+		if sink.IsMetricName(kv[0]) {
+			problems = append(problems, fmt.Sprintf("invalid static-metric: %s is the name of a built-in metric", kv[0]))
+			continue
+		}
+		args.StaticMetrics[kv[0]] = value
+	}
+	args.TimestampSource, err = iotco1000.ParseTimestampSource(*timestampSource)
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("invalid timestamp-source: %s", err))
+	}
+	args.Provenance = *provenance
+	args.SerialDeviceGlob = *serialDeviceGlob
+	args.AlertWebhookURL = *alertWebhookURL
+	args.AlertCOThreshold = *alertCOThreshold
+	args.AlertDuringWarmUp = *alertDuringWarmUp
+	args.AlertNotifyActive = *alertNotifyActive
+	args.ReadRetryDelay = *readRetryDelay
+	args.Journal = *journal
+	args.SuppressWarmUpMetrics = *suppressWarmUpMetrics
+	args.ShutdownTimeout = *shutdownTimeout
+	args.StartupDelay = *startupDelay
+	args.SubmitRawAlongside = *submitRawAlongside
+	args.LenientParsing = *lenientParsing
+	args.ConfigCheck = *checkConfig
+	args.SubmitUptimeComponents = *submitUptimeComponents
+	args.StateFile = *stateFile
+	if args.StateFile == "" {
+		args.StateFile = *calibrationStateFile
+	}
+	args.DelimiterAuto = *delimiterAuto
+	args.NATSURL = *natsURL
+	args.NATSSubject = *natsSubject
+	args.NATSToken = *natsToken
+	args.NATSCredsFile = *natsCredsFile
+	args.COPercentileWindow = *coPercentileWindow
+	args.Read = *read
+	args.ReadFormat = *readFormat
+	args.FlushAlign = *flushAlign
+	args.MaxDatumsPerRequest = *maxDatumsPerRequest
+	args.HoldLastValue = *holdLastValue
+	args.ExitOnOpenFailure = *exitOnOpenFailure
+	args.HealthScoreWeights = daemon.HealthScoreWeights{
+		ReadSuccess: *healthWeightReadSuccess,
+		WarmedUp:    *healthWeightWarmedUp,
+		Fresh:       *healthWeightFresh,
+	}
+	args.SkipEcho = *skipEcho
+	args.ReferenceURL = *referenceURL
+	args.ReferenceInterval = *referenceInterval
+	args.MinUptimeBeforeSubmit = *minUptimeBeforeSubmit
+	args.Environment = *environment
+	args.ReplayPath = *replayPath
+	args.ReplayFollow = *replayFollow
+	args.ReplaySpoolPath = *replaySpool
+	args.SerialRegistryDir = *serialRegistryDir
 	args.PollInterval = *pollInterval
 	args.SerialDevicePath = *serialDevicePath
 	args.MetricNamespace = *metricNamespace
+	args.Flags = map[string]string{}
+	fs.VisitAll(func(f *flag.Flag) {
+		args.Flags[f.Name] = f.Value.String()
+		if f.Name == "config" {
+			return
+		}
+		if err := args.Config.Set(f.Name, f.Value.String()); err != nil {
+			problems = append(problems, fmt.Sprintf("invalid %s: %s", f.Name, err))
+		}
+	})
+	// problems with values that failed to parse are reported along with
+	// those found by Validate, so that they can all be fixed at once
+	for _, err := range []error{args.Config.Validate(), args.Validate()} {
+		var invalid *daemon.ValidationError
+		if errors.As(err, &invalid) {
+			problems = append(problems, invalid.Problems...)
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	if len(problems) > 0 {
+		return nil, &daemon.ValidationError{Problems: problems}
+	}
 	return &args, nil
 }
 
-func ifp(i int) *float64 {
-	f := float64(i)
-	return &f
+// Validate checks that the values of args are in range and consistent. The
+// settings each sink requires are checked by args.Config.Validate. It returns
+// a *daemon.ValidationError describing every problem found.
+func (args *ApplicationArguments) Validate() error {
+	problems := []string{}
+	unknownMetrics := []string{}
+	for metric := range args.Resolutions {
+		if _, static := args.StaticMetrics[metric]; !static && !sink.IsMetricName(metric) {
+			unknownMetrics = append(unknownMetrics, metric)
+		}
+	}
+	if len(unknownMetrics) > 0 {
+		sort.Strings(unknownMetrics)
+		problems = append(problems, fmt.Sprintf("resolution names unknown metric(s): %s", strings.Join(unknownMetrics, ", ")))
+	}
+	// one-shot modes interact with the sensor and exit without submitting
+	// any metrics
+	oneShotModes := []string{}
+	for name, set := range map[string]bool{"firmware-info": args.FirmwareInfo, "calibrate": args.Calibrate, "probe": args.Probe, "check": args.Check, "dump-fields": args.DumpFields, "config-check": args.ConfigCheck, "read": args.Read} {
+		if set {
+			oneShotModes = append(oneShotModes, name)
+		}
+	}
+	if len(oneShotModes) > 1 {
+		sort.Strings(oneShotModes)
+		problems = append(problems, fmt.Sprintf("%s are mutually exclusive", strings.Join(oneShotModes, ", ")))
+	}
+	switch args.ReadFormat {
+	case "human", "json", "csv":
+	default:
+		problems = append(problems, fmt.Sprintf("unknown format %q; must be one of human, json, csv", args.ReadFormat))
+	}
+	if args.SampleCount < 1 {
+		problems = append(problems, "sample-count must be at least 1")
+	}
+	if args.SerialField < 0 {
+		problems = append(problems, "serial-field must not be negative")
+	}
+	if args.SerialField != 0 && args.ResponseRegexp != nil {
+		problems = append(problems, "serial-field cannot be used with regex-parser or response-regexp; name the serial group in the regular expression instead")
+	}
+	if args.Delimiter != iotco1000.RESPONSE_DELIMITER && args.ResponseRegexp != nil {
+		problems = append(problems, "delimiter cannot be used with regex-parser or response-regexp")
+	}
+	if args.SubmitWorkers < 1 {
+		problems = append(problems, "submit-workers must be at least 1")
+	}
+	if args.SubmitQueueDepth < 1 {
+		problems = append(problems, "submit-queue-depth must be at least 1")
+	}
+	if args.COMinDelta < 0 || args.TemperatureMinDelta < 0 || args.HumidityMinDelta < 0 {
+		problems = append(problems, "co-min-delta-ppb, temperature-min-delta-c and humidity-min-delta must not be negative")
+	}
+	if args.MalformedRetries < 0 {
+		problems = append(problems, "malformed-retries must not be negative")
+	}
+	if args.WarmUpDuration < 0 {
+		problems = append(problems, "warmup-duration must not be negative")
+	}
+	if args.COVarianceWindow < 0 {
+		problems = append(problems, "co-variance-window must not be negative")
+	}
+	if args.FileRotation.MaxSize < 0 || args.FileRotation.Interval < 0 || args.FileRotation.Keep < 0 {
+		problems = append(problems, "file-rotate-size, file-rotate-interval and file-rotate-keep must not be negative")
+	}
+	if args.KeepaliveInterval < 0 {
+		problems = append(problems, "keepalive-interval must not be negative")
+	}
+	if args.AdaptivePoll && (args.AdaptivePollMin <= 0 || args.AdaptivePollMax < args.AdaptivePollMin) {
+		problems = append(problems, "adaptive-poll-min must be positive and no greater than adaptive-poll-max")
+	}
+	if args.AdaptivePollThreshold < 0 {
+		problems = append(problems, "adaptive-poll-threshold-ppb must not be negative")
+	}
+	if args.MaxResponseBytes < 1 {
+		problems = append(problems, "max-response-bytes must be at least 1")
+	}
+	if args.SerialDeviceGlob != "" && args.SerialDevicePath != "" {
+		problems = append(problems, "serial-device-glob and serial-device-path are mutually exclusive")
+	}
+	if args.AlertCOThreshold < 1 {
+		problems = append(problems, "alert-co-threshold-ppb must be at least 1")
+	}
+	if args.ReadRetryDelay < 0 {
+		problems = append(problems, "read-retry-delay must not be negative")
+	}
+	if args.SuppressWarmUpMetrics && args.WarmupSubmitRaw {
+		problems = append(problems, "suppress-warmup-metrics and warmup-submit-raw are mutually exclusive")
+	}
+	if args.ShutdownTimeout < 0 {
+		problems = append(problems, "shutdown-timeout must not be negative")
+	}
+	if args.StartupDelay < 0 {
+		problems = append(problems, "startup-delay must not be negative")
+	}
+	if args.DelimiterAuto && (args.Delimiter != iotco1000.RESPONSE_DELIMITER || args.ResponseRegexp != nil) {
+		problems = append(problems, "delimiter-auto cannot be used with delimiter, regex-parser or response-regexp")
+	}
+	if len(args.COPercentiles) > 0 && args.COPercentileWindow <= 0 {
+		problems = append(problems, "co-percentile-window must be positive")
+	}
+	if args.MaxDatumsPerRequest < 1 || args.MaxDatumsPerRequest > sink.MAX_DATUMS_PER_REQUEST {
+		problems = append(problems, fmt.Sprintf("max-datums-per-request must be from 1 to %d", sink.MAX_DATUMS_PER_REQUEST))
+	}
+	if args.HealthScoreWeights.ReadSuccess < 0 || args.HealthScoreWeights.WarmedUp < 0 || args.HealthScoreWeights.Fresh < 0 {
+		problems = append(problems, "health score weights must not be negative")
+	}
+	if args.ReferenceURL != "" && args.ReferenceInterval <= 0 {
+		problems = append(problems, "reference-interval must be positive")
+	}
+	if args.Environment != "" && !ENVIRONMENT_PATTERN.MatchString(args.Environment) {
+		problems = append(problems, fmt.Sprintf("environment %q must be up to 64 letters, digits, '.', '_' and '-'", args.Environment))
+	}
+	if args.ReplayFollow && args.ReplayPath == "" {
+		problems = append(problems, "replay-follow requires replay-path")
+	}
+	if args.ReplaySpoolPath != "" && args.ReplayPath != "" {
+		problems = append(problems, "replay-spool and replay-path cannot be combined")
+	}
+	if args.ReplaySpoolPath != "" && args.ReplaySpoolPath == args.FallbackFile {
+		problems = append(problems, "replay-spool must not be the fallback-file that readings failing again are written to")
+	}
+	if len(problems) > 0 {
+		return &daemon.ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// applyConfigFile sets the flags of fs from the config.Config in the JSON
+// file at path. Flags that were set on the command line are not changed. It
+// returns the problems found with the file, each prefixed with its path.
+func applyConfigFile(fs *flag.FlagSet, path string) []string {
+	var file config.Config
+	names, err := file.Load(path)
+	problems := []string{}
+	var invalid *daemon.ValidationError
+	if errors.As(err, &invalid) {
+		for _, p := range invalid.Problems {
+			problems = append(problems, fmt.Sprintf("config file %s: %s", path, p))
+		}
+	} else if err != nil {
+		return []string{fmt.Sprintf("failed loading config file %s: %s", path, err)}
+	}
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	for _, name := range names {
+		if set[name] {
+			continue
+		}
+		value, _ := file.Get(name)
+		if err := fs.Set(name, value); err != nil {
+			problems = append(problems, fmt.Sprintf("config file %s: invalid %s: %s", path, name, err))
+		}
+	}
+	return problems
+}
+
+// reloadArguments re-parses the configuration on each SIGHUP and sends the
+// configuration newConfig builds from it on reload, until ctx is cancelled.
+// Changed settings that cannot be applied while running are logged.
+func reloadArguments(ctx context.Context, logger *log.Logger, args *ApplicationArguments, newConfig func(*ApplicationArguments) daemon.Config, reload chan<- daemon.Config) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+		}
+		_, argv := commandArguments(os.Args[1:])
+		newArgs, err := parseArguments(argv)
+		if err != nil {
+			logger.Printf("failed reloading configuration; keeping current configuration: %s\n", err)
+			continue
+		}
+		// Changes are compared against the configuration the process
+		// started with, since that is what restart-only settings still use.
+		for name, value := range newArgs.Flags {
+			if value != args.Flags[name] && !RELOADABLE_FLAGS[name] {
+				logger.Printf("setting %s changed from %q to %q; restart to apply it\n", name, args.Flags[name], value)
+			}
+		}
+		select {
+		case reload <- newConfig(newArgs):
+		case <-ctx.Done():
+			return
+		}
+	}
 }
 
-func ffp(i float64) *float64 {
-	return &i
+// parseAWSTargets parses a comma-separated list of key=profile/region pairs.
+func parseAWSTargets(s string) (map[string]sink.AWSTarget, error) {
+	kvs, err := parseKeyValues(s)
+	if err != nil {
+		return nil, err
+	}
+	targets := map[string]sink.AWSTarget{}
+	for key, target := range kvs {
+		pr := strings.SplitN(target, "/", 2)
+		if len(pr) != 2 {
+			return nil, fmt.Errorf("expected profile/region for %s, got %q", key, target)
+		}
+		targets[key] = sink.AWSTarget{Profile: pr[0], Region: pr[1]}
+	}
+	return targets, nil
+}
+
+// listFlag is a flag that may be repeated, each value of which may itself be
+// a comma-separated list.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(s string) error {
+	*l = append(*l, strings.Split(s, ",")...)
+	return nil
+}
+
+// parseKeyValues parses a comma-separated list of key=value pairs.
+func parseKeyValues(s string) (map[string]string, error) {
+	m := map[string]string{}
+	if s == "" {
+		return m, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return nil, fmt.Errorf("expected key=value, got %q", pair)
+		}
+		m[kv[0]] = kv[1]
+	}
+	return m, nil
 }
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jkoelndorfer/aqgo/config"
+	"github.com/jkoelndorfer/aqgo/daemon"
+)
+
+func TestConfigHasEveryFlag(t *testing.T) {
+	args, err := parseArguments([]string{"-metric-namespace", "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings := map[string]bool{}
+	typ := reflect.TypeOf(config.Config{})
+	for i := 0; i < typ.NumField(); i++ {
+		name := typ.Field(i).Tag.Get("json")
+		settings[name] = true
+		if _, ok := args.Flags[name]; !ok {
+			t.Errorf("config.Config has %s, which is not a flag", name)
+		}
+	}
+	for name := range args.Flags {
+		if !settings[name] && name != "config" {
+			t.Errorf("flag %s has no config.Config setting", name)
+		}
+	}
+}
+
+func writeConfigFile(t *testing.T, file string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "aqgo.json")
+	if err := ioutil.WriteFile(path, []byte(file), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestParseArgumentsConfigFile(t *testing.T) {
+	path := writeConfigFile(t, `{"metric-namespace": "test", "poll-interval": 10000, "warmup-duration": "1h", "stdout": true}`)
+	args, err := parseArguments([]string{"-config", path, "-poll-interval", "2000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if args.PollInterval != 2000 || args.WarmUpDuration != time.Hour || !args.Stdout || args.MetricNamespace != "test" {
+		t.Errorf("parsed poll interval %d, warm-up %s, stdout %t, namespace %q; want the command line to take precedence over the file", args.PollInterval, args.WarmUpDuration, args.Stdout, args.MetricNamespace)
+	}
+
+	path = writeConfigFile(t, `{"metric-namespace": "test", "sample-count": "two", "bogus": 1, "mqtt-username": "aqgo"}`)
+	_, err = parseArguments([]string{"-config", path, "-co-unit", "furlongs"})
+	var invalid *daemon.ValidationError
+	if !errors.As(err, &invalid) {
+		t.Fatalf("parseArguments() = %v, want a *daemon.ValidationError", err)
+	}
+	problems := strings.Join(invalid.Problems, "\n")
+	for _, want := range []string{`unknown setting "bogus"`, "invalid sample-count", "invalid co-unit", "mqtt-username given without mqtt-broker"} {
+		if !strings.Contains(problems, want) {
+			t.Errorf("problems do not include %q:\n%s", want, problems)
+		}
+	}
+}
+
+func TestShutdownGivesEachStopperItsOwnTimeout(t *testing.T) {
+	logger := log.New(ioutil.Discard, "", 0)
+	closed, saved := false, false
+	shutdown(logger, 50*time.Millisecond,
+		stopper{"sink queues", func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}},
+		stopper{"sink s3", func(ctx context.Context) error {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			// overruns its timeout, but is still waited for
+			time.Sleep(100 * time.Millisecond)
+			closed = true
+			return nil
+		}},
+		stopper{"state file", func(ctx context.Context) error {
+			saved = ctx.Err() == nil
+			return nil
+		}},
+	)
+	if !closed || !saved {
+		t.Errorf("sink closed %t, state saved %t after the sink queues used their timeout; want both", closed, saved)
+	}
+}
@@ -0,0 +1,133 @@
+package simulator
+
+// This package simulates an IOT-CO-1000 sensor over TCP, for developing and
+// demonstrating aqgo without hardware.
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/jkoelndorfer/aqgo/iotco1000"
+)
+
+// FIRMWARE_INFO is the response to iotco1000.FIRMWARE_INFO_COMMAND.
+const FIRMWARE_INFO = "FW Date: Simulated"
+
+type Simulator struct {
+	Serial string
+
+	// StartUptime is the uptime reported when the simulator starts, or
+	// after a reset. UptimeScale is the number of seconds of uptime that
+	// elapse per real second, so that warm-up can be watched quickly.
+	StartUptime time.Duration
+	UptimeScale float64
+
+	// MalformedEvery, if nonzero, makes every MalformedEvery'th response
+	// malformed.
+	MalformedEvery int
+
+	// ResetEvery, if nonzero, resets the uptime to zero this often, as if
+	// the sensor had lost power.
+	ResetEvery time.Duration
+
+	mu        sync.Mutex
+	lastReset time.Time
+	responses int
+	co        float64
+}
+
+func New(serial string) *Simulator {
+	return &Simulator{
+		Serial:      serial,
+		UptimeScale: 1,
+		lastReset:   time.Now(),
+	}
+}
+
+// Response returns the simulator's response to a measurement trigger.
+func (s *Simulator) Response() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.responses++
+	if s.MalformedEvery > 0 && s.responses%s.MalformedEvery == 0 {
+		return fmt.Sprintf("%s, garbled\r\n", s.Serial)
+	}
+	if s.ResetEvery > 0 && time.Since(s.lastReset) >= s.ResetEvery {
+		s.lastReset = time.Now()
+		s.StartUptime = 0
+	}
+	uptime := s.StartUptime + time.Duration(float64(time.Since(s.lastReset))*s.UptimeScale)
+	s.co = clamp(s.co+rand.NormFloat64(), 0, 50)
+	temperature := 22 + rand.NormFloat64()*0.5
+	humidity := 40 + rand.NormFloat64()
+	seconds := int(uptime.Seconds())
+	return fmt.Sprintf(
+		"%s, %d, %d, %d, %d, %d, %d, %02d, %02d, %02d, %02d\r\n",
+		s.Serial, int(s.co), int(temperature), int(humidity),
+		28000+rand.Intn(100), 25000+rand.Intn(100), 30000+rand.Intn(100),
+		seconds/86400, seconds/3600%24, seconds/60%60, seconds%60,
+	)
+}
+
+// Serve accepts connections on l and responds to the commands sent on them,
+// until ctx is cancelled.
+func (s *Simulator) Serve(ctx context.Context, l net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go s.handle(ctx, conn)
+	}
+}
+
+func (s *Simulator) handle(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+	r := bufio.NewReader(conn)
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return
+		}
+		var response string
+		switch string(b) {
+		case "\n":
+			response = s.Response()
+		case iotco1000.FIRMWARE_INFO_COMMAND:
+			response = FIRMWARE_INFO + "\r\n"
+		case iotco1000.ZERO_CALIBRATION_COMMAND:
+			response = "Setting zero...done\r\n"
+		default:
+			continue
+		}
+		if _, err := conn.Write([]byte(response)); err != nil {
+			return
+		}
+	}
+}
+
+func clamp(v, min, max float64) float64 {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
@@ -0,0 +1,143 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jkoelndorfer/aqgo/iotco1000"
+)
+
+const (
+	ALERT_EVENT_CO_HIGH           = "co_high"
+	ALERT_EVENT_MONITORING_ACTIVE = "monitoring_active"
+)
+
+// ALERT_WEBHOOK_TIMEOUT bounds each request to the webhook, so that one that
+// never responds does not hold up the readings submitted after it.
+const ALERT_WEBHOOK_TIMEOUT = 10 * time.Second
+
+// AlertSink posts a JSON notification to a webhook when a sensor's carbon
+// monoxide concentration rises to COThresholdPPB. It alerts again only after
+// the concentration has fallen back below the threshold.
+//
+// Readings taken before the sensor has warmed up are not trusted, so they
+// never raise an alert unless DuringWarmUp is set.
+type AlertSink struct {
+	URL            string
+	COThresholdPPB int
+	Client         *http.Client
+
+	// DuringWarmUp alerts on readings taken while the sensor is warming up.
+	DuringWarmUp bool
+
+	// NotifyActive posts a monitoring_active notification when a sensor
+	// completes warm up, after which its readings may raise alerts. If the
+	// notification cannot be posted, it is retried with each of the sensor's
+	// readings until it is.
+	NotifyActive bool
+
+	// alerting holds, by sensor serial number, whether the last reading was
+	// at or above the threshold.
+	alerting map[string]bool
+
+	// activePending holds, by sensor serial number, the reading that
+	// completed warm up, for sensors whose monitoring_active notification
+	// has yet to be posted.
+	activePending map[string]*Reading
+}
+
+type alertNotification struct {
+	Event              string    `json:"event"`
+	SensorSerialNumber string    `json:"sensor_serial_number"`
+	Location           string    `json:"location,omitempty"`
+	MeasurementTime    time.Time `json:"measurement_time"`
+	COConcentrationPPB int       `json:"co_concentration_ppb"`
+	COThresholdPPB     int       `json:"co_threshold_ppb"`
+	SensorWarmedUp     bool      `json:"sensor_warmed_up"`
+}
+
+func NewAlertSink(url string, coThresholdPPB int, duringWarmUp, notifyActive bool) *AlertSink {
+	return &AlertSink{
+		URL:            url,
+		COThresholdPPB: coThresholdPPB,
+		Client:         &http.Client{Timeout: ALERT_WEBHOOK_TIMEOUT},
+		DuringWarmUp:   duringWarmUp,
+		NotifyActive:   notifyActive,
+		alerting:       map[string]bool{},
+		activePending:  map[string]*Reading{},
+	}
+}
+
+func (s *AlertSink) Name() string {
+	return "alert"
+}
+
+func (s *AlertSink) Submit(ctx context.Context, r *Reading) error {
+	if r.WarmUpCompleted && s.NotifyActive {
+		s.activePending[r.SensorSerialNumber] = r
+	}
+	// a failure to post the monitoring_active notification must not keep
+	// the reading from raising an alert, so it is returned only once the
+	// reading has been checked against the threshold
+	var activeErr error
+	if active := s.activePending[r.SensorSerialNumber]; active != nil {
+		if activeErr = s.post(ctx, ALERT_EVENT_MONITORING_ACTIVE, active); activeErr == nil {
+			delete(s.activePending, r.SensorSerialNumber)
+		}
+	}
+	if !r.SensorWarmedUp && !s.DuringWarmUp || !r.FieldValid(iotco1000.FIELD_CO) {
+		return activeErr
+	}
+	high := r.COConcentrationPPB >= s.COThresholdPPB
+	if high == s.alerting[r.SensorSerialNumber] {
+		return activeErr
+	}
+	if high {
+		if err := s.post(ctx, ALERT_EVENT_CO_HIGH, r); err != nil {
+			return err
+		}
+	}
+	s.alerting[r.SensorSerialNumber] = high
+	return activeErr
+}
+
+func (s *AlertSink) post(ctx context.Context, event string, r *Reading) error {
+	body, err := json.Marshal(alertNotification{
+		Event:              event,
+		SensorSerialNumber: r.SensorSerialNumber,
+		Location:           r.Location,
+		MeasurementTime:    r.MeasurementTime,
+		COConcentrationPPB: r.COConcentrationPPB,
+		COThresholdPPB:     s.COThresholdPPB,
+		SensorWarmedUp:     r.SensorWarmedUp,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("alert webhook returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+func (s *AlertSink) Close() error {
+	return nil
+}
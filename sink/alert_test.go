@@ -0,0 +1,46 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAlertSinkRetriesMonitoringActive(t *testing.T) {
+	fail := true
+	events := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if fail {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		var n alertNotification
+		if err := json.NewDecoder(req.Body).Decode(&n); err != nil {
+			t.Error(err)
+		}
+		events = append(events, n.Event)
+	}))
+	defer server.Close()
+
+	s := NewAlertSink(server.URL, 50, false, true)
+	r := testReading(true)
+	r.WarmUpCompleted = true
+	if err := s.Submit(context.Background(), r); err == nil {
+		t.Fatal("Submit succeeded with the webhook unavailable")
+	}
+
+	fail = false
+	high := testReading(true)
+	high.COConcentrationPPB = 100
+	if err := s.Submit(context.Background(), high); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Submit(context.Background(), testReading(true)); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0] != ALERT_EVENT_MONITORING_ACTIVE || events[1] != ALERT_EVENT_CO_HIGH {
+		t.Errorf("posted %v, want %s retried once, then %s", events, ALERT_EVENT_MONITORING_ACTIVE, ALERT_EVENT_CO_HIGH)
+	}
+}
@@ -0,0 +1,39 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+)
+
+func awsConfig() (aws.Config, error) {
+	return awsConfigFor(AWSTarget{})
+}
+
+// AWSTarget identifies the account and region metrics are submitted to by a
+// shared config profile and region. Empty fields use the defaults.
+type AWSTarget struct {
+	Profile string
+	Region  string
+}
+
+func (t AWSTarget) String() string {
+	return t.Profile + "/" + t.Region
+}
+
+func awsConfigFor(t AWSTarget) (aws.Config, error) {
+	opts := []func(*config.LoadOptions) error{}
+	if t.Profile != "" {
+		opts = append(opts, config.WithSharedConfigProfile(t.Profile))
+	}
+	if t.Region != "" {
+		opts = append(opts, config.WithRegion(t.Region))
+	}
+	cfg, err := config.LoadDefaultConfig(context.TODO(), opts...)
+	if err != nil {
+		return aws.Config{}, fmt.Errorf("error loading AWS config for %s: %s", t, err)
+	}
+	return cfg, nil
+}
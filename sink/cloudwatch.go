@@ -0,0 +1,1159 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	cwtypes "github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/jkoelndorfer/aqgo/iotco1000"
+)
+
+var CO_CONCENTRATION_PPB = "COConcentrationPPB"
+var CO_CONCENTRATION_PPM = "COConcentrationPPM"
+var CO_CONCENTRATION_PPB_RAW = "COConcentrationPPBRaw"
+var CO_DELTA_FROM_REFERENCE = "CODeltaFromReference"
+var TEMPERATURE_C_RAW = "TemperatureCRaw"
+var RELATIVE_HUMIDITY_RAW = "RelativeHumidityRaw"
+var TEMPERATURE_C = "TemperatureC"
+var RELATIVE_HUMIDITY = "RelativeHumidity"
+var UPTIME = "Uptime"
+var SENSOR_UPTIME_HOURS = "SensorUptimeHours"
+var UPTIME_DAYS = "UptimeDays"
+var UPTIME_HOURS = "UptimeHours"
+var UPTIME_MINUTES = "UptimeMinutes"
+var UPTIME_SECONDS = "UptimeSeconds"
+var SENSOR_ID = "SensorID"
+var HOST = "Host"
+var ENVIRONMENT = "Environment"
+var LOCATION = "Location"
+var SENSOR_WARMED_UP = "SensorWarmedUp"
+var CALIBRATED = "Calibrated"
+var RESPONSE_BYTES = "ResponseBytes"
+var SERIAL_THROUGHPUT_BYTES_PER_SEC = "SerialThroughputBytesPerSec"
+var PRESSURE_HPA = "PressurehPa"
+var VOC_INDEX = "VOCIndex"
+var PREFLIGHT_CHECK = "PreflightCheck"
+var SUBMIT_SUCCESS = "SubmitSuccess"
+var SAMPLE_COUNT = "SampleCount"
+var DROPPED_READINGS = "DroppedReadings"
+var SUBMIT_WORKERS = "SubmitWorkers"
+var CHANNEL_BACKLOG = "ChannelBacklog"
+
+// SENSOR_ALIVE is submitted with every reading and never otherwise, so that
+// it goes missing when the sensor cannot be read. Alarm on it with missing
+// data treated as breaching.
+var SENSOR_ALIVE = "SensorAlive"
+var STARTUP = "Startup"
+var DEVICE_HEALTH_SCORE = "DeviceHealthScore"
+
+// STALE is submitted with readings that repeat the last good reading in
+// place of one that failed. See Reading.Stale.
+var STALE = "Stale"
+var READ_FAILURE = "ReadFailure"
+var CATEGORY = "Category"
+var CONFIGURED_POLL_INTERVAL_MS = "ConfiguredPollIntervalMs"
+var CONFIGURED_WARMUP_SECONDS = "ConfiguredWarmupSeconds"
+var CO_VARIANCE = "COVariance"
+var CO_Z_SCORE = "COZScore"
+var CONSECUTIVE_SUCCESSES = "ConsecutiveSuccesses"
+var WARM_UP_COMPLETED = "WarmUpCompleted"
+var RESPONSE_TRUNCATED = "ResponseTruncated"
+var DEVICE_ATTACHED = "DeviceAttached"
+var DEVICE_DETACHED = "DeviceDetached"
+var DUPLICATE_SERIAL_DETECTED = "DuplicateSerialDetected"
+var POLL_OVERRUN = "PollOverrun"
+var SECONDS_SINCE_CALIBRATION = "SecondsSinceCalibration"
+var FIELD_PARSE_ERROR = "FieldParseError"
+var FIELD = "Field"
+var SINK_ERRORS = "SinkErrors"
+var SINK_SUCCESSES = "SinkSuccesses"
+var SINK = "Sink"
+
+// METRIC_NAMES are the names of the metrics a CloudWatchSink submits, apart
+// from the CO percentiles, which are named for the configured percentiles,
+// and static metrics.
+var METRIC_NAMES = []string{
+	CO_CONCENTRATION_PPB, CO_CONCENTRATION_PPM, CO_CONCENTRATION_PPB_RAW, CO_DELTA_FROM_REFERENCE,
+	TEMPERATURE_C_RAW, RELATIVE_HUMIDITY_RAW, TEMPERATURE_C, RELATIVE_HUMIDITY,
+	UPTIME, SENSOR_UPTIME_HOURS, UPTIME_DAYS, UPTIME_HOURS, UPTIME_MINUTES, UPTIME_SECONDS,
+	SENSOR_WARMED_UP, CALIBRATED, RESPONSE_BYTES, SERIAL_THROUGHPUT_BYTES_PER_SEC, PRESSURE_HPA, VOC_INDEX,
+	PREFLIGHT_CHECK, SUBMIT_SUCCESS, SAMPLE_COUNT, DROPPED_READINGS, SUBMIT_WORKERS, CHANNEL_BACKLOG,
+	SENSOR_ALIVE, STARTUP, DEVICE_HEALTH_SCORE, STALE, READ_FAILURE,
+	CONFIGURED_POLL_INTERVAL_MS, CONFIGURED_WARMUP_SECONDS, CO_VARIANCE, CO_Z_SCORE, CONSECUTIVE_SUCCESSES,
+	WARM_UP_COMPLETED, RESPONSE_TRUNCATED, DEVICE_ATTACHED, DEVICE_DETACHED, DUPLICATE_SERIAL_DETECTED,
+	POLL_OVERRUN, SECONDS_SINCE_CALIBRATION, FIELD_PARSE_ERROR, SINK_ERRORS, SINK_SUCCESSES,
+}
+
+// IsMetricName reports whether a CloudWatchSink submits a metric named name,
+// either one of METRIC_NAMES or a CO percentile such as
+// COConcentrationPPBp95.
+func IsMetricName(name string) bool {
+	for _, n := range METRIC_NAMES {
+		if name == n {
+			return true
+		}
+	}
+	if p := strings.TrimPrefix(name, CO_CONCENTRATION_PPB+"p"); p != name {
+		f, err := strconv.ParseFloat(p, 64)
+		return err == nil && f >= 0 && f <= 100
+	}
+	return false
+}
+
+// CONFIGURATION_REPORT_INTERVAL is how often the configured poll interval and
+// warm-up duration are reported.
+const CONFIGURATION_REPORT_INTERVAL = time.Hour
+
+// MAX_DATUMS_PER_REQUEST is the most datums PutMetricData accepts in a single
+// request.
+const MAX_DATUMS_PER_REQUEST = 1000
+
+// CONegativePolicy selects how CloudWatchSink submits negative CO
+// concentrations, which the sensor reports as its zero point drifts.
+type CONegativePolicy string
+
+const (
+	// CONegativeClamp submits negative concentrations as 0.
+	CONegativeClamp CONegativePolicy = "clamp"
+	// CONegativeDrop omits the CO concentration of readings where it is
+	// negative. The reading's other metrics are still submitted.
+	CONegativeDrop CONegativePolicy = "drop"
+	// CONegativePass submits negative concentrations as reported.
+	CONegativePass CONegativePolicy = "pass"
+)
+
+func ParseCONegativePolicy(s string) (CONegativePolicy, error) {
+	switch p := CONegativePolicy(s); p {
+	case CONegativeClamp, CONegativeDrop, CONegativePass:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown policy %q; must be one of clamp, drop, pass", s)
+}
+
+// COUnit selects the unit CloudWatchSink submits CO concentrations in.
+type COUnit string
+
+const (
+	COUnitPPB COUnit = "ppb"
+	COUnitPPM COUnit = "ppm"
+)
+
+func ParseCOUnit(s string) (COUnit, error) {
+	switch u := COUnit(s); u {
+	case COUnitPPB, COUnitPPM:
+		return u, nil
+	}
+	return "", fmt.Errorf("unknown unit %q; must be one of ppb, ppm", s)
+}
+
+// ErrOutOfOrder is returned by CloudWatchSink.Submit for a reading older than
+// one already submitted for the same sensor.
+var ErrOutOfOrder = errors.New("reading is older than one already submitted")
+
+// CloudWatchSink submits readings as CloudWatch metrics. It is safe for
+// concurrent use. Submissions are serialized and, within each
+// PutMetricData call, datums are sorted by timestamp, so the timestamps of
+// each sensor's metrics never decrease from one call to the next: a reading
+// older than one already submitted for its sensor is rejected with
+// ErrOutOfOrder, and written to Fallback if that is set.
+type CloudWatchSink struct {
+	Client    *cloudwatch.Client
+	STSClient *sts.Client
+	Namespace string
+
+	// Region is the region of Client.
+	Region string
+
+	// Targets, keyed by sensor serial number, directs the metrics of those
+	// sensors to other accounts or regions than Client's. Clients are
+	// created as needed and shared by sensors with the same target.
+	Targets map[string]AWSTarget
+	clients map[AWSTarget]*cloudwatch.Client
+
+	// Resolutions, keyed by metric name, overrides the storage resolution
+	// in seconds of those metrics: 1 for high resolution or 60 for
+	// standard resolution.
+	Resolutions map[string]int32
+
+	// Timeout bounds each PutMetricData call.
+	Timeout time.Duration
+
+	// Host, if set, is added to every datum as the Host dimension.
+	Host string
+
+	// Environment, if set, is added to every datum as the Environment
+	// dimension, to separate the metrics of deployments such as dev and
+	// prod.
+	Environment string
+
+	// Fallback, if set, records readings that could not be submitted.
+	Fallback *FallbackFile
+
+	// WarmupSubmitRaw submits CO, temperature and humidity while the sensor
+	// is warming up, even though they are not yet trustworthy.
+	WarmupSubmitRaw bool
+
+	// CONegativePolicy is how negative CO concentrations are submitted. If
+	// empty, CONegativeClamp is used.
+	CONegativePolicy CONegativePolicy
+
+	// SubmitRawAlongside also submits the CO concentration, temperature and
+	// humidity as reported by the sensor, before aggregation, clamping or
+	// unit conversion, as COConcentrationPPBRaw, TemperatureCRaw and
+	// RelativeHumidityRaw.
+	SubmitRawAlongside bool
+
+	// SubmitUptimeComponents also submits the days, hours, minutes and
+	// seconds fields the uptime was computed from, as UptimeDays,
+	// UptimeHours, UptimeMinutes and UptimeSeconds.
+	SubmitUptimeComponents bool
+
+	// COUnit is the unit CO concentrations are submitted in, named
+	// COConcentrationPPB or COConcentrationPPM accordingly. If empty,
+	// COUnitPPB is used.
+	COUnit COUnit
+
+	// MaxDatumsPerRequest, if nonzero, splits submissions into
+	// PutMetricData requests of at most this many datums. Smaller requests
+	// take less time and memory each, at the cost of more of them. If zero,
+	// MAX_DATUMS_PER_REQUEST is used.
+	MaxDatumsPerRequest int
+
+	// SubmitWorkers, if nonzero, is the size of the Fanout worker pool this
+	// sink submits through, and is reported as a metric alongside each
+	// reading.
+	SubmitWorkers int
+
+	// StaticMetrics, keyed by metric name, are submitted with the same
+	// value and dimensions as every reading, e.g. DeviceCount=1 to count
+	// active devices with SUM.
+	StaticMetrics map[string]float64
+
+	// ConfiguredPollInterval and ConfiguredWarmUp are reported with the first
+	// submission and every CONFIGURATION_REPORT_INTERVAL thereafter, so that
+	// misconfigured devices can be found from their metrics.
+	ConfiguredPollInterval time.Duration
+	ConfiguredWarmUp       time.Duration
+	configReported         time.Time
+
+	// successes counts the successful PutMetricData requests submitting
+	// readings that have not yet been reported in the SubmitSuccess metric.
+	// A request can only be known to have succeeded after it returns, so each
+	// is reported with the next reading,
+	// or on Close, with the dimensions of the last reading submitted,
+	// successDimensions.
+	successes         int
+	successDimensions []cwtypes.Dimension
+
+	// sinkResults counts, by sink name, the results recorded by
+	// SubmitSinkResults that have yet to be submitted.
+	sinkResults map[string]*sinkResultCounts
+
+	mu sync.Mutex
+	// submitted holds the time of the latest reading submitted for each
+	// sensor.
+	submitted map[string]time.Time
+}
+
+type sinkResultCounts struct {
+	successes int
+	errors    int
+}
+
+func NewCloudWatchSink(ns string, timeout time.Duration, host string) (*CloudWatchSink, error) {
+	return NewCloudWatchSinkForTarget(ns, AWSTarget{}, timeout, host)
+}
+
+// NewCloudWatchSinkForTarget returns a CloudWatchSink that submits to
+// namespace ns in the account and region of target.
+func NewCloudWatchSinkForTarget(ns string, target AWSTarget, timeout time.Duration, host string) (*CloudWatchSink, error) {
+	cfg, err := awsConfigFor(target)
+	if err != nil {
+		return nil, err
+	}
+	return &CloudWatchSink{
+		Client:    cloudwatch.NewFromConfig(cfg),
+		STSClient: sts.NewFromConfig(cfg),
+		Namespace: ns,
+		Region:    cfg.Region,
+		Timeout:   timeout,
+		Host:      host,
+	}, nil
+}
+
+func (s *CloudWatchSink) Name() string {
+	return "cloudwatch:" + s.Namespace
+}
+
+func (s *CloudWatchSink) Submit(ctx context.Context, r *Reading) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if last, ok := s.submitted[r.SensorSerialNumber]; ok && r.MeasurementTime.Before(last) {
+		err := fmt.Errorf("%w: %s is before %s", ErrOutOfOrder, r.MeasurementTime.Format(time.RFC3339Nano), last.Format(time.RFC3339Nano))
+		if s.Fallback != nil {
+			if ferr := s.Fallback.Write(s.Name(), r, err); ferr != nil {
+				return fmt.Errorf("%s (also failed writing to fallback file: %s)", err, ferr)
+			}
+		}
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.Timeout)
+	defer cancel()
+	params := s.metricDataInput(r)
+	reported := s.successes
+	if reported > 0 {
+		params.MetricData = append(params.MetricData, cwtypes.MetricDatum{
+			MetricName: &SUBMIT_SUCCESS,
+			Value:      ifp(reported),
+			Dimensions: params.MetricData[0].Dimensions,
+			Unit:       cwtypes.StandardUnitCount,
+			Timestamp:  &r.MeasurementTime,
+		})
+	}
+	params.MetricData = append(params.MetricData, s.sinkResultData(&r.MeasurementTime)...)
+	reportConfig := time.Since(s.configReported) >= CONFIGURATION_REPORT_INTERVAL
+	if reportConfig {
+		params.MetricData = append(params.MetricData, cwtypes.MetricDatum{
+			MetricName: &CONFIGURED_POLL_INTERVAL_MS,
+			Value:      ffp(float64(s.ConfiguredPollInterval.Milliseconds())),
+			Dimensions: params.MetricData[0].Dimensions,
+			Unit:       cwtypes.StandardUnitMilliseconds,
+			Timestamp:  &r.MeasurementTime,
+		}, cwtypes.MetricDatum{
+			MetricName: &CONFIGURED_WARMUP_SECONDS,
+			Value:      ffp(s.ConfiguredWarmUp.Seconds()),
+			Dimensions: params.MetricData[0].Dimensions,
+			Unit:       cwtypes.StandardUnitSeconds,
+			Timestamp:  &r.MeasurementTime,
+		})
+	}
+	for i, d := range params.MetricData {
+		if resolution, ok := s.Resolutions[*d.MetricName]; ok {
+			params.MetricData[i].StorageResolution = &resolution
+		}
+	}
+	sortDatums(params.MetricData)
+	client, err := s.clientFor(r.SensorSerialNumber)
+	if err == nil {
+		err = s.putMetricData(ctx, client, params, true)
+	}
+	if err == nil {
+		if s.submitted == nil {
+			s.submitted = map[string]time.Time{}
+		}
+		s.submitted[r.SensorSerialNumber] = r.MeasurementTime
+		s.successDimensions = params.MetricData[0].Dimensions
+		s.sinkResults = nil
+		if reportConfig {
+			s.configReported = time.Now()
+		}
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		err = fmt.Errorf("PutMetricData timed out after %s", s.Timeout)
+	}
+	if err != nil && s.Fallback != nil {
+		if ferr := s.Fallback.Write(s.Name(), r, err); ferr != nil {
+			return fmt.Errorf("%s (also failed writing to fallback file: %s)", err, ferr)
+		}
+	}
+	return err
+}
+
+// putMetricData submits params with client, split into as many requests as
+// MaxDatumsPerRequest requires. Requests are made in order, stopping at the
+// first that fails. If reading is set, each request that succeeds is counted
+// in s.successes, less the SubmitSuccess count it reports.
+func (s *CloudWatchSink) putMetricData(ctx context.Context, client *cloudwatch.Client, params *cloudwatch.PutMetricDataInput, reading bool) error {
+	max := s.MaxDatumsPerRequest
+	if max <= 0 {
+		max = MAX_DATUMS_PER_REQUEST
+	}
+	datums := params.MetricData
+	for len(datums) > 0 {
+		n := max
+		if n > len(datums) {
+			n = len(datums)
+		}
+		request := *params
+		request.MetricData = datums[:n]
+		if _, err := client.PutMetricData(ctx, &request); err != nil {
+			return err
+		}
+		if reading {
+			s.successes++
+			for _, d := range request.MetricData {
+				if d.MetricName == &SUBMIT_SUCCESS {
+					s.successes -= int(*d.Value)
+				}
+			}
+		}
+		datums = datums[n:]
+	}
+	return nil
+}
+
+// sortDatums sorts datums by timestamp, keeping the order of those with the
+// same timestamp.
+func sortDatums(datums []cwtypes.MetricDatum) {
+	sort.SliceStable(datums, func(i, j int) bool {
+		if datums[i].Timestamp == nil || datums[j].Timestamp == nil {
+			return false
+		}
+		return datums[i].Timestamp.Before(*datums[j].Timestamp)
+	})
+}
+
+// clientFor returns the client that submits the metrics of the sensor with
+// the given serial number.
+func (s *CloudWatchSink) clientFor(serial string) (*cloudwatch.Client, error) {
+	t, ok := s.Targets[serial]
+	if !ok {
+		return s.Client, nil
+	}
+	if client, ok := s.clients[t]; ok {
+		return client, nil
+	}
+	cfg, err := awsConfigFor(t)
+	if err != nil {
+		return nil, err
+	}
+	if s.clients == nil {
+		s.clients = map[AWSTarget]*cloudwatch.Client{}
+	}
+	client := cloudwatch.NewFromConfig(cfg)
+	s.clients[t] = client
+	return client, nil
+}
+
+// SubmitReadFailure submits a ReadFailure metric dimensioned by category and
+// Host. The failed read has no serial number to dimension it by.
+func (s *CloudWatchSink) SubmitReadFailure(ctx context.Context, category string, t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ctx, cancel := context.WithTimeout(ctx, s.Timeout)
+	defer cancel()
+	dimensions := []cwtypes.Dimension{
+		{
+			Name:  &CATEGORY,
+			Value: &category,
+		},
+	}
+	if s.Host != "" {
+		dimensions = append(dimensions, cwtypes.Dimension{
+			Name:  &HOST,
+			Value: &s.Host,
+		})
+	}
+	if s.Environment != "" {
+		dimensions = append(dimensions, cwtypes.Dimension{
+			Name:  &ENVIRONMENT,
+			Value: &s.Environment,
+		})
+	}
+	params := &cloudwatch.PutMetricDataInput{
+		Namespace: &s.Namespace,
+		MetricData: []cwtypes.MetricDatum{
+			{
+				MetricName: &READ_FAILURE,
+				Value:      ffp(1),
+				Dimensions: dimensions,
+				Unit:       cwtypes.StandardUnitCount,
+				Timestamp:  &t,
+			},
+		},
+	}
+	if category == iotco1000.FailureCategory(iotco1000.ErrResponseTruncated) {
+		params.MetricData = append(params.MetricData, cwtypes.MetricDatum{
+			MetricName: &RESPONSE_TRUNCATED,
+			Value:      ffp(1),
+			Dimensions: dimensions[1:],
+			Unit:       cwtypes.StandardUnitCount,
+			Timestamp:  &t,
+		})
+	}
+	err := s.putMetricData(ctx, s.Client, params, false)
+	if errors.Is(err, context.DeadlineExceeded) {
+		err = fmt.Errorf("PutMetricData timed out after %s", s.Timeout)
+	}
+	return err
+}
+
+// SubmitDeviceDetached submits a DeviceDetached metric, dimensioned by Host
+// and Environment since the sensor's serial number cannot be read.
+func (s *CloudWatchSink) SubmitDeviceDetached(ctx context.Context, t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ctx, cancel := context.WithTimeout(ctx, s.Timeout)
+	defer cancel()
+	dimensions := []cwtypes.Dimension{}
+	if s.Host != "" {
+		dimensions = append(dimensions, cwtypes.Dimension{
+			Name:  &HOST,
+			Value: &s.Host,
+		})
+	}
+	if s.Environment != "" {
+		dimensions = append(dimensions, cwtypes.Dimension{
+			Name:  &ENVIRONMENT,
+			Value: &s.Environment,
+		})
+	}
+	params := &cloudwatch.PutMetricDataInput{
+		Namespace: &s.Namespace,
+		MetricData: []cwtypes.MetricDatum{
+			{
+				MetricName: &DEVICE_DETACHED,
+				Value:      ffp(1),
+				Dimensions: dimensions,
+				Unit:       cwtypes.StandardUnitCount,
+				Timestamp:  &t,
+			},
+		},
+	}
+	err := s.putMetricData(ctx, s.Client, params, false)
+	if errors.Is(err, context.DeadlineExceeded) {
+		err = fmt.Errorf("PutMetricData timed out after %s", s.Timeout)
+	}
+	return err
+}
+
+// SubmitSinkResults counts, for each sink of a Fanout, whether submitting a
+// reading succeeded. The counts are submitted with the next reading, as
+// SinkErrors and SinkSuccesses metrics dimensioned by sink name and Host,
+// rather than in a request of their own.
+func (s *CloudWatchSink) SubmitSinkResults(ctx context.Context, results map[string]error, t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sinkResults == nil {
+		s.sinkResults = map[string]*sinkResultCounts{}
+	}
+	for name, err := range results {
+		counts, ok := s.sinkResults[name]
+		if !ok {
+			counts = &sinkResultCounts{}
+			s.sinkResults[name] = counts
+		}
+		if err != nil {
+			counts.errors++
+		} else {
+			counts.successes++
+		}
+	}
+	return nil
+}
+
+// sinkResultData returns the SinkErrors and SinkSuccesses datums of the
+// counted sink results, timestamped t.
+func (s *CloudWatchSink) sinkResultData(t *time.Time) []cwtypes.MetricDatum {
+	names := []string{}
+	for name := range s.sinkResults {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	data := []cwtypes.MetricDatum{}
+	for _, name := range names {
+		name := name
+		dimensions := []cwtypes.Dimension{
+			{
+				Name:  &SINK,
+				Value: &name,
+			},
+		}
+		if s.Host != "" {
+			dimensions = append(dimensions, cwtypes.Dimension{
+				Name:  &HOST,
+				Value: &s.Host,
+			})
+		}
+		if s.Environment != "" {
+			dimensions = append(dimensions, cwtypes.Dimension{
+				Name:  &ENVIRONMENT,
+				Value: &s.Environment,
+			})
+		}
+		counts := s.sinkResults[name]
+		for _, c := range []struct {
+			metricName *string
+			count      int
+		}{
+			{&SINK_SUCCESSES, counts.successes},
+			{&SINK_ERRORS, counts.errors},
+		} {
+			if c.count == 0 {
+				continue
+			}
+			data = append(data, cwtypes.MetricDatum{
+				MetricName: c.metricName,
+				Value:      ifp(c.count),
+				Dimensions: dimensions,
+				Unit:       cwtypes.StandardUnitCount,
+				Timestamp:  t,
+			})
+		}
+	}
+	return data
+}
+
+// Check verifies that AWS credentials are available and permit submitting
+// metrics to the sink's namespace, by submitting a PreflightCheck metric.
+func (s *CloudWatchSink) Check(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, s.Timeout)
+	defer cancel()
+	identity, err := s.STSClient.GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return fmt.Errorf("failed validating AWS credentials: %s", err)
+	}
+	_, err = s.Client.PutMetricData(ctx, &cloudwatch.PutMetricDataInput{
+		Namespace: &s.Namespace,
+		MetricData: []cwtypes.MetricDatum{
+			{
+				MetricName: &PREFLIGHT_CHECK,
+				Value:      ffp(1),
+				Unit:       cwtypes.StandardUnitCount,
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("%s is not permitted to submit metrics to namespace %s: %s", aws.ToString(identity.Arn), s.Namespace, err)
+	}
+	return nil
+}
+
+// Close submits the sink results and SubmitSuccess count that are yet to be
+// submitted, then closes Fallback.
+func (s *CloudWatchSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var err error
+	now := time.Now()
+	data := s.sinkResultData(&now)
+	if s.successes > 0 && s.successDimensions != nil {
+		data = append(data, cwtypes.MetricDatum{
+			MetricName: &SUBMIT_SUCCESS,
+			Value:      ifp(s.successes),
+			Dimensions: s.successDimensions,
+			Unit:       cwtypes.StandardUnitCount,
+			Timestamp:  &now,
+		})
+	}
+	if len(data) > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), s.Timeout)
+		defer cancel()
+		err = s.putMetricData(ctx, s.Client, &cloudwatch.PutMetricDataInput{
+			Namespace:  &s.Namespace,
+			MetricData: data,
+		}, false)
+		if err != nil {
+			err = fmt.Errorf("failed submitting sink results and submission successes: %s", err)
+		}
+		s.sinkResults = nil
+		s.successes = 0
+	}
+	if s.Fallback != nil {
+		if ferr := s.Fallback.Close(); ferr != nil {
+			return ferr
+		}
+	}
+	return err
+}
+
+func (s *CloudWatchSink) metricDataInput(aq *Reading) *cloudwatch.PutMetricDataInput {
+	sensorWarmedUp := aq.SensorWarmedUp
+	ns := s.Namespace
+	var warmedUp float64
+	var params *cloudwatch.PutMetricDataInput
+	storageResolution := int32(1)
+	dimensions := []cwtypes.Dimension{
+		{
+			Name:  &SENSOR_ID,
+			Value: &aq.SensorSerialNumber,
+		},
+	}
+	if aq.Location != "" {
+		dimensions = append(dimensions, cwtypes.Dimension{
+			Name:  &LOCATION,
+			Value: &aq.Location,
+		})
+	}
+	if s.Host != "" {
+		dimensions = append(dimensions, cwtypes.Dimension{
+			Name:  &HOST,
+			Value: &s.Host,
+		})
+	}
+	if s.Environment != "" {
+		dimensions = append(dimensions, cwtypes.Dimension{
+			Name:  &ENVIRONMENT,
+			Value: &s.Environment,
+		})
+	}
+	if sensorWarmedUp || (s.WarmupSubmitRaw && !aq.Placeholder) {
+		if sensorWarmedUp {
+			warmedUp = 1.0
+		}
+		coValue := func(m *iotco1000.AirQualityMeasurement) float64 {
+			co := float64(m.COConcentrationPPB)
+			if s.CONegativePolicy != CONegativePass {
+				co = math.Max(co, 0)
+			}
+			if s.COUnit == COUnitPPM {
+				co /= 1000
+			}
+			return co
+		}
+		co := coValue(aq.AirQualityMeasurement)
+		coMetricName := &CO_CONCENTRATION_PPB
+		if s.COUnit == COUnitPPM {
+			coMetricName = &CO_CONCENTRATION_PPM
+		}
+		params = &cloudwatch.PutMetricDataInput{
+			Namespace: &ns,
+			MetricData: []cwtypes.MetricDatum{
+				{
+					MetricName:        coMetricName,
+					Value:             &co,
+					Dimensions:        dimensions,
+					Unit:              cwtypes.StandardUnitNone,
+					StorageResolution: &storageResolution,
+					Timestamp:         &aq.MeasurementTime,
+				},
+				{
+					MetricName:        &TEMPERATURE_C,
+					Value:             ffp(aq.TemperatureCFloat),
+					Dimensions:        dimensions,
+					Unit:              cwtypes.StandardUnitNone,
+					StorageResolution: &storageResolution,
+					Timestamp:         &aq.MeasurementTime,
+				},
+				{
+					MetricName:        &RELATIVE_HUMIDITY,
+					Value:             ffp(aq.RelativeHumidityFloat),
+					Dimensions:        dimensions,
+					Unit:              cwtypes.StandardUnitNone,
+					StorageResolution: &storageResolution,
+					Timestamp:         &aq.MeasurementTime,
+				},
+				{
+					MetricName:        &UPTIME,
+					Value:             ffp(aq.Uptime.Seconds()),
+					Dimensions:        dimensions,
+					Unit:              cwtypes.StandardUnitSeconds,
+					StorageResolution: &storageResolution,
+					Timestamp:         &aq.MeasurementTime,
+				},
+				{
+					MetricName:        &SENSOR_WARMED_UP,
+					Value:             &warmedUp,
+					Dimensions:        dimensions,
+					Unit:              cwtypes.StandardUnitNone,
+					StorageResolution: &storageResolution,
+					Timestamp:         &aq.MeasurementTime,
+				},
+			},
+		}
+		// CloudWatch aggregates statistic sets correctly, where an average
+		// of averages would not be.
+		if len(aq.Samples) > 1 {
+			for i, value := range []func(*iotco1000.AirQualityMeasurement) float64{
+				coValue,
+				func(m *iotco1000.AirQualityMeasurement) float64 { return m.TemperatureCFloat },
+				func(m *iotco1000.AirQualityMeasurement) float64 { return m.RelativeHumidityFloat },
+			} {
+				params.MetricData[i].Value = nil
+				params.MetricData[i].StatisticValues = statisticSet(aq.Samples, value)
+			}
+		}
+		if s.CONegativePolicy == CONegativeDrop && aq.COConcentrationPPB < 0 {
+			params.MetricData = params.MetricData[1:]
+		}
+		if s.SubmitRawAlongside {
+			samples := aq.Samples
+			if len(samples) == 0 {
+				samples = []*iotco1000.AirQualityMeasurement{aq.AirQualityMeasurement}
+			}
+			for _, raw := range []struct {
+				name  *string
+				value func(*iotco1000.AirQualityMeasurement) float64
+			}{
+				{&CO_CONCENTRATION_PPB_RAW, func(m *iotco1000.AirQualityMeasurement) float64 { return float64(m.COConcentrationPPB) }},
+				{&TEMPERATURE_C_RAW, func(m *iotco1000.AirQualityMeasurement) float64 { return m.TemperatureCFloat }},
+				{&RELATIVE_HUMIDITY_RAW, func(m *iotco1000.AirQualityMeasurement) float64 { return m.RelativeHumidityFloat }},
+			} {
+				values := []float64{}
+				for _, m := range samples {
+					values = append(values, raw.value(m))
+				}
+				params.MetricData = append(params.MetricData, cwtypes.MetricDatum{
+					MetricName:        raw.name,
+					Values:            values,
+					Dimensions:        dimensions,
+					Unit:              cwtypes.StandardUnitNone,
+					StorageResolution: &storageResolution,
+					Timestamp:         &aq.MeasurementTime,
+				})
+			}
+		}
+		if len(aq.InvalidFields) > 0 {
+			omit := map[string]bool{}
+			for field, names := range map[string][]string{
+				iotco1000.FIELD_CO:          {*coMetricName, CO_CONCENTRATION_PPB_RAW},
+				iotco1000.FIELD_TEMPERATURE: {TEMPERATURE_C, TEMPERATURE_C_RAW},
+				iotco1000.FIELD_HUMIDITY:    {RELATIVE_HUMIDITY, RELATIVE_HUMIDITY_RAW},
+			} {
+				if !aq.FieldValid(field) {
+					for _, name := range names {
+						omit[name] = true
+					}
+				}
+			}
+			data := []cwtypes.MetricDatum{}
+			for _, d := range params.MetricData {
+				if !omit[*d.MetricName] {
+					data = append(data, d)
+				}
+			}
+			params.MetricData = data
+		}
+	} else {
+		warmedUp = 0.0
+		params = &cloudwatch.PutMetricDataInput{
+			Namespace: &ns,
+			MetricData: []cwtypes.MetricDatum{
+				{
+					MetricName:        &UPTIME,
+					Value:             ffp(aq.Uptime.Seconds()),
+					Dimensions:        dimensions,
+					Unit:              cwtypes.StandardUnitSeconds,
+					StorageResolution: &storageResolution,
+					Timestamp:         &aq.MeasurementTime,
+				},
+				{
+					MetricName:        &SENSOR_WARMED_UP,
+					Value:             &warmedUp,
+					Dimensions:        dimensions,
+					Unit:              cwtypes.StandardUnitNone,
+					StorageResolution: &storageResolution,
+					Timestamp:         &aq.MeasurementTime,
+				},
+			},
+		}
+	}
+	if s.SubmitUptimeComponents {
+		components := aq.UptimeComponents
+		for _, c := range []struct {
+			name  *string
+			value int
+		}{
+			{&UPTIME_DAYS, components.Days},
+			{&UPTIME_HOURS, components.Hours},
+			{&UPTIME_MINUTES, components.Minutes},
+			{&UPTIME_SECONDS, components.Seconds},
+		} {
+			params.MetricData = append(params.MetricData, cwtypes.MetricDatum{
+				MetricName:        c.name,
+				Value:             ifp(c.value),
+				Dimensions:        dimensions,
+				Unit:              cwtypes.StandardUnitNone,
+				StorageResolution: &storageResolution,
+				Timestamp:         &aq.MeasurementTime,
+			})
+		}
+	}
+	for _, m := range []struct {
+		name  *string
+		value *float64
+	}{
+		{&PRESSURE_HPA, aq.PressurehPa},
+		{&VOC_INDEX, aq.VOCIndex},
+	} {
+		if m.value == nil {
+			continue
+		}
+		params.MetricData = append(params.MetricData, cwtypes.MetricDatum{
+			MetricName:        m.name,
+			Value:             ffp(*m.value),
+			Dimensions:        dimensions,
+			Unit:              cwtypes.StandardUnitNone,
+			StorageResolution: &storageResolution,
+			Timestamp:         &aq.MeasurementTime,
+		})
+	}
+	if throughput, ok := aq.SerialThroughput(); ok {
+		params.MetricData = append(params.MetricData, cwtypes.MetricDatum{
+			MetricName:        &SERIAL_THROUGHPUT_BYTES_PER_SEC,
+			Value:             ffp(throughput),
+			Dimensions:        dimensions,
+			Unit:              cwtypes.StandardUnitBytesSecond,
+			StorageResolution: &storageResolution,
+			Timestamp:         &aq.MeasurementTime,
+		})
+	}
+	params.MetricData = append(params.MetricData, cwtypes.MetricDatum{
+		MetricName:        &SENSOR_UPTIME_HOURS,
+		Value:             ffp(aq.Uptime.Hours()),
+		Dimensions:        dimensions,
+		Unit:              cwtypes.StandardUnitNone,
+		StorageResolution: &storageResolution,
+		Timestamp:         &aq.MeasurementTime,
+	}, cwtypes.MetricDatum{
+		MetricName:        &RESPONSE_BYTES,
+		Value:             ifp(aq.ResponseBytes),
+		Dimensions:        dimensions,
+		Unit:              cwtypes.StandardUnitBytes,
+		StorageResolution: &storageResolution,
+		Timestamp:         &aq.MeasurementTime,
+	}, cwtypes.MetricDatum{
+		MetricName:        &DROPPED_READINGS,
+		Value:             ifp(aq.DroppedReadings),
+		Dimensions:        dimensions,
+		Unit:              cwtypes.StandardUnitCount,
+		StorageResolution: &storageResolution,
+		Timestamp:         &aq.MeasurementTime,
+	}, cwtypes.MetricDatum{
+		MetricName:        &SENSOR_ALIVE,
+		Value:             ffp(1),
+		Dimensions:        dimensions,
+		Unit:              cwtypes.StandardUnitNone,
+		StorageResolution: &storageResolution,
+		Timestamp:         &aq.MeasurementTime,
+	}, cwtypes.MetricDatum{
+		MetricName:        &CHANNEL_BACKLOG,
+		Value:             ifp(aq.ChannelBacklog),
+		Dimensions:        dimensions,
+		Unit:              cwtypes.StandardUnitCount,
+		StorageResolution: &storageResolution,
+		Timestamp:         &aq.MeasurementTime,
+	}, cwtypes.MetricDatum{
+		MetricName:        &CONSECUTIVE_SUCCESSES,
+		Value:             ifp(aq.ConsecutiveSuccesses),
+		Dimensions:        dimensions,
+		Unit:              cwtypes.StandardUnitCount,
+		StorageResolution: &storageResolution,
+		Timestamp:         &aq.MeasurementTime,
+	})
+	if s.SubmitWorkers > 0 {
+		params.MetricData = append(params.MetricData, cwtypes.MetricDatum{
+			MetricName:        &SUBMIT_WORKERS,
+			Value:             ifp(s.SubmitWorkers),
+			Dimensions:        dimensions,
+			Unit:              cwtypes.StandardUnitCount,
+			StorageResolution: &storageResolution,
+			Timestamp:         &aq.MeasurementTime,
+		})
+	}
+	if len(aq.Samples) > 1 {
+		params.MetricData = append(params.MetricData, cwtypes.MetricDatum{
+			MetricName:        &SAMPLE_COUNT,
+			Value:             ifp(len(aq.Samples)),
+			Dimensions:        dimensions,
+			Unit:              cwtypes.StandardUnitCount,
+			StorageResolution: &storageResolution,
+			Timestamp:         &aq.MeasurementTime,
+		})
+	}
+	if aq.Startup {
+		params.MetricData = append(params.MetricData, cwtypes.MetricDatum{
+			MetricName:        &STARTUP,
+			Value:             ffp(1),
+			Dimensions:        dimensions,
+			Unit:              cwtypes.StandardUnitCount,
+			StorageResolution: &storageResolution,
+			Timestamp:         &aq.MeasurementTime,
+		})
+	}
+	if aq.COStatistics != nil && aq.SensorWarmedUp {
+		params.MetricData = append(params.MetricData, cwtypes.MetricDatum{
+			MetricName:        &CO_VARIANCE,
+			Value:             ffp(aq.COStatistics.Variance),
+			Dimensions:        dimensions,
+			Unit:              cwtypes.StandardUnitNone,
+			StorageResolution: &storageResolution,
+			Timestamp:         &aq.MeasurementTime,
+		}, cwtypes.MetricDatum{
+			MetricName:        &CO_Z_SCORE,
+			Value:             ffp(aq.COStatistics.ZScore),
+			Dimensions:        dimensions,
+			Unit:              cwtypes.StandardUnitNone,
+			StorageResolution: &storageResolution,
+			Timestamp:         &aq.MeasurementTime,
+		})
+	}
+	if aq.SensorWarmedUp {
+		for _, p := range aq.COPercentiles {
+			name := CO_CONCENTRATION_PPB + "p" + strconv.FormatFloat(p.Percentile, 'f', -1, 64)
+			params.MetricData = append(params.MetricData, cwtypes.MetricDatum{
+				MetricName:        &name,
+				Value:             ffp(p.Value),
+				Dimensions:        dimensions,
+				Unit:              cwtypes.StandardUnitNone,
+				StorageResolution: &storageResolution,
+				Timestamp:         &aq.MeasurementTime,
+			})
+		}
+	}
+	staticNames := []string{}
+	for name := range s.StaticMetrics {
+		staticNames = append(staticNames, name)
+	}
+	sort.Strings(staticNames)
+	for _, name := range staticNames {
+		name := name
+		params.MetricData = append(params.MetricData, cwtypes.MetricDatum{
+			MetricName:        &name,
+			Value:             ffp(s.StaticMetrics[name]),
+			Dimensions:        dimensions,
+			Unit:              cwtypes.StandardUnitNone,
+			StorageResolution: &storageResolution,
+			Timestamp:         &aq.MeasurementTime,
+		})
+	}
+	for _, field := range aq.InvalidFields {
+		field := field
+		params.MetricData = append(params.MetricData, cwtypes.MetricDatum{
+			MetricName: &FIELD_PARSE_ERROR,
+			Value:      ffp(1),
+			Dimensions: append([]cwtypes.Dimension{
+				{
+					Name:  &FIELD,
+					Value: &field,
+				},
+			}, dimensions...),
+			Unit:              cwtypes.StandardUnitCount,
+			StorageResolution: &storageResolution,
+			Timestamp:         &aq.MeasurementTime,
+		})
+	}
+	if !aq.LastCalibration.IsZero() {
+		params.MetricData = append(params.MetricData, cwtypes.MetricDatum{
+			MetricName:        &SECONDS_SINCE_CALIBRATION,
+			Value:             ffp(aq.MeasurementTime.Sub(aq.LastCalibration).Seconds()),
+			Dimensions:        dimensions,
+			Unit:              cwtypes.StandardUnitSeconds,
+			StorageResolution: &storageResolution,
+			Timestamp:         &aq.MeasurementTime,
+		})
+	}
+	if aq.PollOverrun {
+		params.MetricData = append(params.MetricData, cwtypes.MetricDatum{
+			MetricName:        &POLL_OVERRUN,
+			Value:             ffp(1),
+			Dimensions:        dimensions,
+			Unit:              cwtypes.StandardUnitCount,
+			StorageResolution: &storageResolution,
+			Timestamp:         &aq.MeasurementTime,
+		})
+	}
+	if aq.DeviceAttached {
+		params.MetricData = append(params.MetricData, cwtypes.MetricDatum{
+			MetricName:        &DEVICE_ATTACHED,
+			Value:             ffp(1),
+			Dimensions:        dimensions,
+			Unit:              cwtypes.StandardUnitCount,
+			StorageResolution: &storageResolution,
+			Timestamp:         &aq.MeasurementTime,
+		})
+	}
+	if aq.DuplicateSerial {
+		params.MetricData = append(params.MetricData, cwtypes.MetricDatum{
+			MetricName:        &DUPLICATE_SERIAL_DETECTED,
+			Value:             ffp(1),
+			Dimensions:        dimensions,
+			Unit:              cwtypes.StandardUnitCount,
+			StorageResolution: &storageResolution,
+			Timestamp:         &aq.MeasurementTime,
+		})
+	}
+	if aq.WarmUpCompleted {
+		params.MetricData = append(params.MetricData, cwtypes.MetricDatum{
+			MetricName:        &WARM_UP_COMPLETED,
+			Value:             ffp(1),
+			Dimensions:        dimensions,
+			Unit:              cwtypes.StandardUnitCount,
+			StorageResolution: &storageResolution,
+			Timestamp:         &aq.MeasurementTime,
+		})
+	}
+	if aq.Calibrated {
+		params.MetricData = append(params.MetricData, cwtypes.MetricDatum{
+			MetricName:        &CALIBRATED,
+			Value:             ffp(1),
+			Dimensions:        dimensions,
+			Unit:              cwtypes.StandardUnitCount,
+			StorageResolution: &storageResolution,
+			Timestamp:         &aq.MeasurementTime,
+		})
+	}
+	if aq.CODeltaFromReference != nil {
+		params.MetricData = append(params.MetricData, cwtypes.MetricDatum{
+			MetricName:        &CO_DELTA_FROM_REFERENCE,
+			Value:             aq.CODeltaFromReference,
+			Dimensions:        dimensions,
+			Unit:              cwtypes.StandardUnitNone,
+			StorageResolution: &storageResolution,
+			Timestamp:         &aq.MeasurementTime,
+		})
+	}
+	if aq.HealthScore != nil {
+		params.MetricData = append(params.MetricData, cwtypes.MetricDatum{
+			MetricName:        &DEVICE_HEALTH_SCORE,
+			Value:             aq.HealthScore,
+			Dimensions:        dimensions,
+			Unit:              cwtypes.StandardUnitNone,
+			StorageResolution: &storageResolution,
+			Timestamp:         &aq.MeasurementTime,
+		})
+	}
+	if aq.Stale {
+		// a held reading says nothing about whether the sensor is alive
+		data := []cwtypes.MetricDatum{}
+		for _, d := range params.MetricData {
+			if *d.MetricName != SENSOR_ALIVE {
+				data = append(data, d)
+			}
+		}
+		params.MetricData = append(data, cwtypes.MetricDatum{
+			MetricName:        &STALE,
+			Value:             ffp(1),
+			Dimensions:        dimensions,
+			Unit:              cwtypes.StandardUnitCount,
+			StorageResolution: &storageResolution,
+			Timestamp:         &aq.MeasurementTime,
+		})
+	}
+	return params
+}
+
+func statisticSet(samples []*iotco1000.AirQualityMeasurement, value func(*iotco1000.AirQualityMeasurement) float64) *cwtypes.StatisticSet {
+	min, max, sum := math.Inf(1), math.Inf(-1), 0.0
+	for _, s := range samples {
+		v := value(s)
+		min = math.Min(min, v)
+		max = math.Max(max, v)
+		sum += v
+	}
+	return &cwtypes.StatisticSet{
+		Minimum:     &min,
+		Maximum:     &max,
+		Sum:         &sum,
+		SampleCount: ifp(len(samples)),
+	}
+}
+
+func ifp(i int) *float64 {
+	f := float64(i)
+	return &f
+}
+
+func ffp(i float64) *float64 {
+	return &i
+}
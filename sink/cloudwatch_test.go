@@ -0,0 +1,362 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	cwtypes "github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+
+	"github.com/jkoelndorfer/aqgo/iotco1000"
+)
+
+func testReading(warmedUp bool) *Reading {
+	return &Reading{
+		AirQualityMeasurement: &iotco1000.AirQualityMeasurement{
+			SensorSerialNumber:    "123456789012",
+			COConcentrationPPB:    2,
+			TemperatureC:          21,
+			RelativeHumidity:      39,
+			TemperatureCFloat:     21,
+			RelativeHumidityFloat: 39,
+			Uptime:                3 * time.Hour,
+			MeasurementTime:       time.Date(2024, time.June, 1, 0, 0, 0, 0, time.UTC),
+		},
+		SensorWarmedUp: warmedUp,
+	}
+}
+
+func datumNames(data []cwtypes.MetricDatum) map[string]bool {
+	names := map[string]bool{}
+	for _, d := range data {
+		names[*d.MetricName] = true
+	}
+	return names
+}
+
+func TestMetricDataInputDimensions(t *testing.T) {
+	s := &CloudWatchSink{Namespace: "test", Host: "pi", Environment: "prod"}
+	r := testReading(true)
+	r.Location = "kitchen"
+	want := []string{SENSOR_ID + "=123456789012", LOCATION + "=kitchen", HOST + "=pi", ENVIRONMENT + "=prod"}
+	for _, d := range s.metricDataInput(r).MetricData {
+		got := []string{}
+		for _, dim := range d.Dimensions {
+			got = append(got, *dim.Name+"="+*dim.Value)
+		}
+		if len(got) != len(want) {
+			t.Errorf("%s has dimensions %v, want %v", *d.MetricName, got, want)
+			continue
+		}
+		for i := range got {
+			if got[i] != want[i] {
+				t.Errorf("%s has dimensions %v, want %v", *d.MetricName, got, want)
+				break
+			}
+		}
+	}
+}
+
+func TestMetricDataInputWarmUp(t *testing.T) {
+	s := &CloudWatchSink{Namespace: "test"}
+	names := datumNames(s.metricDataInput(testReading(false)).MetricData)
+	for _, name := range []string{CO_CONCENTRATION_PPB, TEMPERATURE_C, RELATIVE_HUMIDITY} {
+		if names[name] {
+			t.Errorf("%s submitted while warming up", name)
+		}
+	}
+	if !names[UPTIME] || !names[SENSOR_WARMED_UP] {
+		t.Errorf("uptime and warm-up status not submitted while warming up")
+	}
+
+	s.WarmupSubmitRaw = true
+	names = datumNames(s.metricDataInput(testReading(false)).MetricData)
+	if !names[CO_CONCENTRATION_PPB] {
+		t.Errorf("%s not submitted while warming up with WarmupSubmitRaw", CO_CONCENTRATION_PPB)
+	}
+
+	names = datumNames((&CloudWatchSink{Namespace: "test"}).metricDataInput(testReading(true)).MetricData)
+	for _, name := range []string{CO_CONCENTRATION_PPB, TEMPERATURE_C, RELATIVE_HUMIDITY, UPTIME, SENSOR_WARMED_UP} {
+		if !names[name] {
+			t.Errorf("%s not submitted once warmed up", name)
+		}
+	}
+}
+
+// fakeCloudWatch is an HTTP client that accepts every PutMetricData request
+// and records the form each was sent with, in the order they arrived.
+type fakeCloudWatch struct {
+	mu       sync.Mutex
+	requests []url.Values
+	// fail, if nonzero, is the number of the one request that is rejected.
+	fail int
+}
+
+func (c *fakeCloudWatch) Do(req *http.Request) (*http.Response, error) {
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.requests = append(c.requests, form)
+	n := len(c.requests)
+	c.mu.Unlock()
+	if n == c.fail {
+		return &http.Response{
+			StatusCode: http.StatusBadRequest,
+			Header:     http.Header{"Content-Type": {"text/xml"}},
+			Body:       ioutil.NopCloser(strings.NewReader(`<ErrorResponse><Error><Type>Sender</Type><Code>InvalidParameterValue</Code><Message>rejected</Message></Error><RequestId>1</RequestId></ErrorResponse>`)),
+		}, nil
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"text/xml"}},
+		Body:       ioutil.NopCloser(strings.NewReader(`<PutMetricDataResponse xmlns="http://monitoring.amazonaws.com/doc/2010-08-01/"><ResponseMetadata><RequestId>1</RequestId></ResponseMetadata></PutMetricDataResponse>`)),
+	}, nil
+}
+
+func newFakeCloudWatchSink(fake *fakeCloudWatch) *CloudWatchSink {
+	client := cloudwatch.New(cloudwatch.Options{
+		Region:     "us-east-1",
+		HTTPClient: fake,
+		Credentials: aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+			return aws.Credentials{AccessKeyID: "test", SecretAccessKey: "test"}, nil
+		}),
+	})
+	return &CloudWatchSink{Client: client, Namespace: "test", Timeout: 5 * time.Second}
+}
+
+func TestSubmitBurstTimestampsNonDecreasing(t *testing.T) {
+	fake := &fakeCloudWatch{}
+	s := newFakeCloudWatchSink(fake)
+	// split each reading over several requests, as large submissions are,
+	// so that ordering must hold across requests as well as within them
+	s.MaxDatumsPerRequest = 4
+	s.SubmitRawAlongside = true
+
+	const n = 50
+	start := time.Date(2024, time.June, 1, 0, 0, 0, 0, time.UTC)
+	readings := make(chan *Reading, n)
+	for i := 0; i < n; i++ {
+		r := testReading(true)
+		r.MeasurementTime = start.Add(time.Duration(i) * time.Second)
+		r.Samples = []*iotco1000.AirQualityMeasurement{r.AirQualityMeasurement, r.AirQualityMeasurement}
+		readings <- r
+	}
+	close(readings)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	submitted, rejected := 0, 0
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for r := range readings {
+				err := s.Submit(context.Background(), r)
+				mu.Lock()
+				switch {
+				case err == nil:
+					submitted++
+				case errors.Is(err, ErrOutOfOrder):
+					rejected++
+				default:
+					t.Error(err)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if submitted == 0 || submitted+rejected != n {
+		t.Fatalf("%d readings submitted and %d rejected, want %d in all", submitted, rejected, n)
+	}
+
+	last := map[string]time.Time{}
+	datums := 0
+	for _, form := range fake.requests {
+		for i := 1; ; i++ {
+			prefix := "MetricData.member." + strconv.Itoa(i) + "."
+			name := form.Get(prefix + "MetricName")
+			if name == "" {
+				break
+			}
+			datums++
+			ts, err := time.Parse(time.RFC3339, form.Get(prefix+"Timestamp"))
+			if err != nil {
+				t.Fatalf("%s: %s", name, err)
+			}
+			if ts.Before(last[name]) {
+				t.Errorf("%s submitted at %s after %s", name, ts, last[name])
+			}
+			last[name] = ts
+		}
+	}
+	if datums == 0 {
+		t.Fatal("no datums were submitted")
+	}
+}
+
+func TestSinkResultsSubmittedWithReading(t *testing.T) {
+	fake := &fakeCloudWatch{}
+	s := newFakeCloudWatchSink(fake)
+	results := map[string]error{"stdout": nil, "mqtt": errors.New("not connected")}
+	for i := 0; i < 2; i++ {
+		if err := s.SubmitSinkResults(context.Background(), results, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(fake.requests) != 0 {
+		t.Fatalf("%d requests made for sink results, want none until the next reading", len(fake.requests))
+	}
+	if err := s.Submit(context.Background(), testReading(true)); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.requests) != 1 {
+		t.Fatalf("%d requests made, want the sink results in the reading's request", len(fake.requests))
+	}
+	counts := map[string]string{}
+	form := fake.requests[0]
+	for i := 1; ; i++ {
+		prefix := "MetricData.member." + strconv.Itoa(i) + "."
+		name := form.Get(prefix + "MetricName")
+		if name == "" {
+			break
+		}
+		if name == SINK_SUCCESSES || name == SINK_ERRORS {
+			counts[name+"/"+form.Get(prefix+"Dimensions.member.1.Value")] = form.Get(prefix + "Value")
+		}
+	}
+	if counts[SINK_SUCCESSES+"/stdout"] != "2" || counts[SINK_ERRORS+"/mqtt"] != "2" || len(counts) != 2 {
+		t.Errorf("sink result datums %v, want 2 stdout successes and 2 mqtt errors", counts)
+	}
+
+	if err := s.SubmitSinkResults(context.Background(), results, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.requests) != 2 {
+		t.Errorf("%d requests made, want the remaining sink results submitted on Close", len(fake.requests))
+	}
+}
+
+func TestIsMetricName(t *testing.T) {
+	for name, want := range map[string]bool{
+		CO_CONCENTRATION_PPB:      true,
+		UPTIME:                    true,
+		"COConcentrationPPBp99.9": true,
+		"COConcentrationPPBp101":  false,
+		"COConcentrationPPBpx":    false,
+		"Uptim":                   false,
+		HOST:                      false,
+	} {
+		if got := IsMetricName(name); got != want {
+			t.Errorf("IsMetricName(%q) = %t, want %t", name, got, want)
+		}
+	}
+}
+
+// formValue returns the value of the first datum named name in a
+// PutMetricData form, or "" if there is none.
+func formValue(form url.Values, name string) string {
+	for i := 1; ; i++ {
+		prefix := "MetricData.member." + strconv.Itoa(i) + "."
+		switch form.Get(prefix + "MetricName") {
+		case "":
+			return ""
+		case name:
+			return form.Get(prefix + "Value")
+		}
+	}
+}
+
+func TestSubmitSuccessCountsReadingRequests(t *testing.T) {
+	fake := &fakeCloudWatch{}
+	s := newFakeCloudWatchSink(fake)
+	ctx := context.Background()
+	if err := s.Submit(ctx, testReading(true)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SubmitReadFailure(ctx, "timeout", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	r := testReading(true)
+	r.MeasurementTime = r.MeasurementTime.Add(time.Minute)
+	if err := s.Submit(ctx, r); err != nil {
+		t.Fatal(err)
+	}
+	if got := formValue(fake.requests[2], SUBMIT_SUCCESS); got != "1" {
+		t.Errorf("%s is %q with the second reading, want 1 for the first reading only", SUBMIT_SUCCESS, got)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.requests) != 4 {
+		t.Fatalf("%d requests made, want the last success submitted on Close", len(fake.requests))
+	}
+	if got := formValue(fake.requests[3], SUBMIT_SUCCESS); got != "1" {
+		t.Errorf("%s is %q on Close, want 1", SUBMIT_SUCCESS, got)
+	}
+}
+
+// submitSuccess returns the SubmitSuccess count in requests, or "" if none
+// has one.
+func submitSuccess(requests []url.Values) string {
+	for _, form := range requests {
+		if v := formValue(form, SUBMIT_SUCCESS); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func TestSubmitSuccessCountsEachSplitRequest(t *testing.T) {
+	fake := &fakeCloudWatch{}
+	s := newFakeCloudWatchSink(fake)
+	s.MaxDatumsPerRequest = 4
+	ctx := context.Background()
+	if err := s.Submit(ctx, testReading(true)); err != nil {
+		t.Fatal(err)
+	}
+	split := len(fake.requests)
+	if split < 3 {
+		t.Fatalf("reading was split into %d requests, want at least 3", split)
+	}
+	r := testReading(true)
+	r.MeasurementTime = r.MeasurementTime.Add(time.Minute)
+	if err := s.Submit(ctx, r); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := submitSuccess(fake.requests[split:]), strconv.Itoa(split); got != want {
+		t.Errorf("%s is %q after a reading split into %d requests, want %s", SUBMIT_SUCCESS, got, split, want)
+	}
+
+	// only the first of the requests splitting a reading succeeds
+	fake = &fakeCloudWatch{fail: 2}
+	s = newFakeCloudWatchSink(fake)
+	s.MaxDatumsPerRequest = 4
+	if err := s.Submit(ctx, testReading(true)); err == nil {
+		t.Fatal("Submit() succeeded with a request rejected")
+	}
+	failed := len(fake.requests)
+	if err := s.Submit(ctx, r); err != nil {
+		t.Fatal(err)
+	}
+	if got := submitSuccess(fake.requests[failed:]); got != "1" {
+		t.Errorf("%s is %q after one of the requests splitting a reading succeeded, want 1", SUBMIT_SUCCESS, got)
+	}
+}
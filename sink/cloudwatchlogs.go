@@ -0,0 +1,145 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	logstypes "github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+)
+
+const (
+	// LOG_EVENTS_MAX_BATCH_SIZE is the maximum number of events accepted by a
+	// single PutLogEvents call.
+	LOG_EVENTS_MAX_BATCH_SIZE = 10000
+
+	// LOG_EVENTS_MAX_BATCH_BYTES is the maximum size of a PutLogEvents batch.
+	// Each event counts as the length of its message plus
+	// LOG_EVENT_OVERHEAD_BYTES.
+	LOG_EVENTS_MAX_BATCH_BYTES = 1048576
+	LOG_EVENT_OVERHEAD_BYTES   = 26
+)
+
+// CloudWatchLogsSink records the raw response of each reading as an event in
+// a CloudWatch Logs stream, timestamped with the measurement time. It
+// requires the sensor to retain raw responses (iotco1000.WithKeepRaw).
+//
+// Events are buffered and sent in batches within the PutLogEvents limits; a
+// partial batch is sent once its oldest event is older than MaxBatchDelay.
+// The log stream is created if it does not exist.
+type CloudWatchLogsSink struct {
+	Client        *cloudwatchlogs.Client
+	LogGroup      string
+	LogStream     string
+	MaxBatchDelay time.Duration
+
+	pending       []logstypes.InputLogEvent
+	pendingBytes  int
+	oldest        time.Time
+	streamReady   bool
+	sequenceToken *string
+}
+
+func NewCloudWatchLogsSink(logGroup, logStream string, maxBatchDelay time.Duration) (*CloudWatchLogsSink, error) {
+	cfg, err := awsConfig()
+	if err != nil {
+		return nil, err
+	}
+	return &CloudWatchLogsSink{
+		Client:        cloudwatchlogs.NewFromConfig(cfg),
+		LogGroup:      logGroup,
+		LogStream:     logStream,
+		MaxBatchDelay: maxBatchDelay,
+	}, nil
+}
+
+func (s *CloudWatchLogsSink) Name() string {
+	return "cloudwatchlogs"
+}
+
+func (s *CloudWatchLogsSink) Submit(ctx context.Context, r *Reading) error {
+	if r.RawResponse == "" {
+		return errors.New("reading has no raw response")
+	}
+	size := len(r.RawResponse) + LOG_EVENT_OVERHEAD_BYTES
+	if s.pendingBytes+size > LOG_EVENTS_MAX_BATCH_BYTES {
+		if err := s.flush(ctx); err != nil {
+			return err
+		}
+	}
+	if len(s.pending) == 0 {
+		s.oldest = time.Now()
+	}
+	s.pending = append(s.pending, logstypes.InputLogEvent{
+		Message:   aws.String(r.RawResponse),
+		Timestamp: aws.Int64(r.MeasurementTime.UnixNano() / int64(time.Millisecond)),
+	})
+	s.pendingBytes += size
+	if len(s.pending) < LOG_EVENTS_MAX_BATCH_SIZE && time.Since(s.oldest) < s.MaxBatchDelay {
+		return nil
+	}
+	return s.flush(ctx)
+}
+
+func (s *CloudWatchLogsSink) createStream(ctx context.Context) error {
+	_, err := s.Client.CreateLogStream(ctx, &cloudwatchlogs.CreateLogStreamInput{
+		LogGroupName:  &s.LogGroup,
+		LogStreamName: &s.LogStream,
+	})
+	var exists *logstypes.ResourceAlreadyExistsException
+	if err != nil && !errors.As(err, &exists) {
+		return fmt.Errorf("failed creating log stream %s/%s: %s", s.LogGroup, s.LogStream, err)
+	}
+	s.streamReady = true
+	return nil
+}
+
+// flush sends all pending events. If the events are rejected for an invalid
+// sequence token, as happens when the stream already contains events, they
+// are resent once with the token CloudWatch Logs expects. Events that cannot
+// be sent remain pending.
+func (s *CloudWatchLogsSink) flush(ctx context.Context) error {
+	if len(s.pending) == 0 {
+		return nil
+	}
+	if !s.streamReady {
+		if err := s.createStream(ctx); err != nil {
+			return err
+		}
+	}
+	// PutLogEvents requires events in chronological order.
+	sort.SliceStable(s.pending, func(i, j int) bool {
+		return *s.pending[i].Timestamp < *s.pending[j].Timestamp
+	})
+
+	var out *cloudwatchlogs.PutLogEventsOutput
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		out, err = s.Client.PutLogEvents(ctx, &cloudwatchlogs.PutLogEventsInput{
+			LogGroupName:  &s.LogGroup,
+			LogStreamName: &s.LogStream,
+			LogEvents:     s.pending,
+			SequenceToken: s.sequenceToken,
+		})
+		var invalidToken *logstypes.InvalidSequenceTokenException
+		if !errors.As(err, &invalidToken) {
+			break
+		}
+		s.sequenceToken = invalidToken.ExpectedSequenceToken
+	}
+	if err != nil {
+		return fmt.Errorf("failed putting %d log event(s) to %s/%s: %s", len(s.pending), s.LogGroup, s.LogStream, err)
+	}
+	s.sequenceToken = out.NextSequenceToken
+	s.pending = nil
+	s.pendingBytes = 0
+	return nil
+}
+
+func (s *CloudWatchLogsSink) Close() error {
+	return s.flush(context.TODO())
+}
@@ -0,0 +1,171 @@
+package sink
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/jkoelndorfer/aqgo/iotco1000"
+)
+
+// Encoding selects the wire format used by message-oriented sinks.
+type Encoding string
+
+const (
+	EncodingJSON Encoding = "json"
+	EncodingAvro Encoding = "avro"
+)
+
+func ParseEncoding(s string) (Encoding, error) {
+	switch e := Encoding(s); e {
+	case EncodingJSON, EncodingAvro:
+		return e, nil
+	}
+	return "", fmt.Errorf("unknown encoding %q; must be one of json, avro", s)
+}
+
+func (e Encoding) Encode(r *Reading) ([]byte, error) {
+	switch e {
+	case EncodingJSON:
+		return encodeJSON(r)
+	case EncodingAvro:
+		return encodeAvro(r), nil
+	}
+	return nil, fmt.Errorf("unknown encoding %q", string(e))
+}
+
+type jsonReading struct {
+	SensorSerialNumber string      `json:"sensor_serial_number"`
+	COConcentrationPPB *int        `json:"co_concentration_ppb"`
+	TemperatureC       *float64    `json:"temperature_c"`
+	RelativeHumidity   *float64    `json:"relative_humidity"`
+	UptimeSeconds      float64     `json:"uptime_seconds"`
+	MeasurementTime    time.Time   `json:"measurement_time"`
+	SensorWarmedUp     bool        `json:"sensor_warmed_up"`
+	Location           string      `json:"location,omitempty"`
+	Environment        string      `json:"environment,omitempty"`
+	WarmUpCompleted    bool        `json:"warm_up_completed,omitempty"`
+	Provenance         *Provenance `json:"provenance,omitempty"`
+	PressurehPa        *float64    `json:"pressure_hpa,omitempty"`
+	VOCIndex           *float64    `json:"voc_index,omitempty"`
+	Stale              bool        `json:"stale,omitempty"`
+}
+
+// newJSONReading returns the JSON representation of r. Fields that could not
+// be parsed from the sensor's response are null.
+func newJSONReading(r *Reading) *jsonReading {
+	return &jsonReading{
+		SensorSerialNumber: r.SensorSerialNumber,
+		COConcentrationPPB: validField(r, iotco1000.FIELD_CO, r.COConcentrationPPB),
+		TemperatureC:       validFloatField(r, iotco1000.FIELD_TEMPERATURE, r.TemperatureCFloat),
+		RelativeHumidity:   validFloatField(r, iotco1000.FIELD_HUMIDITY, r.RelativeHumidityFloat),
+		UptimeSeconds:      r.Uptime.Seconds(),
+		MeasurementTime:    r.MeasurementTime,
+		SensorWarmedUp:     r.SensorWarmedUp,
+		Location:           r.Location,
+		Environment:        r.Environment,
+		WarmUpCompleted:    r.WarmUpCompleted,
+		Provenance:         r.Provenance,
+		PressurehPa:        r.PressurehPa,
+		VOCIndex:           r.VOCIndex,
+		Stale:              r.Stale,
+	}
+}
+
+// validField returns a pointer to v, the value of field in r, or nil if field
+// could not be parsed.
+func validField(r *Reading, field string, v int) *int {
+	if !r.FieldValid(field) {
+		return nil
+	}
+	return &v
+}
+
+func validFloatField(r *Reading, field string, v float64) *float64 {
+	if !r.FieldValid(field) {
+		return nil
+	}
+	return &v
+}
+
+func encodeJSON(r *Reading) ([]byte, error) {
+	return json.Marshal(newJSONReading(r))
+}
+
+// AvroSchema is the Avro schema of readings produced with EncodingAvro. As
+// with JSON, fields that could not be parsed from the sensor's response are
+// null.
+const AvroSchema = `{
+  "type": "record",
+  "name": "Reading",
+  "namespace": "com.github.jkoelndorfer.aqgo",
+  "fields": [
+    {"name": "sensor_serial_number", "type": "string"},
+    {"name": "co_concentration_ppb", "type": ["null", "int"]},
+    {"name": "temperature_c", "type": ["null", "double"]},
+    {"name": "relative_humidity", "type": ["null", "double"]},
+    {"name": "uptime_seconds", "type": "double"},
+    {"name": "measurement_time", "type": {"type": "long", "logicalType": "timestamp-millis"}},
+    {"name": "sensor_warmed_up", "type": "boolean"}
+  ]
+}`
+
+// encodeAvro encodes r as a binary Avro datum matching AvroSchema.
+func encodeAvro(r *Reading) []byte {
+	var b bytes.Buffer
+	avroString(&b, r.SensorSerialNumber)
+	avroOptionalLong(&b, validField(r, iotco1000.FIELD_CO, r.COConcentrationPPB))
+	avroOptionalDouble(&b, validFloatField(r, iotco1000.FIELD_TEMPERATURE, r.TemperatureCFloat))
+	avroOptionalDouble(&b, validFloatField(r, iotco1000.FIELD_HUMIDITY, r.RelativeHumidityFloat))
+	avroDouble(&b, r.Uptime.Seconds())
+	avroLong(&b, r.MeasurementTime.UnixNano()/int64(time.Millisecond))
+	avroBoolean(&b, r.SensorWarmedUp)
+	return b.Bytes()
+}
+
+func avroLong(b *bytes.Buffer, n int64) {
+	buf := make([]byte, binary.MaxVarintLen64)
+	b.Write(buf[:binary.PutVarint(buf, n)])
+}
+
+// avroOptionalLong encodes n as a ["null", "int"] or ["null", "long"] union.
+func avroOptionalLong(b *bytes.Buffer, n *int) {
+	if n == nil {
+		avroLong(b, 0)
+		return
+	}
+	avroLong(b, 1)
+	avroLong(b, int64(*n))
+}
+
+func avroString(b *bytes.Buffer, s string) {
+	avroLong(b, int64(len(s)))
+	b.WriteString(s)
+}
+
+func avroDouble(b *bytes.Buffer, f float64) {
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, math.Float64bits(f))
+	b.Write(buf)
+}
+
+// avroOptionalDouble encodes f as a ["null", "double"] union.
+func avroOptionalDouble(b *bytes.Buffer, f *float64) {
+	if f == nil {
+		avroLong(b, 0)
+		return
+	}
+	avroLong(b, 1)
+	avroDouble(b, *f)
+}
+
+func avroBoolean(b *bytes.Buffer, v bool) {
+	if v {
+		b.WriteByte(1)
+	} else {
+		b.WriteByte(0)
+	}
+}
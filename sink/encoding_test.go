@@ -0,0 +1,79 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jkoelndorfer/aqgo/iotco1000"
+)
+
+func TestEncodeInvalidFields(t *testing.T) {
+	r := testReading(true)
+	r.InvalidFields = []string{iotco1000.FIELD_TEMPERATURE}
+
+	b, err := encodeJSON(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var j map[string]interface{}
+	if err := json.Unmarshal(b, &j); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := j["temperature_c"]; !ok || v != nil {
+		t.Errorf("temperature_c is %v in %s, want null", v, b)
+	}
+	if j["co_concentration_ppb"] != 2.0 || j["relative_humidity"] != 39.0 {
+		t.Errorf("valid fields not encoded in %s", b)
+	}
+
+	var out bytes.Buffer
+	s := &StdoutSink{Writer: &out, Format: StdoutFormatText, Location: time.UTC}
+	if err := s.Submit(context.Background(), r); err != nil {
+		t.Fatal(err)
+	}
+	if line := out.String(); strings.Contains(line, "temperature_c=") || !strings.Contains(line, "co_ppb=2 relative_humidity=39") {
+		t.Errorf("text line %q, want temperature omitted", line)
+	}
+
+	out.Reset()
+	s = &StdoutSink{Writer: &out, Format: StdoutFormatCSV, Location: time.UTC}
+	if err := s.Submit(context.Background(), r); err != nil {
+		t.Fatal(err)
+	}
+	if rows := strings.Split(strings.TrimSpace(out.String()), "\n"); len(rows) != 2 || !strings.Contains(rows[1], ",2,,39,") {
+		t.Errorf("CSV rows %q, want an empty temperature", rows)
+	}
+
+	for _, sample := range promSamples(r) {
+		if sample.name == "aqgo_temperature_celsius" {
+			t.Errorf("%s reported for an invalid temperature", sample.name)
+		}
+	}
+}
+
+func TestEncodeUnroundedValues(t *testing.T) {
+	r := testReading(true)
+	r.TemperatureC, r.TemperatureCFloat = 22, 21.5
+	r.RelativeHumidity, r.RelativeHumidityFloat = 39, 38.7
+
+	b, err := encodeJSON(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `"temperature_c":21.5`) || !strings.Contains(string(b), `"relative_humidity":38.7`) {
+		t.Errorf("JSON %s, want the unrounded temperature and humidity", b)
+	}
+
+	var out bytes.Buffer
+	s := &StdoutSink{Writer: &out, Format: StdoutFormatText, Location: time.UTC}
+	if err := s.Submit(context.Background(), r); err != nil {
+		t.Fatal(err)
+	}
+	if line := out.String(); !strings.Contains(line, "temperature_c=21.5 relative_humidity=38.7") {
+		t.Errorf("text line %q, want the unrounded temperature and humidity", line)
+	}
+}
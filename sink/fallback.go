@@ -0,0 +1,186 @@
+package sink
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/jkoelndorfer/aqgo/iotco1000"
+)
+
+// FallbackFile is an append-only file of readings that could not be
+// submitted, one JSON object per line. Each write is synced to disk before
+// returning. Its readings can be read back with a FallbackReader.
+type FallbackFile struct {
+	// Compress gzips each line as a separate gzip member. The file can be
+	// read with zcat or any gzip reader that accepts concatenated members,
+	// and a record left partially written by a crash only loses that record.
+	Compress bool
+
+	mu sync.Mutex
+	f  *RotatingFile
+}
+
+type fallbackRecord struct {
+	Time    time.Time    `json:"time"`
+	Sink    string       `json:"sink"`
+	Error   string       `json:"error"`
+	Reading *jsonReading `json:"reading"`
+}
+
+func OpenFallbackFile(path string, rotation FileRotation) (*FallbackFile, error) {
+	f, err := OpenRotatingFile(path, rotation)
+	if err != nil {
+		return nil, err
+	}
+	return &FallbackFile{f: f}, nil
+}
+
+// Write records that r could not be submitted to the named sink because of
+// cause.
+func (ff *FallbackFile) Write(sink string, r *Reading, cause error) error {
+	line, err := json.Marshal(&fallbackRecord{
+		Time:    time.Now(),
+		Sink:    sink,
+		Error:   cause.Error(),
+		Reading: newJSONReading(r),
+	})
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	if ff.Compress {
+		var b bytes.Buffer
+		zw := gzip.NewWriter(&b)
+		if _, err := zw.Write(line); err != nil {
+			return err
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		line = b.Bytes()
+	}
+	ff.mu.Lock()
+	defer ff.mu.Unlock()
+	if _, err := ff.f.Write(line); err != nil {
+		return err
+	}
+	return ff.f.Sync()
+}
+
+func (ff *FallbackFile) Close() error {
+	return ff.f.Close()
+}
+
+// ErrCorruptFallbackRecord is returned by FallbackReader.Next for a record
+// that could not be read, such as one left partially written by a crash.
+var ErrCorruptFallbackRecord = errors.New("corrupt fallback record")
+
+// GZIP_MAGIC begins each gzip member.
+var GZIP_MAGIC = []byte{0x1f, 0x8b}
+
+// FallbackReader reads back the readings of a FallbackFile. Compressed and
+// uncompressed records may be mixed, as they are if Compress was changed
+// between runs.
+type FallbackReader struct {
+	r *bufio.Reader
+}
+
+func NewFallbackReader(r io.Reader) *FallbackReader {
+	return &FallbackReader{r: bufio.NewReader(r)}
+}
+
+// Next returns the next reading and the name of the sink it could not be
+// submitted to, or io.EOF at the end of the file. A record that cannot be
+// read is skipped, returning an error wrapping ErrCorruptFallbackRecord;
+// reading may continue with the next record.
+func (fr *FallbackReader) Next() (*Reading, string, error) {
+	if _, err := fr.r.Peek(1); err != nil {
+		return nil, "", err
+	}
+	line, err := fr.record()
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %s", ErrCorruptFallbackRecord, err)
+	}
+	var record fallbackRecord
+	if err := json.Unmarshal(line, &record); err != nil {
+		return nil, "", fmt.Errorf("%w: %s", ErrCorruptFallbackRecord, err)
+	}
+	if record.Reading == nil {
+		return nil, "", fmt.Errorf("%w: no reading", ErrCorruptFallbackRecord)
+	}
+	return record.Reading.reading(), record.Sink, nil
+}
+
+// record returns the next record, decompressing it if it is a gzip member.
+func (fr *FallbackReader) record() ([]byte, error) {
+	if magic, _ := fr.r.Peek(len(GZIP_MAGIC)); !bytes.Equal(magic, GZIP_MAGIC) {
+		line, err := fr.r.ReadBytes('\n')
+		if err == io.EOF {
+			err = nil
+		}
+		return line, err
+	}
+	zr, err := gzip.NewReader(fr.r)
+	if err == nil {
+		zr.Multistream(false)
+		var line []byte
+		if line, err = ioutil.ReadAll(zr); err == nil {
+			return line, nil
+		}
+	}
+	// skip the rest of the damaged member, up to the start of the next
+	for {
+		magic, perr := fr.r.Peek(len(GZIP_MAGIC))
+		if perr != nil || bytes.Equal(magic, GZIP_MAGIC) {
+			return nil, err
+		}
+		fr.r.Discard(1)
+	}
+}
+
+// reading returns the Reading that j was encoded from, as far as JSON
+// records it. Fields that were null become InvalidFields.
+func (j *jsonReading) reading() *Reading {
+	aq := &iotco1000.AirQualityMeasurement{
+		SensorSerialNumber: j.SensorSerialNumber,
+		Uptime:             time.Duration(math.Round(j.UptimeSeconds * float64(time.Second))),
+		MeasurementTime:    j.MeasurementTime,
+		PressurehPa:        j.PressurehPa,
+		VOCIndex:           j.VOCIndex,
+	}
+	if j.COConcentrationPPB != nil {
+		aq.COConcentrationPPB = *j.COConcentrationPPB
+	} else {
+		aq.InvalidFields = append(aq.InvalidFields, iotco1000.FIELD_CO)
+	}
+	if j.TemperatureC != nil {
+		aq.TemperatureCFloat = *j.TemperatureC
+		aq.TemperatureC = int(math.Round(*j.TemperatureC))
+	} else {
+		aq.InvalidFields = append(aq.InvalidFields, iotco1000.FIELD_TEMPERATURE)
+	}
+	if j.RelativeHumidity != nil {
+		aq.RelativeHumidityFloat = *j.RelativeHumidity
+		aq.RelativeHumidity = int(math.Round(*j.RelativeHumidity))
+	} else {
+		aq.InvalidFields = append(aq.InvalidFields, iotco1000.FIELD_HUMIDITY)
+	}
+	return &Reading{
+		AirQualityMeasurement: aq,
+		SensorWarmedUp:        j.SensorWarmedUp,
+		Location:              j.Location,
+		Environment:           j.Environment,
+		WarmUpCompleted:       j.WarmUpCompleted,
+		Provenance:            j.Provenance,
+		Stale:                 j.Stale,
+	}
+}
@@ -0,0 +1,80 @@
+package sink
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jkoelndorfer/aqgo/iotco1000"
+)
+
+func TestFallbackReaderSkipsTruncatedRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spool")
+	ff, err := OpenFallbackFile(path, FileRotation{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cause := errors.New("offline")
+	invalid := testReading(true)
+	invalid.TemperatureCFloat = 21.5
+	invalid.InvalidFields = []string{iotco1000.FIELD_HUMIDITY}
+	// records written before and after compression was enabled
+	if err := ff.Write("cloudwatch:test", testReading(true), cause); err != nil {
+		t.Fatal(err)
+	}
+	ff.Compress = true
+	for _, r := range []*Reading{invalid, testReading(false)} {
+		if err := ff.Write("cloudwatch:test", r, cause); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ff.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// a crash partway through writing the last record
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(path, fi.Size()-10); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	fr := NewFallbackReader(f)
+	readings := []*Reading{}
+	corrupt := 0
+	for {
+		r, sinkName, err := fr.Next()
+		if err == io.EOF {
+			break
+		}
+		if errors.Is(err, ErrCorruptFallbackRecord) {
+			corrupt++
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sinkName != "cloudwatch:test" {
+			t.Errorf("read sink %q, want cloudwatch:test", sinkName)
+		}
+		readings = append(readings, r)
+	}
+	if len(readings) != 2 || corrupt != 1 {
+		t.Fatalf("read %d readings and %d corrupt records, want 2 and the truncated record", len(readings), corrupt)
+	}
+	want := testReading(true)
+	if r := readings[0]; r.SensorSerialNumber != want.SensorSerialNumber || r.COConcentrationPPB != want.COConcentrationPPB || !r.MeasurementTime.Equal(want.MeasurementTime) || r.Uptime != want.Uptime || !r.SensorWarmedUp {
+		t.Errorf("read %+v, want %+v", r.AirQualityMeasurement, want.AirQualityMeasurement)
+	}
+	if r := readings[1]; r.TemperatureCFloat != 21.5 || r.TemperatureC != 22 || r.FieldValid(iotco1000.FIELD_HUMIDITY) || !r.FieldValid(iotco1000.FIELD_CO) {
+		t.Errorf("read temperature %g (%d), invalid fields %v; want 21.5 (22), humidity invalid", r.TemperatureCFloat, r.TemperatureC, r.InvalidFields)
+	}
+}
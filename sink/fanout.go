@@ -0,0 +1,337 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Fanout is a MetricSink that submits each reading to all of Sinks, and waits
+// for every submission to finish. A pool of Workers bounds the submissions in
+// progress at once, across every sink and every kind of submission. Each sink
+// handles one reading at a time, so sinks need not be safe for concurrent
+// use.
+type Fanout struct {
+	Sinks   []MetricSink
+	Workers int
+
+	// QueueDepth, if nonzero, gives each sink a queue of this many readings
+	// with a worker of its own, so that a slow sink does not hold up the
+	// others; the workers still share the pool. Submit then returns once a
+	// reading is queued, and submission errors are logged to Logger rather
+	// than returned. When a sink's queue is full, its oldest reading is
+	// dropped. Drops from every queue are counted in the DroppedReadings of
+	// the next reading submitted to all of the sinks, so that a sink
+	// reporting drops counts those of the others as well.
+	QueueDepth int
+	Logger     *log.Logger
+
+	mu       sync.Mutex
+	pool     chan struct{}
+	dropped  int
+	disabled map[string]bool
+	queues   map[string]chan *Reading
+	wg       sync.WaitGroup
+	ctx      context.Context
+	cancel   context.CancelFunc
+}
+
+func NewFanout(workers int, sinks ...MetricSink) *Fanout {
+	return &Fanout{
+		Sinks:   sinks,
+		Workers: workers,
+	}
+}
+
+// FanoutError holds the errors returned by the sinks of a Fanout, by sink
+// name.
+type FanoutError struct {
+	Errors map[string]error
+}
+
+func (e *FanoutError) Error() string {
+	msgs := []string{}
+	for name, err := range e.Errors {
+		msgs = append(msgs, name+": "+err.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (f *Fanout) Name() string {
+	names := []string{}
+	for _, s := range f.Sinks {
+		names = append(names, s.Name())
+	}
+	return strings.Join(names, ", ")
+}
+
+// Disable suppresses the sinks named by names, replacing any previously
+// disabled. A name matches a sink by its full name, or by the part before the
+// first ":", so that "cloudwatch" disables every CloudWatch namespace.
+func (f *Fanout) Disable(names []string) {
+	disabled := map[string]bool{}
+	for _, name := range names {
+		disabled[name] = true
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.disabled = disabled
+}
+
+// Active returns the names of the sinks that are not disabled.
+func (f *Fanout) Active() []string {
+	names := []string{}
+	for _, s := range f.active() {
+		names = append(names, s.Name())
+	}
+	return names
+}
+
+func (f *Fanout) active() []MetricSink {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	sinks := []MetricSink{}
+	for _, s := range f.Sinks {
+		name := s.Name()
+		if !f.disabled[name] && !f.disabled[strings.SplitN(name, ":", 2)[0]] {
+			sinks = append(sinks, s)
+		}
+	}
+	return sinks
+}
+
+// each calls fn on each active sink, using a worker from the pool for each
+// call, and collects the errors it returns.
+func (f *Fanout) each(fn func(s MetricSink) error) error {
+	return eachSink(f.active(), f.workers(), fn)
+}
+
+// workers returns the pool of f.Workers that bounds its submissions.
+func (f *Fanout) workers() chan struct{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.pool == nil {
+		workers := f.Workers
+		if workers < 1 {
+			workers = 1
+		}
+		f.pool = make(chan struct{}, workers)
+	}
+	return f.pool
+}
+
+func eachSink(sinks []MetricSink, sem chan struct{}, fn func(s MetricSink) error) error {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	errs := map[string]error{}
+	for _, s := range sinks {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(s MetricSink) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if err := fn(s); err != nil {
+				mu.Lock()
+				errs[s.Name()] = err
+				mu.Unlock()
+			}
+		}(s)
+	}
+	wg.Wait()
+	if len(errs) > 0 {
+		return &FanoutError{errs}
+	}
+	return nil
+}
+
+// Submit submits r to each active sink, then reports whether each submission
+// succeeded to the sinks that are SinkResultSinks. If f.QueueDepth is
+// nonzero, r is queued for each sink instead.
+func (f *Fanout) Submit(ctx context.Context, r *Reading) error {
+	sinks := f.active()
+	if f.QueueDepth > 0 {
+		f.mu.Lock()
+		if f.dropped > 0 {
+			// r belongs to the caller, so the count goes on a copy
+			c := *r
+			c.DroppedReadings += f.dropped
+			r = &c
+			f.dropped = 0
+		}
+		f.mu.Unlock()
+		for _, s := range sinks {
+			f.enqueue(s, r)
+		}
+		return nil
+	}
+	err := eachSink(sinks, f.workers(), func(s MetricSink) error {
+		return s.Submit(ctx, r)
+	})
+	results := map[string]error{}
+	for _, s := range sinks {
+		results[s.Name()] = nil
+	}
+	var fe *FanoutError
+	if errors.As(err, &fe) {
+		for name, err := range fe.Errors {
+			results[name] = err
+		}
+	}
+	resultErr := eachSink(sinks, f.workers(), func(s MetricSink) error {
+		if rs, ok := s.(SinkResultSink); ok {
+			if err := rs.SubmitSinkResults(ctx, results, r.MeasurementTime); err != nil {
+				return fmt.Errorf("failed submitting sink results: %s", err)
+			}
+		}
+		return nil
+	})
+	if errors.As(resultErr, &fe) {
+		for name, err := range fe.Errors {
+			if results[name] == nil {
+				results[name] = err
+			}
+		}
+	}
+	for name, err := range results {
+		if err == nil {
+			delete(results, name)
+		}
+	}
+	if len(results) > 0 {
+		return &FanoutError{results}
+	}
+	return nil
+}
+
+// enqueue queues r for s without blocking, starting the worker that submits
+// to s if it is not yet running. If the queue is full, its oldest reading is
+// dropped, and counted in the DroppedReadings of the next reading submitted.
+func (f *Fanout) enqueue(s MetricSink, r *Reading) {
+	f.mu.Lock()
+	if f.queues == nil {
+		f.queues = map[string]chan *Reading{}
+		f.ctx, f.cancel = context.WithCancel(context.Background())
+	}
+	q, ok := f.queues[s.Name()]
+	if !ok {
+		q = make(chan *Reading, f.QueueDepth)
+		f.queues[s.Name()] = q
+		f.wg.Add(1)
+		go f.submitQueued(s, q)
+	}
+	f.mu.Unlock()
+	for {
+		select {
+		case q <- r:
+			return
+		default:
+		}
+		select {
+		case dropped := <-q:
+			f.logger().Printf("submission queue of %s is full; dropping reading taken at %s\n", s.Name(), dropped.MeasurementTime)
+			f.mu.Lock()
+			f.dropped++
+			f.mu.Unlock()
+		default:
+		}
+	}
+}
+
+// submitQueued submits each reading queued on q to s until q is closed,
+// using a worker from the pool for each, and reports the result of each to
+// the sinks that are SinkResultSinks.
+func (f *Fanout) submitQueued(s MetricSink, q chan *Reading) {
+	defer f.wg.Done()
+	pool := f.workers()
+	for r := range q {
+		pool <- struct{}{}
+		err := s.Submit(f.ctx, r)
+		<-pool
+		if err != nil {
+			f.logger().Printf("error submitting metric data to %s: %s\n", s.Name(), err)
+		}
+		results := map[string]error{s.Name(): err}
+		for _, rs := range f.active() {
+			if rs, ok := rs.(SinkResultSink); ok {
+				pool <- struct{}{}
+				err := rs.SubmitSinkResults(f.ctx, results, r.MeasurementTime)
+				<-pool
+				if err != nil {
+					f.logger().Printf("error submitting sink results to %s: %s\n", rs.Name(), err)
+				}
+			}
+		}
+	}
+}
+
+func (f *Fanout) logger() *log.Logger {
+	if f.Logger == nil {
+		return log.Default()
+	}
+	return f.Logger
+}
+
+// Drain waits for the readings queued for each sink to be submitted. If ctx
+// is done first, the submissions in progress are cancelled and the readings
+// still queued are dropped. Nothing may be submitted to f afterward.
+func (f *Fanout) Drain(ctx context.Context) error {
+	f.mu.Lock()
+	for name, q := range f.queues {
+		close(q)
+		delete(f.queues, name)
+	}
+	cancel := f.cancel
+	f.mu.Unlock()
+	if cancel == nil {
+		return nil
+	}
+	done := make(chan struct{})
+	go func() {
+		f.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		cancel()
+		return ctx.Err()
+	}
+}
+
+// SubmitReadFailure submits the failure to each sink that is a
+// ReadFailureSink.
+func (f *Fanout) SubmitReadFailure(ctx context.Context, category string, t time.Time) error {
+	return f.each(func(s MetricSink) error {
+		if fs, ok := s.(ReadFailureSink); ok {
+			return fs.SubmitReadFailure(ctx, category, t)
+		}
+		return nil
+	})
+}
+
+// SubmitDeviceDetached submits the detachment to each sink that is a
+// DeviceDetachSink.
+func (f *Fanout) SubmitDeviceDetached(ctx context.Context, t time.Time) error {
+	return f.each(func(s MetricSink) error {
+		if ds, ok := s.(DeviceDetachSink); ok {
+			return ds.SubmitDeviceDetached(ctx, t)
+		}
+		return nil
+	})
+}
+
+// Close submits the readings still queued, then closes every sink, including
+// those that are disabled.
+func (f *Fanout) Close() error {
+	f.Drain(context.Background())
+	return eachSink(f.Sinks, f.workers(), func(s MetricSink) error {
+		return s.Close()
+	})
+}
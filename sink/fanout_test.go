@@ -0,0 +1,121 @@
+package sink
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"sync"
+	"testing"
+	"time"
+)
+
+// blockingSink records each reading submitted to it. If release is set, it
+// signals entered and then waits for release to be closed first.
+type blockingSink struct {
+	name    string
+	entered chan struct{}
+	release chan struct{}
+
+	mu       sync.Mutex
+	readings []*Reading
+}
+
+func (s *blockingSink) Name() string {
+	return s.name
+}
+
+func (s *blockingSink) Submit(ctx context.Context, r *Reading) error {
+	if s.release != nil {
+		select {
+		case s.entered <- struct{}{}:
+		default:
+		}
+		select {
+		case <-s.release:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.readings = append(s.readings, r)
+	return nil
+}
+
+func (s *blockingSink) submitted() []*Reading {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*Reading(nil), s.readings...)
+}
+
+func (s *blockingSink) Close() error {
+	return nil
+}
+
+func TestFanoutSlowSinkDoesNotHoldUpOthers(t *testing.T) {
+	slow := &blockingSink{name: "slow", entered: make(chan struct{}, 1), release: make(chan struct{})}
+	fast := &blockingSink{name: "fast"}
+	f := NewFanout(2, slow, fast)
+	f.QueueDepth = 2
+	f.Logger = log.New(ioutil.Discard, "", 0)
+	for i := 0; i < 5; i++ {
+		if i == 1 {
+			<-slow.entered
+		}
+		if err := f.Submit(context.Background(), testReading(true)); err != nil {
+			t.Fatal(err)
+		}
+		deadline := time.Now().Add(5 * time.Second)
+		for len(fast.submitted()) <= i && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		if got := len(fast.submitted()); got != i+1 {
+			t.Fatalf("fast sink got %d readings while the slow sink was blocked, want %d", got, i+1)
+		}
+	}
+
+	close(slow.release)
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// the slow sink's worker holds the first reading, so the fourth and
+	// fifth each dropped the oldest of those queued after it; the first drop
+	// is counted in the fifth reading submitted to every sink
+	got := slow.submitted()
+	if len(got) != 3 || got[2].DroppedReadings != 1 {
+		t.Fatalf("slow sink got %d readings, want 3 with the last counting 1 dropped", len(got))
+	}
+	for i, r := range fast.submitted() {
+		if want := map[bool]int{true: 1}[i == 4]; r.DroppedReadings != want {
+			t.Errorf("fast sink got reading %d counting %d dropped, want %d", i, r.DroppedReadings, want)
+		}
+	}
+}
+
+func TestFanoutWorkersBoundQueuedSubmissions(t *testing.T) {
+	slow := &blockingSink{name: "slow", entered: make(chan struct{}, 1), release: make(chan struct{})}
+	fast := &blockingSink{name: "fast"}
+	f := NewFanout(1, slow, fast)
+	f.QueueDepth = 2
+	f.Logger = log.New(ioutil.Discard, "", 0)
+	f.Disable([]string{"fast"})
+	if err := f.Submit(context.Background(), testReading(true)); err != nil {
+		t.Fatal(err)
+	}
+	<-slow.entered
+	f.Disable(nil)
+	if err := f.Submit(context.Background(), testReading(true)); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if got := len(fast.submitted()); got != 0 {
+		t.Errorf("fast sink got %d readings while the only worker was busy, want 0", got)
+	}
+	close(slow.release)
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(fast.submitted()); got != 1 {
+		t.Errorf("fast sink got %d readings after the worker was freed, want 1", got)
+	}
+}
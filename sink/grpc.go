@@ -0,0 +1,140 @@
+package sink
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/jkoelndorfer/aqgo/aqgopb"
+)
+
+// GRPC_SUBSCRIBER_BUFFER is the number of readings queued for a streaming
+// client before further readings are dropped for it.
+const GRPC_SUBSCRIBER_BUFFER = 16
+
+// GRPC_GRACEFUL_STOP_TIMEOUT is how long Close waits for in-flight RPCs to
+// finish before closing their connections.
+const GRPC_GRACEFUL_STOP_TIMEOUT = 5 * time.Second
+
+// GRPCSink serves the aqgopb.AirQuality service, streaming each submitted
+// reading to connected clients. Clients that fall behind have readings
+// dropped rather than blocking submission.
+type GRPCSink struct {
+	aqgopb.UnimplementedAirQualityServer
+
+	server   *grpc.Server
+	mu       sync.Mutex
+	nextID   int
+	watchers map[int]*grpcWatcher
+
+	// done is closed by Close to end the streams.
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+type grpcWatcher struct {
+	serial   string
+	readings chan *aqgopb.AirQualityMeasurement
+}
+
+// NewGRPCSink starts a gRPC server listening on addr.
+func NewGRPCSink(addr string) (*GRPCSink, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &GRPCSink{
+		server:   grpc.NewServer(),
+		watchers: map[int]*grpcWatcher{},
+		done:     make(chan struct{}),
+	}
+	aqgopb.RegisterAirQualityServer(s.server, s)
+	go s.server.Serve(l)
+	return s, nil
+}
+
+func (s *GRPCSink) Name() string {
+	return "grpc"
+}
+
+func (s *GRPCSink) Submit(ctx context.Context, r *Reading) error {
+	m := &aqgopb.AirQualityMeasurement{
+		SensorSerialNumber:    r.SensorSerialNumber,
+		CoConcentrationPpb:    int32(r.COConcentrationPPB),
+		TemperatureC:          int32(r.TemperatureC),
+		RelativeHumidity:      int32(r.RelativeHumidity),
+		Uptime:                durationpb.New(r.Uptime),
+		MeasurementTime:       timestamppb.New(r.MeasurementTime),
+		SensorWarmedUp:        r.SensorWarmedUp,
+		InvalidFields:         r.InvalidFields,
+		TemperatureCFloat:     r.TemperatureCFloat,
+		RelativeHumidityFloat: r.RelativeHumidityFloat,
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, w := range s.watchers {
+		if w.serial != "" && w.serial != m.SensorSerialNumber {
+			continue
+		}
+		select {
+		case w.readings <- m:
+		default:
+		}
+	}
+	return nil
+}
+
+func (s *GRPCSink) StreamReadings(req *aqgopb.StreamReadingsRequest, stream aqgopb.AirQuality_StreamReadingsServer) error {
+	w := &grpcWatcher{
+		serial:   req.SensorSerialNumber,
+		readings: make(chan *aqgopb.AirQualityMeasurement, GRPC_SUBSCRIBER_BUFFER),
+	}
+	s.mu.Lock()
+	id := s.nextID
+	s.nextID++
+	s.watchers[id] = w
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.watchers, id)
+		s.mu.Unlock()
+	}()
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-s.done:
+			return nil
+		case m := <-w.readings:
+			if err := stream.Send(m); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Close ends the streams and stops the server, waiting up to
+// GRPC_GRACEFUL_STOP_TIMEOUT for them to finish before closing their
+// connections regardless.
+func (s *GRPCSink) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		stopped := make(chan struct{})
+		go func() {
+			s.server.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-time.After(GRPC_GRACEFUL_STOP_TIMEOUT):
+			s.server.Stop()
+		}
+	})
+	return nil
+}
@@ -0,0 +1,160 @@
+package sink
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"github.com/jkoelndorfer/aqgo/iotco1000"
+)
+
+// IoTCoreSink publishes readings to AWS IoT Core over mutually-authenticated
+// MQTT and optionally updates the reported state of the device shadow.
+//
+// If ThingName is empty, the serial number of the first submitted reading is
+// used as the thing name and MQTT client ID; the connection is established
+// on the first submission. Until it succeeds the client keeps retrying in the
+// background, and submissions fail. The client reconnects automatically if
+// the connection is lost.
+type IoTCoreSink struct {
+	Endpoint     string
+	Topic        string
+	ThingName    string
+	UpdateShadow bool
+	TLSConfig    *tls.Config
+
+	client mqtt.Client
+	// connecting completes once client first connects.
+	connecting mqtt.Token
+}
+
+// IOT_CORE_PUBLISH_TIMEOUT bounds how long a publish may wait for
+// acknowledgement from the broker.
+const IOT_CORE_PUBLISH_TIMEOUT = 10 * time.Second
+
+func NewIoTCoreSink(endpoint, topic, thingName string, updateShadow bool, certFile, keyFile, caFile string) (*IoTCoreSink, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed loading IoT Core client certificate: %s", err)
+	}
+	ca, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading IoT Core CA certificate: %s", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificates found in %s", caFile)
+	}
+	return &IoTCoreSink{
+		Endpoint:     endpoint,
+		Topic:        topic,
+		ThingName:    thingName,
+		UpdateShadow: updateShadow,
+		TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			RootCAs:      pool,
+		},
+	}, nil
+}
+
+func (s *IoTCoreSink) Name() string {
+	return "iotcore"
+}
+
+// connect starts connecting as thingName, if that has not already begun,
+// and waits for the connection to be established. A client that times out
+// is kept, to go on retrying in the background, rather than replaced.
+func (s *IoTCoreSink) connect(thingName string) error {
+	if s.client == nil {
+		opts := mqtt.NewClientOptions().
+			AddBroker(fmt.Sprintf("ssl://%s:8883", s.Endpoint)).
+			SetClientID(thingName).
+			SetTLSConfig(s.TLSConfig).
+			SetAutoReconnect(true).
+			SetConnectRetry(true).
+			SetMaxReconnectInterval(time.Minute)
+		s.client = mqtt.NewClient(opts)
+		s.connecting = s.client.Connect()
+		s.ThingName = thingName
+	}
+	if !s.connecting.WaitTimeout(IOT_CORE_PUBLISH_TIMEOUT) {
+		return errors.New("timed out connecting to IoT Core; still retrying")
+	}
+	if err := s.connecting.Error(); err != nil {
+		s.client.Disconnect(0)
+		s.client = nil
+		return fmt.Errorf("failed connecting to IoT Core: %s", err)
+	}
+	return nil
+}
+
+func (s *IoTCoreSink) Submit(ctx context.Context, r *Reading) error {
+	thingName := s.ThingName
+	if thingName == "" {
+		thingName = r.SensorSerialNumber
+	}
+	if err := s.connect(thingName); err != nil {
+		return err
+	}
+
+	payload, err := encodeJSON(r)
+	if err != nil {
+		return err
+	}
+	if err := s.publish(s.Topic, payload); err != nil {
+		return err
+	}
+
+	if !s.UpdateShadow {
+		return nil
+	}
+	// fields that could not be parsed are left out, so that the shadow keeps
+	// reporting their last known values
+	reported := map[string]interface{}{
+		"sensor_warmed_up": r.SensorWarmedUp,
+		"measurement_time": r.MeasurementTime,
+	}
+	if r.FieldValid(iotco1000.FIELD_CO) {
+		reported["co_concentration_ppb"] = r.COConcentrationPPB
+	}
+	if r.FieldValid(iotco1000.FIELD_TEMPERATURE) {
+		reported["temperature_c"] = r.TemperatureCFloat
+	}
+	if r.FieldValid(iotco1000.FIELD_HUMIDITY) {
+		reported["relative_humidity"] = r.RelativeHumidityFloat
+	}
+	shadow, err := json.Marshal(map[string]interface{}{
+		"state": map[string]interface{}{
+			"reported": reported,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	return s.publish(fmt.Sprintf("$aws/things/%s/shadow/update", s.ThingName), shadow)
+}
+
+func (s *IoTCoreSink) publish(topic string, payload []byte) error {
+	token := s.client.Publish(topic, 1, false, payload)
+	if !token.WaitTimeout(IOT_CORE_PUBLISH_TIMEOUT) {
+		return fmt.Errorf("timed out publishing to %s", topic)
+	}
+	if err := token.Error(); err != nil {
+		return fmt.Errorf("failed publishing to %s: %s", topic, err)
+	}
+	return nil
+}
+
+func (s *IoTCoreSink) Close() error {
+	if s.client != nil {
+		s.client.Disconnect(250)
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/jkoelndorfer/aqgo/iotco1000"
+)
+
+// JOURNAL_SOCKET is the socket of the systemd journal's native protocol.
+const JOURNAL_SOCKET = "/run/systemd/journal/socket"
+
+// Syslog priorities, as used by the journal's PRIORITY field.
+const (
+	JOURNAL_PRIORITY_WARNING = 4
+	JOURNAL_PRIORITY_INFO    = 6
+)
+
+// JournalSink logs each reading to the systemd journal with its values as
+// structured fields, e.g. AQGO_CO_PPB, so that they can be queried with
+// journalctl. Readings taken while the sensor is warming up are logged at
+// warning priority; others at info.
+type JournalSink struct {
+	Identifier string
+
+	conn *net.UnixConn
+}
+
+func NewJournalSink(identifier string) (*JournalSink, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: JOURNAL_SOCKET, Net: "unixgram"})
+	if err != nil {
+		return nil, fmt.Errorf("failed connecting to the journal: %s", err)
+	}
+	return &JournalSink{
+		Identifier: identifier,
+		conn:       conn,
+	}, nil
+}
+
+func (s *JournalSink) Name() string {
+	return "journal"
+}
+
+func (s *JournalSink) Submit(ctx context.Context, r *Reading) error {
+	priority := JOURNAL_PRIORITY_INFO
+	if !r.SensorWarmedUp {
+		priority = JOURNAL_PRIORITY_WARNING
+	}
+	message := fmt.Sprintf(
+		"sensor=%s%s uptime=%s warmed_up=%t",
+		r.SensorSerialNumber,
+		textFields(r),
+		r.Uptime,
+		r.SensorWarmedUp,
+	)
+	var entry bytes.Buffer
+	writeJournalField(&entry, "PRIORITY", strconv.Itoa(priority))
+	writeJournalField(&entry, "SYSLOG_IDENTIFIER", s.Identifier)
+	writeJournalField(&entry, "MESSAGE", message)
+	writeJournalField(&entry, "AQGO_SENSOR_SERIAL_NUMBER", r.SensorSerialNumber)
+	if r.FieldValid(iotco1000.FIELD_CO) {
+		writeJournalField(&entry, "AQGO_CO_PPB", strconv.Itoa(r.COConcentrationPPB))
+	}
+	if r.FieldValid(iotco1000.FIELD_TEMPERATURE) {
+		writeJournalField(&entry, "AQGO_TEMPERATURE_C", strconv.FormatFloat(r.TemperatureCFloat, 'f', -1, 64))
+	}
+	if r.FieldValid(iotco1000.FIELD_HUMIDITY) {
+		writeJournalField(&entry, "AQGO_RELATIVE_HUMIDITY", strconv.FormatFloat(r.RelativeHumidityFloat, 'f', -1, 64))
+	}
+	writeJournalField(&entry, "AQGO_UPTIME_SECONDS", strconv.FormatFloat(r.Uptime.Seconds(), 'f', -1, 64))
+	writeJournalField(&entry, "AQGO_SENSOR_WARMED_UP", strconv.FormatBool(r.SensorWarmedUp))
+	writeJournalField(&entry, "AQGO_MEASUREMENT_TIME", strconv.FormatInt(r.MeasurementTime.UnixNano()/1000, 10))
+	if r.Location != "" {
+		writeJournalField(&entry, "AQGO_LOCATION", r.Location)
+	}
+	if r.Environment != "" {
+		writeJournalField(&entry, "AQGO_ENVIRONMENT", r.Environment)
+	}
+	_, err := s.conn.Write(entry.Bytes())
+	return err
+}
+
+// writeJournalField writes a field in the journal's native protocol. Values
+// containing a newline are written with an explicit length.
+func writeJournalField(w *bytes.Buffer, name, value string) {
+	if !strings.Contains(value, "\n") {
+		fmt.Fprintf(w, "%s=%s\n", name, value)
+		return
+	}
+	w.WriteString(name)
+	w.WriteByte('\n')
+	binary.Write(w, binary.LittleEndian, uint64(len(value)))
+	w.WriteString(value)
+	w.WriteByte('\n')
+}
+
+func (s *JournalSink) Close() error {
+	return s.conn.Close()
+}
@@ -0,0 +1,50 @@
+package sink
+
+import (
+	"context"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// KafkaSink publishes each reading to a Kafka topic, keyed by sensor serial
+// number. Writes wait for acknowledgement from all in-sync replicas and are
+// retried, giving at-least-once delivery.
+type KafkaSink struct {
+	Writer   *kafka.Writer
+	Encoding Encoding
+}
+
+func NewKafkaSink(brokers []string, topic string, encoding Encoding) *KafkaSink {
+	return &KafkaSink{
+		Writer: &kafka.Writer{
+			Addr:         kafka.TCP(brokers...),
+			Topic:        topic,
+			Balancer:     &kafka.Hash{},
+			RequiredAcks: kafka.RequireAll,
+			MaxAttempts:  10,
+			BatchTimeout: 10 * time.Millisecond,
+		},
+		Encoding: encoding,
+	}
+}
+
+func (s *KafkaSink) Name() string {
+	return "kafka"
+}
+
+func (s *KafkaSink) Submit(ctx context.Context, r *Reading) error {
+	value, err := s.Encoding.Encode(r)
+	if err != nil {
+		return err
+	}
+	return s.Writer.WriteMessages(ctx, kafka.Message{
+		Key:   []byte(r.SensorSerialNumber),
+		Value: value,
+		Time:  r.MeasurementTime,
+	})
+}
+
+func (s *KafkaSink) Close() error {
+	return s.Writer.Close()
+}
@@ -0,0 +1,162 @@
+package sink
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// MQTTSink publishes readings, JSON encoded, to Topic on an MQTT broker. The
+// connection is established on the first submission; until it succeeds the
+// client keeps retrying in the background, and submissions fail. The client
+// reconnects automatically if the connection is lost.
+type MQTTSink struct {
+	Broker   string
+	Topic    string
+	ClientID string
+	Username string
+	Password string
+
+	// TLSConfig, if set, is used for ssl:// and tls:// brokers.
+	TLSConfig *tls.Config
+
+	client mqtt.Client
+	// connecting completes once client first connects.
+	connecting mqtt.Token
+}
+
+// MQTT_PUBLISH_TIMEOUT bounds how long connecting or publishing may wait for
+// acknowledgement from the broker.
+const MQTT_PUBLISH_TIMEOUT = 10 * time.Second
+
+// MQTTTLSOptions configures the TLS client of an MQTTSink. CAFile, if set,
+// replaces the system roots used to verify the broker. CertFile and KeyFile,
+// if set, authenticate the client to the broker. InsecureSkipVerify disables
+// verification of the broker's certificate, for self-signed development
+// brokers.
+type MQTTTLSOptions struct {
+	CAFile             string
+	CertFile           string
+	KeyFile            string
+	InsecureSkipVerify bool
+}
+
+func (o MQTTTLSOptions) enabled() bool {
+	return o.CAFile != "" || o.CertFile != "" || o.KeyFile != "" || o.InsecureSkipVerify
+}
+
+func (o MQTTTLSOptions) config() (*tls.Config, error) {
+	if (o.CertFile == "") != (o.KeyFile == "") {
+		return nil, errors.New("an MQTT client certificate and key must be given together")
+	}
+	cfg := &tls.Config{
+		InsecureSkipVerify: o.InsecureSkipVerify,
+	}
+	if o.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed loading MQTT client certificate: %s", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	if o.CAFile != "" {
+		ca, err := ioutil.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed reading MQTT CA certificate: %s", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates found in %s", o.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+	return cfg, nil
+}
+
+func NewMQTTSink(broker, topic, clientID, username, password string, tlsOpts MQTTTLSOptions) (*MQTTSink, error) {
+	s := &MQTTSink{
+		Broker:   broker,
+		Topic:    topic,
+		ClientID: clientID,
+		Username: username,
+		Password: password,
+	}
+	if tlsOpts.enabled() {
+		cfg, err := tlsOpts.config()
+		if err != nil {
+			return nil, err
+		}
+		s.TLSConfig = cfg
+	}
+	return s, nil
+}
+
+func (s *MQTTSink) Name() string {
+	return "mqtt"
+}
+
+// connect starts connecting to the broker, if that has not already begun,
+// and waits for the connection to be established. A client that times out
+// is kept, to go on retrying in the background, rather than replaced.
+func (s *MQTTSink) connect() error {
+	if s.client == nil {
+		opts := mqtt.NewClientOptions().
+			AddBroker(s.Broker).
+			SetClientID(s.ClientID).
+			SetUsername(s.Username).
+			SetPassword(s.Password).
+			SetAutoReconnect(true).
+			SetConnectRetry(true).
+			SetMaxReconnectInterval(time.Minute)
+		if s.TLSConfig != nil {
+			opts.SetTLSConfig(s.TLSConfig)
+		}
+		s.client = mqtt.NewClient(opts)
+		s.connecting = s.client.Connect()
+	}
+	if !s.connecting.WaitTimeout(MQTT_PUBLISH_TIMEOUT) {
+		return fmt.Errorf("timed out connecting to MQTT broker %s; still retrying", s.Broker)
+	}
+	if err := s.connecting.Error(); err != nil {
+		s.client.Disconnect(0)
+		s.client = nil
+		return fmt.Errorf("failed connecting to MQTT broker %s: %s", s.Broker, err)
+	}
+	return nil
+}
+
+// Check connects to the broker, if not already connected.
+func (s *MQTTSink) Check(ctx context.Context) error {
+	return s.connect()
+}
+
+func (s *MQTTSink) Submit(ctx context.Context, r *Reading) error {
+	if err := s.connect(); err != nil {
+		return err
+	}
+	payload, err := encodeJSON(r)
+	if err != nil {
+		return err
+	}
+	token := s.client.Publish(s.Topic, 1, false, payload)
+	if !token.WaitTimeout(MQTT_PUBLISH_TIMEOUT) {
+		return fmt.Errorf("timed out publishing to %s", s.Topic)
+	}
+	if err := token.Error(); err != nil {
+		return fmt.Errorf("failed publishing to %s: %s", s.Topic, err)
+	}
+	return nil
+}
+
+func (s *MQTTSink) Close() error {
+	if s.client != nil {
+		s.client.Disconnect(250)
+	}
+	return nil
+}
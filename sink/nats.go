@@ -0,0 +1,100 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// NATS_FLUSH_TIMEOUT bounds how long a publish may wait for the server to
+// acknowledge it.
+const NATS_FLUSH_TIMEOUT = 10 * time.Second
+
+// NATSSink publishes readings, JSON encoded, to a NATS subject. Subject may
+// contain {serial}, which is replaced with the sensor serial number. The
+// client reconnects indefinitely if the connection is lost, logging each
+// disconnection and reconnection to Logger.
+type NATSSink struct {
+	URL     string
+	Subject string
+	Logger  *log.Logger
+
+	conn *nats.Conn
+}
+
+// NewNATSSink connects to the NATS server at url, authenticating with token
+// or with the credentials file credsFile if either is set. The connection is
+// retried in the background if the server is not reachable.
+func NewNATSSink(url, subject, token, credsFile string, logger *log.Logger) (*NATSSink, error) {
+	s := &NATSSink{
+		URL:     url,
+		Subject: subject,
+		Logger:  logger,
+	}
+	opts := []nats.Option{
+		nats.Name("aqgo"),
+		nats.RetryOnFailedConnect(true),
+		nats.MaxReconnects(-1),
+		nats.ReconnectWait(2 * time.Second),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			if err != nil {
+				logger.Printf("disconnected from NATS server: %s\n", err)
+			}
+		}),
+		nats.ReconnectHandler(func(nc *nats.Conn) {
+			logger.Printf("reconnected to NATS server %s\n", nc.ConnectedUrl())
+		}),
+		nats.ErrorHandler(func(_ *nats.Conn, _ *nats.Subscription, err error) {
+			logger.Printf("NATS error: %s\n", err)
+		}),
+	}
+	if token != "" {
+		opts = append(opts, nats.Token(token))
+	}
+	if credsFile != "" {
+		opts = append(opts, nats.UserCredentials(credsFile))
+	}
+	conn, err := nats.Connect(url, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed connecting to NATS server %s: %s", url, err)
+	}
+	s.conn = conn
+	return s, nil
+}
+
+func (s *NATSSink) Name() string {
+	return "nats"
+}
+
+func (s *NATSSink) subject(r *Reading) string {
+	return strings.ReplaceAll(s.Subject, "{serial}", r.SensorSerialNumber)
+}
+
+func (s *NATSSink) Submit(ctx context.Context, r *Reading) error {
+	payload, err := encodeJSON(r)
+	if err != nil {
+		return err
+	}
+	subject := s.subject(r)
+	if err := s.conn.Publish(subject, payload); err != nil {
+		return fmt.Errorf("failed publishing to %s: %s", subject, err)
+	}
+	if err := s.conn.FlushTimeout(NATS_FLUSH_TIMEOUT); err != nil {
+		return fmt.Errorf("failed publishing to %s: %s", subject, err)
+	}
+	return nil
+}
+
+// Check verifies that the server is reachable.
+func (s *NATSSink) Check(ctx context.Context) error {
+	return s.conn.FlushTimeout(NATS_FLUSH_TIMEOUT)
+}
+
+func (s *NATSSink) Close() error {
+	s.conn.Close()
+	return nil
+}
@@ -0,0 +1,59 @@
+package sink
+
+import (
+	"bytes"
+
+	"github.com/jkoelndorfer/aqgo/iotco1000"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// parquetReading is the schema of readings archived as Parquet. Its columns
+// match STDOUT_CSV_HEADER; fields that could not be parsed from the sensor's
+// response are null.
+type parquetReading struct {
+	MeasurementTime    int64    `parquet:"name=measurement_time, type=INT64, convertedtype=TIMESTAMP_MILLIS"`
+	SensorSerialNumber string   `parquet:"name=sensor_serial_number, type=BYTE_ARRAY, convertedtype=UTF8"`
+	COConcentrationPPB *int64   `parquet:"name=co_concentration_ppb, type=INT64, repetitiontype=OPTIONAL"`
+	TemperatureC       *float64 `parquet:"name=temperature_c, type=DOUBLE, repetitiontype=OPTIONAL"`
+	RelativeHumidity   *float64 `parquet:"name=relative_humidity, type=DOUBLE, repetitiontype=OPTIONAL"`
+	UptimeSeconds      float64  `parquet:"name=uptime_seconds, type=DOUBLE"`
+	SensorWarmedUp     bool     `parquet:"name=sensor_warmed_up, type=BOOLEAN"`
+	Location           string   `parquet:"name=location, type=BYTE_ARRAY, convertedtype=UTF8"`
+}
+
+// encodeParquet encodes readings as a Snappy-compressed Parquet file.
+func encodeParquet(readings []*Reading) ([]byte, error) {
+	var b bytes.Buffer
+	w, err := writer.NewParquetWriterFromWriter(&b, new(parquetReading), 1)
+	if err != nil {
+		return nil, err
+	}
+	w.CompressionType = parquet.CompressionCodec_SNAPPY
+	for _, r := range readings {
+		pr := parquetReading{
+			MeasurementTime:    r.MeasurementTime.UnixNano() / 1e6,
+			SensorSerialNumber: r.SensorSerialNumber,
+			UptimeSeconds:      r.Uptime.Seconds(),
+			SensorWarmedUp:     r.SensorWarmedUp,
+			Location:           r.Location,
+		}
+		if r.FieldValid(iotco1000.FIELD_CO) {
+			co := int64(r.COConcentrationPPB)
+			pr.COConcentrationPPB = &co
+		}
+		if r.FieldValid(iotco1000.FIELD_TEMPERATURE) {
+			pr.TemperatureC = &r.TemperatureCFloat
+		}
+		if r.FieldValid(iotco1000.FIELD_HUMIDITY) {
+			pr.RelativeHumidity = &r.RelativeHumidityFloat
+		}
+		if err := w.Write(pr); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.WriteStop(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
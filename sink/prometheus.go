@@ -0,0 +1,54 @@
+package sink
+
+import "github.com/jkoelndorfer/aqgo/iotco1000"
+
+// promSample is a single gauge value of a reading, named following
+// Prometheus conventions.
+type promSample struct {
+	name  string
+	help  string
+	value float64
+}
+
+// promSamples returns the gauges describing r. As with CloudWatch, only
+// uptime and warm-up status are reported until the sensor has warmed up, and
+// fields that could not be parsed from the sensor's response are omitted.
+func promSamples(r *Reading) []promSample {
+	warmedUp := 0.0
+	if r.SensorWarmedUp {
+		warmedUp = 1.0
+	}
+	samples := []promSample{
+		{"aqgo_uptime_seconds", "Time since the sensor powered on.", r.Uptime.Seconds()},
+		{"aqgo_sensor_warmed_up", "Whether the sensor has been active for the warm up duration.", warmedUp},
+		{"aqgo_channel_backlog", "Readings waiting to be submitted when this reading was queued.", float64(r.ChannelBacklog)},
+		{"aqgo_consecutive_successes", "Readings in a row taken without error.", float64(r.ConsecutiveSuccesses)},
+	}
+	if !r.SensorWarmedUp {
+		return samples
+	}
+	coPPB := float64(r.COConcentrationPPB)
+	if coPPB < 0 {
+		coPPB = 0
+	}
+	if r.COStatistics != nil {
+		samples = append(samples,
+			promSample{"aqgo_co_variance", "Moving variance of the carbon monoxide concentration in parts per billion squared.", r.COStatistics.Variance},
+			promSample{"aqgo_co_z_score", "Standard deviations by which the carbon monoxide concentration differs from its moving mean.", r.COStatistics.ZScore},
+		)
+	}
+	measured := []promSample{}
+	for _, m := range []struct {
+		field  string
+		sample promSample
+	}{
+		{iotco1000.FIELD_CO, promSample{"aqgo_co_concentration_ppb", "Carbon monoxide concentration in parts per billion.", coPPB}},
+		{iotco1000.FIELD_TEMPERATURE, promSample{"aqgo_temperature_celsius", "Temperature in degrees Celsius.", r.TemperatureCFloat}},
+		{iotco1000.FIELD_HUMIDITY, promSample{"aqgo_relative_humidity_percent", "Relative humidity.", r.RelativeHumidityFloat}},
+	} {
+		if r.FieldValid(m.field) {
+			measured = append(measured, m.sample)
+		}
+	}
+	return append(measured, samples...)
+}
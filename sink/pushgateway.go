@@ -0,0 +1,70 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// PushgatewaySink pushes the gauges of each reading to a Prometheus
+// Pushgateway, grouped by job and sensor serial number. Each push replaces
+// the previous gauges of the group.
+type PushgatewaySink struct {
+	URL    string
+	Job    string
+	Client *http.Client
+}
+
+func NewPushgatewaySink(url, job string) *PushgatewaySink {
+	return &PushgatewaySink{
+		URL:    strings.TrimRight(url, "/"),
+		Job:    job,
+		Client: http.DefaultClient,
+	}
+}
+
+func (s *PushgatewaySink) Name() string {
+	return "pushgateway"
+}
+
+func (s *PushgatewaySink) Submit(ctx context.Context, r *Reading) error {
+	var body bytes.Buffer
+	for _, sample := range promSamples(r) {
+		fmt.Fprintf(&body, "# HELP %s %s\n", sample.name, sample.help)
+		fmt.Fprintf(&body, "# TYPE %s gauge\n", sample.name)
+		fmt.Fprintf(&body, "%s %s\n", sample.name, strconv.FormatFloat(sample.value, 'g', -1, 64))
+	}
+
+	u := fmt.Sprintf("%s/metrics/job/%s/sensor_id/%s", s.URL, url.PathEscape(s.Job), url.PathEscape(r.SensorSerialNumber))
+	if r.Location != "" {
+		u += "/location/" + url.PathEscape(r.Location)
+	}
+	if r.Environment != "" {
+		u += "/environment/" + url.PathEscape(r.Environment)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("pushgateway returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+func (s *PushgatewaySink) Close() error {
+	return nil
+}
@@ -0,0 +1,116 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// RemoteWriteSink sends the gauges of each reading to a Prometheus
+// remote-write endpoint, labelled with the sensor serial number and
+// timestamped with the measurement time.
+type RemoteWriteSink struct {
+	URL         string
+	Username    string
+	Password    string
+	BearerToken string
+	Client      *http.Client
+}
+
+func NewRemoteWriteSink(url, username, password, bearerToken string) *RemoteWriteSink {
+	return &RemoteWriteSink{
+		URL:         url,
+		Username:    username,
+		Password:    password,
+		BearerToken: bearerToken,
+		Client:      &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (s *RemoteWriteSink) Name() string {
+	return "remote-write"
+}
+
+func (s *RemoteWriteSink) Submit(ctx context.Context, r *Reading) error {
+	body := snappy.Encode(nil, encodeWriteRequest(r))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("User-Agent", "aqgo")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	if s.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+s.BearerToken)
+	} else if s.Username != "" {
+		req.SetBasicAuth(s.Username, s.Password)
+	}
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("remote write endpoint returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+func (s *RemoteWriteSink) Close() error {
+	return nil
+}
+
+// encodeWriteRequest encodes the gauges of r as a prometheus.WriteRequest
+// protobuf message:
+//
+//	message WriteRequest { repeated TimeSeries timeseries = 1; }
+//	message TimeSeries { repeated Label labels = 1; repeated Sample samples = 2; }
+//	message Label { string name = 1; string value = 2; }
+//	message Sample { double value = 1; int64 timestamp = 2; }
+func encodeWriteRequest(r *Reading) []byte {
+	timestamp := r.MeasurementTime.UnixNano() / int64(time.Millisecond)
+	var req []byte
+	for _, sample := range promSamples(r) {
+		var ts []byte
+		// Labels must be sorted by name.
+		labels := [][2]string{{"__name__", sample.name}}
+		if r.Environment != "" {
+			labels = append(labels, [2]string{"environment", r.Environment})
+		}
+		if r.Location != "" {
+			labels = append(labels, [2]string{"location", r.Location})
+		}
+		labels = append(labels, [2]string{"sensor_id", r.SensorSerialNumber})
+		for _, l := range labels {
+			var label []byte
+			label = protowire.AppendTag(label, 1, protowire.BytesType)
+			label = protowire.AppendString(label, l[0])
+			label = protowire.AppendTag(label, 2, protowire.BytesType)
+			label = protowire.AppendString(label, l[1])
+			ts = protowire.AppendTag(ts, 1, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, label)
+		}
+		var s []byte
+		s = protowire.AppendTag(s, 1, protowire.Fixed64Type)
+		s = protowire.AppendFixed64(s, math.Float64bits(sample.value))
+		s = protowire.AppendTag(s, 2, protowire.VarintType)
+		s = protowire.AppendVarint(s, uint64(timestamp))
+		ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+		ts = protowire.AppendBytes(ts, s)
+
+		req = protowire.AppendTag(req, 1, protowire.BytesType)
+		req = protowire.AppendBytes(req, ts)
+	}
+	return req
+}
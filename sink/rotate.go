@@ -0,0 +1,175 @@
+package sink
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// FileRotation configures when a RotatingFile is rotated. A file is rotated
+// once writing to it would exceed MaxSize bytes, or once it has been open for
+// Interval; zero disables either. Keep rotated files are retained, named
+// path.1 (the newest) through path.Keep, and compressed if Gzip is set.
+type FileRotation struct {
+	MaxSize  int64
+	Interval time.Duration
+	Keep     int
+	Gzip     bool
+}
+
+// RotatingFile is an append-only file that is rotated as configured by its
+// FileRotation.
+type RotatingFile struct {
+	Path     string
+	Rotation FileRotation
+
+	// Header, if set, is written at the start of each new file.
+	Header []byte
+
+	mu     sync.Mutex
+	f      *os.File
+	size   int64
+	opened time.Time
+}
+
+func OpenRotatingFile(path string, rotation FileRotation) (*RotatingFile, error) {
+	rf := &RotatingFile{
+		Path:     path,
+		Rotation: rotation,
+	}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *RotatingFile) open() error {
+	f, err := os.OpenFile(rf.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	rf.f = f
+	rf.size = fi.Size()
+	rf.opened = time.Now()
+	return nil
+}
+
+func (rf *RotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	r := rf.Rotation
+	if rf.size > int64(len(rf.Header)) && ((r.MaxSize > 0 && rf.size+int64(len(p)) > r.MaxSize) || (r.Interval > 0 && time.Since(rf.opened) >= r.Interval)) {
+		if err := rf.rotate(); err != nil {
+			return 0, fmt.Errorf("failed rotating %s: %s", rf.Path, err)
+		}
+		if err := rf.writeHeader(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := rf.f.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+// writeHeader writes Header if nothing has been written to the current file.
+func (rf *RotatingFile) writeHeader() error {
+	if rf.size > 0 || len(rf.Header) == 0 {
+		return nil
+	}
+	n, err := rf.f.Write(rf.Header)
+	rf.size += int64(n)
+	return err
+}
+
+func (rf *RotatingFile) Sync() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	return rf.f.Sync()
+}
+
+func (rf *RotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	return rf.f.Close()
+}
+
+// rotate closes the current file, moves it aside and opens a new one. If
+// closing or moving it fails, Path is reopened as it is, so that writes may
+// continue and rotation is attempted again later.
+func (rf *RotatingFile) rotate() error {
+	err := rf.f.Close()
+	if err == nil {
+		err = rf.shift()
+	}
+	if err != nil {
+		if oerr := rf.open(); oerr != nil {
+			return fmt.Errorf("%s (also failed reopening %s: %s)", err, rf.Path, oerr)
+		}
+		return err
+	}
+	return rf.open()
+}
+
+// shift renames each rotated file to the next, discarding the oldest, and
+// Path to the newest.
+func (rf *RotatingFile) shift() error {
+	suffix := ""
+	if rf.Rotation.Gzip {
+		suffix = ".gz"
+	}
+	rotated := func(i int) string {
+		return fmt.Sprintf("%s.%d%s", rf.Path, i, suffix)
+	}
+	os.Remove(rotated(rf.Rotation.Keep))
+	for i := rf.Rotation.Keep - 1; i >= 1; i-- {
+		if err := os.Rename(rotated(i), rotated(i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if rf.Rotation.Keep < 1 {
+		if err := os.Remove(rf.Path); err != nil {
+			return err
+		}
+	} else if rf.Rotation.Gzip {
+		if err := gzipFile(rf.Path, rotated(1)); err != nil {
+			return err
+		}
+	} else if err := os.Rename(rf.Path, rotated(1)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// gzipFile compresses src to dst and removes src.
+func gzipFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(out)
+	if _, err := io.Copy(gz, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
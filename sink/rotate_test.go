@@ -0,0 +1,49 @@
+package sink
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRotatingFileReopensAfterFailedRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "readings.log")
+	// a non-empty directory in place of path.2 makes the second rotation
+	// fail to shift path.1 along
+	blocker := path + ".2"
+	if err := os.MkdirAll(filepath.Join(blocker, "x"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	rf, err := OpenRotatingFile(path, FileRotation{MaxSize: 10, Keep: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rf.Close()
+
+	for _, line := range []string{"first\n", "second\n"} {
+		if _, err := rf.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := rf.Write([]byte("third\n")); err == nil {
+		t.Fatal("rotating over a directory succeeded")
+	}
+
+	if err := os.RemoveAll(blocker); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rf.Write([]byte("fourth\n")); err != nil {
+		t.Fatalf("write after a failed rotation: %s", err)
+	}
+	for name, want := range map[string]string{path: "fourth\n", path + ".1": "second\n", path + ".2": "first\n"} {
+		b, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(b); !strings.Contains(got, want) {
+			t.Errorf("%s contains %q, want %q", filepath.Base(name), got, want)
+		}
+	}
+}
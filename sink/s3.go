@@ -0,0 +1,221 @@
+package sink
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// ArchiveFormat selects the format of the objects S3Sink uploads.
+type ArchiveFormat string
+
+const (
+	// ArchiveFormatNDJSON uploads gzip-compressed NDJSON, with every field
+	// of each reading.
+	ArchiveFormatNDJSON ArchiveFormat = "ndjson"
+	// ArchiveFormatParquet uploads Snappy-compressed Parquet, with the
+	// columns of STDOUT_CSV_HEADER, for querying with Athena or Spark.
+	ArchiveFormatParquet ArchiveFormat = "parquet"
+)
+
+func ParseArchiveFormat(s string) (ArchiveFormat, error) {
+	switch f := ArchiveFormat(s); f {
+	case ArchiveFormatNDJSON, ArchiveFormatParquet:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown format %q; must be one of ndjson, parquet", s)
+}
+
+// S3Sink accumulates readings and periodically uploads them to S3,
+// partitioned by sensor serial and measurement date:
+//
+//	<prefix>/serial=<serial>/date=<yyyy-mm-dd>/<flush time>.ndjson.gz
+//
+// or, in ArchiveFormatParquet, <flush time>.parquet.
+//
+// Readings are flushed once FlushInterval has elapsed since the last flush,
+// and on Close. Readings that fail to upload are retried on the next flush.
+// With FlushAlign, readings are instead flushed at each multiple of
+// FlushInterval in wall-clock time, so that every device's objects cover the
+// same periods. Flushes happen on a timer as well as on submission, so the
+// last readings before a sensor goes quiet are not held back indefinitely.
+type S3Sink struct {
+	Client        *s3.Client
+	Bucket        string
+	Prefix        string
+	FlushInterval time.Duration
+
+	// Format is the format of uploaded objects. If empty,
+	// ArchiveFormatNDJSON is used.
+	Format ArchiveFormat
+
+	// FlushAlign aligns flushes to multiples of FlushInterval.
+	FlushAlign bool
+
+	mu         sync.Mutex
+	partitions map[s3Partition][]*Reading
+	lastFlush  time.Time
+
+	// flushErr is the error of the last flush made by the timer, returned
+	// by the next Submit.
+	flushErr error
+
+	startTimer sync.Once
+	done       chan struct{}
+	closeOnce  sync.Once
+}
+
+type s3Partition struct {
+	serial string
+	date   string
+}
+
+func NewS3Sink(bucket, prefix string, flushInterval time.Duration) (*S3Sink, error) {
+	cfg, err := awsConfig()
+	if err != nil {
+		return nil, err
+	}
+	return &S3Sink{
+		Client:        s3.NewFromConfig(cfg),
+		Bucket:        bucket,
+		Prefix:        prefix,
+		FlushInterval: flushInterval,
+		partitions:    map[s3Partition][]*Reading{},
+		lastFlush:     time.Now(),
+		done:          make(chan struct{}),
+	}, nil
+}
+
+func (s *S3Sink) Name() string {
+	return "s3"
+}
+
+func (s *S3Sink) Submit(ctx context.Context, r *Reading) error {
+	s.startTimer.Do(func() {
+		go s.flushOnTimer()
+	})
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// readings of the period that has just ended are flushed before r is
+	// added, so that r is uploaded with the readings of its own period
+	var err error
+	if s.flushDue(time.Now()) {
+		err = s.flush(ctx)
+	}
+	p := s3Partition{
+		serial: r.SensorSerialNumber,
+		date:   r.MeasurementTime.UTC().Format("2006-01-02"),
+	}
+	s.partitions[p] = append(s.partitions[p], r)
+	if err == nil {
+		err, s.flushErr = s.flushErr, nil
+	}
+	return err
+}
+
+func (s *S3Sink) flushDue(now time.Time) bool {
+	if s.FlushAlign {
+		return now.Truncate(s.FlushInterval).After(s.lastFlush)
+	}
+	return now.Sub(s.lastFlush) >= s.FlushInterval
+}
+
+// nextFlush returns the time at which the next flush is due.
+func (s *S3Sink) nextFlush() time.Time {
+	if s.FlushAlign {
+		return s.lastFlush.Truncate(s.FlushInterval).Add(s.FlushInterval)
+	}
+	return s.lastFlush.Add(s.FlushInterval)
+}
+
+// flushOnTimer flushes readings whenever a flush falls due until the sink is
+// closed.
+func (s *S3Sink) flushOnTimer() {
+	s.mu.Lock()
+	timer := time.NewTimer(time.Until(s.nextFlush()))
+	s.mu.Unlock()
+	defer timer.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-timer.C:
+		}
+		s.mu.Lock()
+		if s.flushDue(time.Now()) {
+			s.flushErr = s.flush(context.TODO())
+		}
+		next := s.nextFlush()
+		s.mu.Unlock()
+		timer.Reset(time.Until(next))
+	}
+}
+
+func (s *S3Sink) flush(ctx context.Context) error {
+	flushTime := time.Now().UTC()
+	s.lastFlush = flushTime
+	var lastErr error
+	for p, readings := range s.partitions {
+		encode, extension, contentType := encodeNDJSONGzip, ".ndjson.gz", "application/gzip"
+		if s.Format == ArchiveFormatParquet {
+			encode, extension, contentType = encodeParquet, ".parquet", "application/vnd.apache.parquet"
+		}
+		body, err := encode(readings)
+		if err != nil {
+			return err
+		}
+		key := path.Join(
+			s.Prefix,
+			"serial="+p.serial,
+			"date="+p.date,
+			flushTime.Format("20060102T150405Z")+extension,
+		)
+		_, err = s.Client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket:      &s.Bucket,
+			Key:         &key,
+			Body:        bytes.NewReader(body),
+			ContentType: aws.String(contentType),
+		})
+		if err != nil {
+			lastErr = fmt.Errorf("failed uploading s3://%s/%s: %s", s.Bucket, key, err)
+			continue
+		}
+		delete(s.partitions, p)
+	}
+	return lastErr
+}
+
+func (s *S3Sink) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.done)
+	})
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.partitions) == 0 {
+		return nil
+	}
+	return s.flush(context.TODO())
+}
+
+func encodeNDJSONGzip(readings []*Reading) ([]byte, error) {
+	var b bytes.Buffer
+	w := gzip.NewWriter(&b)
+	for _, r := range readings {
+		line, err := encodeJSON(r)
+		if err != nil {
+			return nil, err
+		}
+		w.Write(append(line, '\n'))
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
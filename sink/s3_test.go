@@ -0,0 +1,110 @@
+package sink
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// fakeS3 is an HTTP client that accepts every PutObject request and records
+// the number of lines in each object uploaded.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects []int
+}
+
+func (c *fakeS3) Do(req *http.Request) (*http.Response, error) {
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	r, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	ndjson, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.objects = append(c.objects, strings.Count(string(ndjson), "\n"))
+	c.mu.Unlock()
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{},
+		Body:       ioutil.NopCloser(strings.NewReader("")),
+	}, nil
+}
+
+func (c *fakeS3) uploaded() []int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]int(nil), c.objects...)
+}
+
+func newFakeS3Sink(fake *fakeS3, flushInterval time.Duration) *S3Sink {
+	client := s3.New(s3.Options{
+		Region:     "us-east-1",
+		HTTPClient: fake,
+		Credentials: aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+			return aws.Credentials{AccessKeyID: "test", SecretAccessKey: "test"}, nil
+		}),
+	})
+	return &S3Sink{
+		Client:        client,
+		Bucket:        "test",
+		FlushInterval: flushInterval,
+		partitions:    map[s3Partition][]*Reading{},
+		lastFlush:     time.Now(),
+		done:          make(chan struct{}),
+	}
+}
+
+func TestS3SinkFlushesBeforeAppending(t *testing.T) {
+	fake := &fakeS3{}
+	s := newFakeS3Sink(fake, time.Hour)
+	if err := s.Submit(context.Background(), testReading(true)); err != nil {
+		t.Fatal(err)
+	}
+	// the period of the first reading ends before the second is submitted
+	s.mu.Lock()
+	s.lastFlush = time.Now().Add(-2 * time.Hour)
+	s.mu.Unlock()
+	if err := s.Submit(context.Background(), testReading(true)); err != nil {
+		t.Fatal(err)
+	}
+	if got := fake.uploaded(); len(got) != 1 || got[0] != 1 {
+		t.Fatalf("uploaded objects of %v readings, want the first reading alone", got)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := fake.uploaded(); len(got) != 2 || got[1] != 1 {
+		t.Errorf("uploaded objects of %v readings, want the second reading flushed on Close", got)
+	}
+}
+
+func TestS3SinkFlushesOnTimer(t *testing.T) {
+	fake := &fakeS3{}
+	s := newFakeS3Sink(fake, 50*time.Millisecond)
+	defer s.Close()
+	if err := s.Submit(context.Background(), testReading(true)); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(fake.uploaded()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := fake.uploaded(); len(got) != 1 || got[0] != 1 {
+		t.Errorf("uploaded objects of %v readings, want the reading flushed without another submission", got)
+	}
+}
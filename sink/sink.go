@@ -0,0 +1,180 @@
+package sink
+
+// This package submits air quality readings to metrics and messaging
+// backends.
+
+import (
+	"context"
+	"time"
+
+	"github.com/jkoelndorfer/aqgo/iotco1000"
+)
+
+// Reading is a measurement along with the state needed to submit it.
+type Reading struct {
+	*iotco1000.AirQualityMeasurement
+
+	// Samples holds the individual readings that were averaged to produce
+	// the measurement.
+	Samples []*iotco1000.AirQualityMeasurement
+
+	// SensorWarmedUp reports whether the sensor has been active long enough
+	// for its readings to be trusted.
+	SensorWarmedUp bool
+
+	// WarmUpCompleted is set on the first reading for which the sensor is
+	// warmed up after having been seen warming up, i.e. once per session and
+	// again after each reset.
+	WarmUpCompleted bool
+
+	// PollOverrun is set when taking the reading took longer than the poll
+	// interval, so that polling cannot keep to it.
+	PollOverrun bool
+
+	// LastCalibration is when the sensor was last zero calibrated, or the
+	// zero time if that is not known.
+	LastCalibration time.Time
+
+	// Stale is set on a reading that repeats the last good reading, with
+	// the time of a read that failed, so that held values can be told
+	// apart from measurements.
+	Stale bool
+
+	// HealthScore, if set, scores the health of the device from 0 to 100,
+	// combining its recent read success rate, warm-up status and whether
+	// the reading is stale.
+	HealthScore *float64
+
+	// DeviceAttached is set on the first reading taken after the serial
+	// device was attached again, having been detached or replaced.
+	DeviceAttached bool
+
+	// DuplicateSerial is set on readings from a sensor that reports the same
+	// serial number as another sensor being read at the same time.
+	DuplicateSerial bool
+
+	// Calibrated is set on the first reading taken after the sensor was zero
+	// calibrated.
+	Calibrated bool
+
+	// Startup is set on the first reading submitted after startup, when a
+	// startup event was requested.
+	Startup bool
+
+	// DroppedReadings is the number of readings dropped from the submission
+	// queue, or from the queue of any sink of a Fanout, because it was full,
+	// since this reading's predecessor was queued.
+	DroppedReadings int
+
+	// ChannelBacklog is the number of readings that were waiting to be
+	// submitted when this reading was queued. A growing backlog means that
+	// submissions are not keeping up with readings.
+	ChannelBacklog int
+
+	// ConsecutiveSuccesses is the number of readings in a row, including
+	// this one, that were taken without error.
+	ConsecutiveSuccesses int
+
+	// Provenance, if set, records how the reading was derived.
+	Provenance *Provenance
+
+	// Location, if set, names where the sensor is installed.
+	Location string
+
+	// Environment, if set, names the deployment the reading comes from,
+	// e.g. dev or prod.
+	Environment string
+
+	// COStatistics, if set, describes the recent volatility of the sensor's
+	// CO readings.
+	COStatistics *COStatistics
+
+	// CODeltaFromReference, if set, is the CO concentration in ppb less
+	// that measured by a reference monitor.
+	CODeltaFromReference *float64
+
+	// COPercentiles, if set, are percentiles of the sensor's CO readings
+	// over a recent window, including this one.
+	COPercentiles []COPercentile
+}
+
+// COPercentile is a percentile, from 0 to 100, of a sensor's recent CO
+// concentrations in ppb.
+type COPercentile struct {
+	Percentile float64
+	Value      float64
+}
+
+// COStatistics describes a reading's CO concentration relative to the
+// sensor's recent readings.
+type COStatistics struct {
+	// Variance is the moving variance of the CO concentration, in ppb
+	// squared, including this reading.
+	Variance float64
+
+	// ZScore is the number of standard deviations by which this reading
+	// differs from the moving mean of the readings before it.
+	ZScore float64
+}
+
+// Provenance records how a reading was derived from the sensor's responses,
+// for auditing data quality.
+type Provenance struct {
+	// RawResponses are the responses the reading was parsed from, one per
+	// sample, if the sensor keeps them.
+	RawResponses []string `json:"raw_responses,omitempty"`
+
+	// Aggregated is set if the reading is the average of more than one
+	// sample.
+	Aggregated bool `json:"aggregated"`
+
+	// Calibrated is set if the sensor was zero calibrated just before the
+	// reading was taken.
+	Calibrated bool `json:"calibrated"`
+
+	// Retries is the number of malformed responses that were retried while
+	// taking the reading.
+	Retries int `json:"retries"`
+
+	// Keepalive is set if the reading was submitted only because the
+	// keepalive interval elapsed without a change.
+	Keepalive bool `json:"keepalive,omitempty"`
+}
+
+// A MetricSink submits readings to a backend.
+type MetricSink interface {
+	// Name identifies the sink in log messages.
+	Name() string
+	Submit(ctx context.Context, r *Reading) error
+	Close() error
+}
+
+// A ReadFailureSink is a MetricSink that also records failures to take a
+// reading. category is as returned by iotco1000.FailureCategory.
+type ReadFailureSink interface {
+	MetricSink
+	SubmitReadFailure(ctx context.Context, category string, t time.Time) error
+}
+
+// A Checker is a MetricSink that can verify that it is able to submit
+// readings, without submitting one.
+type Checker interface {
+	MetricSink
+	Check(ctx context.Context) error
+}
+
+// A DeviceDetachSink is a MetricSink that also records the serial device
+// being detached. Its reattachment is marked on the next reading, by
+// DeviceAttached.
+type DeviceDetachSink interface {
+	MetricSink
+	SubmitDeviceDetached(ctx context.Context, t time.Time) error
+}
+
+// A SinkResultSink is a MetricSink that also records the outcome of submitting
+// a reading to each sink of a Fanout. results holds, by sink name, the error
+// returned by the sink, or nil if the submission succeeded.
+type SinkResultSink interface {
+	MetricSink
+	SubmitSinkResults(ctx context.Context, results map[string]error, t time.Time) error
+}
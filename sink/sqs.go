@@ -0,0 +1,138 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// SQS_MAX_BATCH_SIZE is the maximum number of messages accepted by a single
+// SendMessageBatch call.
+const SQS_MAX_BATCH_SIZE = 10
+
+// SQSSink sends each reading as a JSON message to an SQS queue. Readings are
+// buffered and sent in batches of up to SQS_MAX_BATCH_SIZE messages; a partial
+// batch is sent once its oldest reading is older than MaxBatchDelay.
+type SQSSink struct {
+	Client        *sqs.Client
+	QueueURL      string
+	MaxBatchDelay time.Duration
+	MaxAttempts   int
+
+	pending []sqstypes.SendMessageBatchRequestEntry
+	oldest  time.Time
+}
+
+func NewSQSSink(queueURL string, maxBatchDelay time.Duration) (*SQSSink, error) {
+	cfg, err := awsConfig()
+	if err != nil {
+		return nil, err
+	}
+	return &SQSSink{
+		Client:        sqs.NewFromConfig(cfg),
+		QueueURL:      queueURL,
+		MaxBatchDelay: maxBatchDelay,
+		MaxAttempts:   3,
+	}, nil
+}
+
+func (s *SQSSink) Name() string {
+	return "sqs"
+}
+
+func (s *SQSSink) Submit(ctx context.Context, r *Reading) error {
+	body, err := encodeJSON(r)
+	if err != nil {
+		return err
+	}
+	if len(s.pending) == 0 {
+		s.oldest = time.Now()
+	}
+	s.pending = append(s.pending, sqstypes.SendMessageBatchRequestEntry{
+		MessageBody: aws.String(string(body)),
+		MessageAttributes: map[string]sqstypes.MessageAttributeValue{
+			"SensorSerialNumber": {
+				DataType:    aws.String("String"),
+				StringValue: aws.String(r.SensorSerialNumber),
+			},
+			"MeasurementTime": {
+				DataType:    aws.String("String"),
+				StringValue: aws.String(r.MeasurementTime.Format(time.RFC3339Nano)),
+			},
+		},
+	})
+	if len(s.pending) < SQS_MAX_BATCH_SIZE && time.Since(s.oldest) < s.MaxBatchDelay {
+		return nil
+	}
+	return s.flush(ctx)
+}
+
+// flush sends all pending messages. Entries that fail, or the whole batch if
+// the request itself fails, are retried up to MaxAttempts times before being
+// dropped.
+func (s *SQSSink) flush(ctx context.Context) error {
+	entries := s.pending
+	s.pending = nil
+	for i := range entries {
+		entries[i].Id = aws.String(strconv.Itoa(i))
+	}
+
+	var failures []string
+	for attempt := 1; len(entries) > 0; attempt++ {
+		out, err := s.Client.SendMessageBatch(ctx, &sqs.SendMessageBatchInput{
+			QueueUrl: &s.QueueURL,
+			Entries:  entries,
+		})
+		failures = failures[:0]
+		if err != nil {
+			failures = append(failures, err.Error())
+		} else {
+			failed := map[string]bool{}
+			for _, f := range out.Failed {
+				failed[aws.ToString(f.Id)] = true
+				failures = append(failures, fmt.Sprintf("%s: %s", aws.ToString(f.Code), aws.ToString(f.Message)))
+			}
+			retry := entries[:0]
+			for _, e := range entries {
+				if failed[aws.ToString(e.Id)] {
+					retry = append(retry, e)
+				}
+			}
+			entries = retry
+		}
+		if len(entries) == 0 {
+			break
+		}
+		if attempt >= s.MaxAttempts {
+			return fmt.Errorf("dropped %d message(s) taken at %s after %d attempts: %s", len(entries), measurementTimes(entries), attempt, strings.Join(failures, "; "))
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("dropped %d message(s) taken at %s after %d attempts: %s", len(entries), measurementTimes(entries), attempt, ctx.Err())
+		case <-time.After(time.Duration(attempt) * 100 * time.Millisecond):
+		}
+	}
+	return nil
+}
+
+// measurementTimes lists the measurement times of the readings in entries.
+func measurementTimes(entries []sqstypes.SendMessageBatchRequestEntry) string {
+	times := []string{}
+	for _, e := range entries {
+		times = append(times, aws.ToString(e.MessageAttributes["MeasurementTime"].StringValue))
+	}
+	return strings.Join(times, ", ")
+}
+
+func (s *SQSSink) Close() error {
+	if len(s.pending) == 0 {
+		return nil
+	}
+	return s.flush(context.TODO())
+}
@@ -0,0 +1,63 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+)
+
+// flakySQS is an HTTP client that fails the first failures
+// SendMessageBatch requests outright, then accepts every message.
+type flakySQS struct {
+	failures int
+	requests int
+}
+
+func (c *flakySQS) Do(req *http.Request) (*http.Response, error) {
+	c.requests++
+	if c.requests <= c.failures {
+		return nil, errors.New("connection reset by peer")
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"text/xml"}},
+		Body:       ioutil.NopCloser(strings.NewReader(`<SendMessageBatchResponse><SendMessageBatchResult><SendMessageBatchResultEntry><Id>0</Id><MessageId>1</MessageId><MD5OfMessageBody>0</MD5OfMessageBody></SendMessageBatchResultEntry></SendMessageBatchResult><ResponseMetadata><RequestId>1</RequestId></ResponseMetadata></SendMessageBatchResponse>`)),
+	}, nil
+}
+
+func newFlakySQSSink(fake *flakySQS) *SQSSink {
+	client := sqs.New(sqs.Options{
+		Region:     "us-east-1",
+		HTTPClient: fake,
+		Retryer:    aws.NopRetryer{},
+		Credentials: aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+			return aws.Credentials{AccessKeyID: "test", SecretAccessKey: "test"}, nil
+		}),
+	})
+	return &SQSSink{Client: client, QueueURL: "https://sqs.us-east-1.amazonaws.com/1/test", MaxAttempts: 3}
+}
+
+func TestSQSSinkRetriesBatchAfterTransportError(t *testing.T) {
+	fake := &flakySQS{failures: 1}
+	s := newFlakySQSSink(fake)
+	if err := s.Submit(context.Background(), testReading(true)); err != nil {
+		t.Fatal(err)
+	}
+	if fake.requests != 2 {
+		t.Errorf("%d requests made, want the batch sent again after the transport error", fake.requests)
+	}
+
+	fake = &flakySQS{failures: 3}
+	s = newFlakySQSSink(fake)
+	err := s.Submit(context.Background(), testReading(true))
+	if want := testReading(true).MeasurementTime.Format(time.RFC3339Nano); err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("Submit() = %v after every attempt failed, want an error naming the reading taken at %s", err, want)
+	}
+}
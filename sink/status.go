@@ -0,0 +1,141 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// StatusSink keeps a summary of the daemon's health, assembled from the
+// readings, read failures and sink results submitted to it, and serves it as
+// JSON. It is an http.Handler. Fields that are not known yet, such as those
+// of the last reading before the first is taken, are null.
+type StatusSink struct {
+	// Version is reported as is.
+	Version string
+
+	// WarmUpDuration is the warm up duration, from which warm up progress
+	// is computed.
+	WarmUpDuration time.Duration
+
+	mu                sync.Mutex
+	lastReading       *Reading
+	consecutiveErrors int
+	reconnects        int
+	sinks             map[string]*sinkStatus
+}
+
+type sinkStatus struct {
+	LastSuccess *time.Time `json:"last_success"`
+	Errors      int        `json:"errors"`
+	LastError   *string    `json:"last_error"`
+}
+
+type status struct {
+	Version           string                 `json:"version"`
+	LastReadingTime   *time.Time             `json:"last_reading_time"`
+	LastReading       *jsonReading           `json:"last_reading"`
+	WarmedUp          *bool                  `json:"warmed_up"`
+	WarmUpProgress    *float64               `json:"warm_up_progress"`
+	ConsecutiveErrors int                    `json:"consecutive_errors"`
+	Reconnects        int                    `json:"reconnects"`
+	Sinks             map[string]*sinkStatus `json:"sinks"`
+}
+
+func NewStatusSink(version string, warmUpDuration time.Duration) *StatusSink {
+	return &StatusSink{
+		Version:        version,
+		WarmUpDuration: warmUpDuration,
+		sinks:          map[string]*sinkStatus{},
+	}
+}
+
+func (s *StatusSink) Name() string {
+	return "status"
+}
+
+func (s *StatusSink) Submit(ctx context.Context, r *Reading) error {
+	if r.Stale {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastReading = r
+	s.consecutiveErrors = 0
+	if r.DeviceAttached {
+		s.reconnects++
+	}
+	return nil
+}
+
+func (s *StatusSink) SubmitReadFailure(ctx context.Context, category string, t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.consecutiveErrors++
+	return nil
+}
+
+func (s *StatusSink) SubmitSinkResults(ctx context.Context, results map[string]error, t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name, err := range results {
+		if name == s.Name() {
+			continue
+		}
+		st, ok := s.sinks[name]
+		if !ok {
+			st = &sinkStatus{}
+			s.sinks[name] = st
+		}
+		if err != nil {
+			msg := err.Error()
+			st.Errors++
+			st.LastError = &msg
+			continue
+		}
+		t := t
+		st.LastSuccess = &t
+	}
+	return nil
+}
+
+func (s *StatusSink) status() *status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := &status{
+		Version:           s.Version,
+		ConsecutiveErrors: s.consecutiveErrors,
+		Reconnects:        s.reconnects,
+		Sinks:             map[string]*sinkStatus{},
+	}
+	for name, ss := range s.sinks {
+		copied := *ss
+		st.Sinks[name] = &copied
+	}
+	if r := s.lastReading; r != nil {
+		t := r.MeasurementTime
+		warmedUp := r.SensorWarmedUp
+		progress := 1.0
+		if s.WarmUpDuration > 0 && r.Uptime < s.WarmUpDuration {
+			progress = r.Uptime.Seconds() / s.WarmUpDuration.Seconds()
+		}
+		st.LastReadingTime = &t
+		st.LastReading = newJSONReading(r)
+		st.WarmedUp = &warmedUp
+		st.WarmUpProgress = &progress
+	}
+	return st
+}
+
+func (s *StatusSink) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(s.status())
+}
+
+func (s *StatusSink) Close() error {
+	return nil
+}
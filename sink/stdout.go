@@ -0,0 +1,191 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/jkoelndorfer/aqgo/iotco1000"
+)
+
+// StdoutFormat selects how StdoutSink writes readings.
+type StdoutFormat string
+
+const (
+	StdoutFormatText StdoutFormat = "text"
+	StdoutFormatJSON StdoutFormat = "json"
+	StdoutFormatCSV  StdoutFormat = "csv"
+)
+
+func ParseStdoutFormat(s string) (StdoutFormat, error) {
+	switch f := StdoutFormat(s); f {
+	case StdoutFormatText, StdoutFormatJSON, StdoutFormatCSV:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown format %q; must be one of text, json, csv", s)
+}
+
+// STDOUT_CSV_HEADER is the header row written before the first reading in
+// StdoutFormatCSV.
+var STDOUT_CSV_HEADER = []string{
+	"measurement_time",
+	"sensor_serial_number",
+	"co_concentration_ppb",
+	"temperature_c",
+	"relative_humidity",
+	"uptime_seconds",
+	"sensor_warmed_up",
+	"location",
+}
+
+// StdoutSink writes one line per reading to Writer. Timestamps are displayed
+// in Location; this affects only their formatting, not the instant they
+// represent.
+type StdoutSink struct {
+	Writer   io.Writer
+	Format   StdoutFormat
+	Location *time.Location
+
+	// Pretty indents StdoutFormatJSON output for readability. Otherwise
+	// each reading is written as a single line of NDJSON.
+	Pretty bool
+
+	// path is the file written to, if the sink was created by NewFileSink.
+	path string
+	csv  *csv.Writer
+}
+
+func NewStdoutSink(format StdoutFormat, location *time.Location) *StdoutSink {
+	return &StdoutSink{
+		Writer:   os.Stdout,
+		Format:   format,
+		Location: location,
+	}
+}
+
+// NewFileSink returns a StdoutSink that writes to the file at path rather
+// than to standard output, rotating it as configured by rotation.
+func NewFileSink(path string, rotation FileRotation, format StdoutFormat, location *time.Location) (*StdoutSink, error) {
+	f, err := OpenRotatingFile(path, rotation)
+	if err != nil {
+		return nil, err
+	}
+	s := NewStdoutSink(format, location)
+	s.Writer = f
+	s.path = path
+	if format == StdoutFormatCSV {
+		// The header is written by f, at the start of each file, rather
+		// than once by the sink.
+		var header bytes.Buffer
+		w := csv.NewWriter(&header)
+		w.Write(STDOUT_CSV_HEADER)
+		w.Flush()
+		f.Header = header.Bytes()
+		if err := f.writeHeader(); err != nil {
+			f.Close()
+			return nil, err
+		}
+		s.csv = csv.NewWriter(f)
+	}
+	return s, nil
+}
+
+func (s *StdoutSink) Name() string {
+	if s.path != "" {
+		return "file:" + s.path
+	}
+	return "stdout"
+}
+
+func (s *StdoutSink) Submit(ctx context.Context, r *Reading) error {
+	measurementTime := r.MeasurementTime.In(s.Location)
+	switch s.Format {
+	case StdoutFormatJSON:
+		j := newJSONReading(r)
+		j.MeasurementTime = measurementTime
+		var line []byte
+		var err error
+		if s.Pretty {
+			line, err = json.MarshalIndent(j, "", "  ")
+		} else {
+			line, err = json.Marshal(j)
+		}
+		if err != nil {
+			return err
+		}
+		_, err = s.Writer.Write(append(line, '\n'))
+		return err
+	case StdoutFormatCSV:
+		if s.csv == nil {
+			s.csv = csv.NewWriter(s.Writer)
+			s.csv.Write(STDOUT_CSV_HEADER)
+		}
+		s.csv.Write([]string{
+			measurementTime.Format(time.RFC3339),
+			r.SensorSerialNumber,
+			csvField(r, iotco1000.FIELD_CO, float64(r.COConcentrationPPB)),
+			csvField(r, iotco1000.FIELD_TEMPERATURE, r.TemperatureCFloat),
+			csvField(r, iotco1000.FIELD_HUMIDITY, r.RelativeHumidityFloat),
+			strconv.FormatFloat(r.Uptime.Seconds(), 'f', -1, 64),
+			strconv.FormatBool(r.SensorWarmedUp),
+			r.Location,
+		})
+		s.csv.Flush()
+		return s.csv.Error()
+	}
+	line := fmt.Sprintf(
+		"%s sensor=%s%s uptime=%s warmed_up=%t",
+		measurementTime.Format(time.RFC3339),
+		r.SensorSerialNumber,
+		textFields(r),
+		r.Uptime,
+		r.SensorWarmedUp,
+	)
+	if r.Location != "" {
+		line += " location=" + r.Location
+	}
+	_, err := fmt.Fprintln(s.Writer, line)
+	return err
+}
+
+// csvField formats v, the value of field in r, leaving it empty if field could
+// not be parsed.
+func csvField(r *Reading, field string, v float64) string {
+	if !r.FieldValid(field) {
+		return ""
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// textFields formats the CO concentration, temperature and humidity of r as
+// key=value pairs, each preceded by a space, omitting those that could not be
+// parsed.
+func textFields(r *Reading) string {
+	s := ""
+	for _, f := range []struct {
+		key, field string
+		value      float64
+	}{
+		{"co_ppb", iotco1000.FIELD_CO, float64(r.COConcentrationPPB)},
+		{"temperature_c", iotco1000.FIELD_TEMPERATURE, r.TemperatureCFloat},
+		{"relative_humidity", iotco1000.FIELD_HUMIDITY, r.RelativeHumidityFloat},
+	} {
+		if r.FieldValid(f.field) {
+			s += " " + f.key + "=" + strconv.FormatFloat(f.value, 'f', -1, 64)
+		}
+	}
+	return s
+}
+
+func (s *StdoutSink) Close() error {
+	if c, ok := s.Writer.(io.Closer); ok && s.path != "" {
+		return c.Close()
+	}
+	return nil
+}
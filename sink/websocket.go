@@ -0,0 +1,104 @@
+package sink
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// WEBSOCKET_CLIENT_BUFFER is the number of readings queued for a WebSocket
+// client. Clients that fall further behind are disconnected.
+const WEBSOCKET_CLIENT_BUFFER = 16
+
+// WebSocketSink streams each submitted reading as JSON to every connected
+// WebSocket client. It is an http.Handler that upgrades requests to
+// WebSocket connections.
+type WebSocketSink struct {
+	upgrader websocket.Upgrader
+	mu       sync.Mutex
+	clients  map[*websocket.Conn]chan []byte
+}
+
+func NewWebSocketSink() *WebSocketSink {
+	return &WebSocketSink{
+		clients: map[*websocket.Conn]chan []byte{},
+	}
+}
+
+func (s *WebSocketSink) Name() string {
+	return "websocket"
+}
+
+func (s *WebSocketSink) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	ch := make(chan []byte, WEBSOCKET_CLIENT_BUFFER)
+	s.mu.Lock()
+	s.clients[conn] = ch
+	s.mu.Unlock()
+
+	// Reads are only needed to process control frames and notice when the
+	// client goes away.
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				s.drop(conn)
+				return
+			}
+		}
+	}()
+
+	for msg := range ch {
+		conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		if err := conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+			s.drop(conn)
+			break
+		}
+	}
+	conn.Close()
+}
+
+// drop disconnects a client. It must not be called with s.mu held.
+func (s *WebSocketSink) drop(conn *websocket.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dropLocked(conn)
+}
+
+func (s *WebSocketSink) dropLocked(conn *websocket.Conn) {
+	if ch, ok := s.clients[conn]; ok {
+		delete(s.clients, conn)
+		close(ch)
+	}
+}
+
+func (s *WebSocketSink) Submit(ctx context.Context, r *Reading) error {
+	msg, err := encodeJSON(r)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn, ch := range s.clients {
+		select {
+		case ch <- msg:
+		default:
+			s.dropLocked(conn)
+		}
+	}
+	return nil
+}
+
+func (s *WebSocketSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.clients {
+		s.dropLocked(conn)
+	}
+	return nil
+}